@@ -1,49 +1,129 @@
+create table games
+(
+    id   int auto_increment
+        primary key,
+    slug varchar(16) not null,
+    name varchar(64) not null,
+    constraint games_slug_uindex
+        unique (slug)
+);
+
+insert into games (slug, name)
+values ('apex', 'Apex Legends'),
+       ('valorant', 'Valorant'),
+       ('cs2', 'Counter-Strike 2');
+
 create table legends
 (
     id         int auto_increment
         primary key,
-    name       varchar(30) null,
-    card_image varchar(50) null
+    name       varchar(30)   null,
+    card_image varchar(50)   null,
+    game       int default 1 not null,
+    constraint legends_games_id_fk
+        foreign key (game) references games (id)
 );
 
 create table maps
 (
     id         int auto_increment
         primary key,
-    name       varchar(30)  null,
-    card_image varchar(70)  null,
-    als_name   varchar(100) null
+    name       varchar(30)   null,
+    card_image varchar(70)   null,
+    als_name   varchar(100)  null,
+    game       int default 1 not null,
+    constraint maps_games_id_fk
+        foreign key (game) references games (id)
 );
 
 create table tags
 (
-    id   int auto_increment
+    id       int auto_increment
         primary key,
-    name varchar(32) null
+    name     varchar(32) collate utf8mb4_0900_ai_ci null,
+    color    char(7)                                null,
+    category varchar(32)                            null,
+    constraint tags_name_uindex
+        unique (name)
 );
 
 create table users
 (
-    id            int auto_increment
+    id                     int auto_increment
         primary key,
-    name          varchar(32) null,
-    apex_username varchar(32) null,
-    apex_uid      varchar(32) null
+    name                   varchar(32)                                         null,
+    apex_username          varchar(32)                                         null,
+    apex_uid               varchar(32)                                         null,
+    username               varchar(32)                                         null,
+    password_hash          char(60)                                            null,
+    credentials_changed_at timestamp                                           null,
+    role                   enum ('admin', 'member', 'viewer') default 'member' not null,
+    default_visibility     enum ('private', 'group', 'public') default 'group' not null,
+    constraint users_username_uindex
+        unique (username)
 );
 
+create table api_tokens
+(
+    id           int auto_increment
+        primary key,
+    user_id      int                                 not null,
+    name         varchar(64)                         not null,
+    token_hash   char(64)                            not null,
+    created_at   timestamp default CURRENT_TIMESTAMP not null,
+    last_used_at timestamp                           null,
+    expires_at   timestamp                           null,
+    constraint api_tokens_token_hash_uindex
+        unique (token_hash),
+    constraint api_tokens_users_id_fk
+        foreign key (user_id) references users (id)
+);
+
+create table user_game_accounts
+(
+    user_id      int         not null,
+    game_id      int         not null,
+    account_name varchar(64) not null,
+    account_uid  varchar(64) not null,
+    primary key (user_id, game_id),
+    constraint user_game_accounts_games_id_fk
+        foreign key (game_id) references games (id),
+    constraint user_game_accounts_users_id_fk
+        foreign key (user_id) references users (id)
+);
+
+create index user_game_accounts_game_id_account_uid_index
+    on user_game_accounts (game_id, account_uid);
+
 create table clips
 (
     id                  int auto_increment
         primary key,
-    owner_id            int                     not null,
-    filename            varchar(128)            not null,
-    is_processed        tinyint(1) default 0    not null,
-    created_at          timestamp               not null,
-    duration            int                     null,
-    map                 int                     null,
-    game_mode           enum ('pubs', 'ranked') null,
-    legend              int                     null,
-    match_history_found tinyint(1) default 0    not null,
+    owner_id            int                                                                 not null,
+    filename            varchar(128)                                                        not null,
+    is_processed        tinyint(1) default 0                                                not null,
+    created_at          timestamp                                                           not null,
+    duration            int                                                                 null,
+    map                 int                                                                 null,
+    game_mode           varchar(32)                                                         null,
+    legend              int                                                                 null,
+    match_history_found tinyint(1) default 0                                                not null,
+    ranked_image        varchar(256)                                                        null,
+    ranked_point_gain   int                                                                 null,
+    game                int default 1                                                       not null,
+    title               varchar(128) default ''                                             not null,
+    description         varchar(2000) default ''                                            not null,
+    visibility          enum ('private', 'group', 'public') default 'group'                 not null,
+    original_filename   varchar(128) default ''                                             not null,
+    content_hash        char(64)                                                            null,
+    created_at_source   enum ('container', 'filename', 'client', 'upload') default 'client' not null,
+    storage_tier        enum ('hot', 'cold') default 'cold'                                 not null,
+    constraint clips_content_hash_uindex
+        unique (content_hash),
+    constraint clips_filename_uindex
+        unique (filename),
+    constraint clips_games_id_fk
+        foreign key (game) references games (id),
     constraint clips_legend_id_fk
         foreign key (legend) references legends (id),
     constraint clips_maps_id_fk
@@ -52,6 +132,43 @@ create table clips
         foreign key (owner_id) references users (id)
 );
 
+create table seasons
+(
+    id         int auto_increment
+        primary key,
+    name       varchar(32) not null,
+    started_at datetime    not null,
+    ended_at   datetime    null
+);
+
+insert into seasons (name, started_at, ended_at)
+values ('Season 1: Wild Frontier', '2019-03-19 17:00:00', '2019-07-02 17:00:00'),
+       ('Season 2: Battle Charge', '2019-07-02 17:00:00', '2019-10-01 17:00:00'),
+       ('Season 3: Meltdown', '2019-10-01 17:00:00', '2020-02-04 17:00:00'),
+       ('Season 4: Assimilation', '2020-02-04 17:00:00', '2020-05-12 17:00:00'),
+       ('Season 5: Fortune''s Favor', '2020-05-12 17:00:00', '2020-08-18 17:00:00'),
+       ('Season 6: Boosted', '2020-08-18 17:00:00', '2020-11-04 17:00:00'),
+       ('Season 7: Ascension', '2020-11-04 17:00:00', '2021-02-02 17:00:00'),
+       ('Season 8: Mayhem', '2021-02-02 17:00:00', '2021-05-04 17:00:00'),
+       ('Season 9: Legacy', '2021-05-04 17:00:00', '2021-08-03 17:00:00'),
+       ('Season 10: Emergence', '2021-08-03 17:00:00', '2021-11-02 17:00:00'),
+       ('Season 11: Escape', '2021-11-02 17:00:00', '2022-02-08 17:00:00'),
+       ('Season 12: Defiance', '2022-02-08 17:00:00', '2022-05-10 17:00:00'),
+       ('Season 13: Saviors', '2022-05-10 17:00:00', '2022-08-09 17:00:00'),
+       ('Season 14: Hunted', '2022-08-09 17:00:00', '2022-11-01 17:00:00'),
+       ('Season 15: Eclipse', '2022-11-01 17:00:00', '2023-02-14 17:00:00'),
+       ('Season 16: Revelry', '2023-02-14 17:00:00', '2023-05-09 17:00:00'),
+       ('Season 17: Arsenal', '2023-05-09 17:00:00', '2023-08-08 17:00:00'),
+       ('Season 18: Resurrection', '2023-08-08 17:00:00', '2023-10-31 17:00:00'),
+       ('Season 19: Ignite', '2023-10-31 17:00:00', '2024-02-13 17:00:00'),
+       ('Season 20: Breakout', '2024-02-13 17:00:00', '2024-05-07 17:00:00'),
+       ('Season 21: Upheaval', '2024-05-07 17:00:00', '2024-08-06 17:00:00'),
+       ('Season 22: Shockwave', '2024-08-06 17:00:00', '2024-11-05 17:00:00'),
+       ('Season 23: From the Rift', '2024-11-05 17:00:00', '2025-02-11 17:00:00'),
+       ('Season 24: Takeover', '2025-02-11 17:00:00', '2025-05-06 17:00:00'),
+       ('Season 25: Prodigy', '2025-05-06 17:00:00', '2025-08-05 17:00:00'),
+       ('Season 26: Showdown', '2025-08-05 17:00:00', null);
+
 create table clips_queue
 (
     id            int auto_increment
@@ -79,14 +196,17 @@ create table clips_tags
 
 create table match_history
 (
-    id         int auto_increment
+    id                int auto_increment
         primary key,
-    user_id    int                     null,
-    game_start datetime                null,
-    game_end   datetime                null,
-    map        int                     null,
-    legend     int                     null,
-    game_mode  enum ('Pubs', 'Ranked') null,
+    user_id           int                     null,
+    game_start        datetime                null,
+    game_end          datetime                null,
+    map               int                     null,
+    legend            int                     null,
+    game_mode         enum ('Pubs', 'Ranked') null,
+    ranked_image      varchar(256)            null,
+    ranked_point_gain int                     null,
+    match_hash        varchar(64)             null,
     constraint match_history_legend_id_fk
         foreign key (legend) references legends (id),
     constraint match_history_maps_id_fk
@@ -95,3 +215,263 @@ create table match_history
         foreign key (user_id) references users (id)
 );
 
+create table match_groups
+(
+    id         int auto_increment
+        primary key,
+    map        int      null,
+    game_start datetime null,
+    game_end   datetime null,
+    constraint match_groups_maps_id_fk
+        foreign key (map) references maps (id)
+);
+
+create table match_group_members
+(
+    match_group_id   int not null,
+    match_history_id int not null
+        primary key,
+    constraint match_group_members_match_groups_id_fk
+        foreign key (match_group_id) references match_groups (id),
+    constraint match_group_members_match_history_id_fk
+        foreign key (match_history_id) references match_history (id)
+);
+
+create table sessions
+(
+    id         int auto_increment
+        primary key,
+    owner_id   int      not null,
+    started_at datetime not null,
+    ended_at   datetime not null,
+    constraint sessions_users_id_fk
+        foreign key (owner_id) references users (id)
+);
+
+create table collections
+(
+    id            int auto_increment
+        primary key,
+    owner_id      int                                 not null,
+    title         varchar(128)                        not null,
+    description   varchar(2000) default ''            not null,
+    cover_clip_id int                                 null,
+    is_public     tinyint(1) default 0                not null,
+    created_at    timestamp default CURRENT_TIMESTAMP not null,
+    constraint collections_clips_id_fk
+        foreign key (cover_clip_id) references clips (id),
+    constraint collections_users_id_fk
+        foreign key (owner_id) references users (id)
+);
+
+create table collection_clips
+(
+    collection_id int not null,
+    clip_id       int not null,
+    position      int not null,
+    primary key (collection_id, clip_id),
+    constraint collection_clips_clips_id_fk
+        foreign key (clip_id) references clips (id),
+    constraint collection_clips_collections_id_fk
+        foreign key (collection_id) references collections (id)
+);
+
+create table favorites
+(
+    user_id    int                                 not null,
+    clip_id    int                                 not null,
+    created_at timestamp default CURRENT_TIMESTAMP not null,
+    primary key (user_id, clip_id),
+    constraint favorites_clips_id_fk
+        foreign key (clip_id) references clips (id),
+    constraint favorites_users_id_fk
+        foreign key (user_id) references users (id)
+);
+
+create table clip_reactions
+(
+    user_id    int                                 not null,
+    clip_id    int                                 not null,
+    emoji      varchar(16) collate utf8mb4_bin     not null,
+    created_at timestamp default CURRENT_TIMESTAMP not null,
+    primary key (user_id, clip_id, emoji),
+    constraint clip_reactions_clips_id_fk
+        foreign key (clip_id) references clips (id),
+    constraint clip_reactions_users_id_fk
+        foreign key (user_id) references users (id)
+);
+
+create table clip_views
+(
+    id        int auto_increment
+        primary key,
+    clip_id   int                                 not null,
+    user_id   int                                 null,
+    address   varchar(45)                         not null,
+    viewed_at timestamp default CURRENT_TIMESTAMP not null,
+    constraint clip_views_clips_id_fk
+        foreign key (clip_id) references clips (id),
+    constraint clip_views_users_id_fk
+        foreign key (user_id) references users (id)
+);
+
+create table clip_comments
+(
+    id                int auto_increment
+        primary key,
+    clip_id           int                                 not null,
+    author_id         int                                 not null,
+    parent_id         int                                 null,
+    timestamp_seconds int                                 null,
+    body              varchar(2000)                       not null,
+    is_deleted        tinyint(1) default 0                not null,
+    created_at        timestamp default CURRENT_TIMESTAMP not null,
+    edited_at         timestamp                           null,
+    constraint clip_comments_clip_comments_id_fk
+        foreign key (parent_id) references clip_comments (id),
+    constraint clip_comments_clips_id_fk
+        foreign key (clip_id) references clips (id),
+    constraint clip_comments_users_id_fk
+        foreign key (author_id) references users (id)
+);
+
+create table clip_shares
+(
+    id         int auto_increment
+        primary key,
+    clip_id    int                                 not null,
+    created_by int                                 not null,
+    token      varchar(32)                         not null,
+    created_at timestamp default CURRENT_TIMESTAMP not null,
+    expires_at timestamp                           null,
+    max_views  int                                 null,
+    view_count int default 0                       not null,
+    constraint clip_shares_token_uindex
+        unique (token),
+    constraint clip_shares_clips_id_fk
+        foreign key (clip_id) references clips (id),
+    constraint clip_shares_users_id_fk
+        foreign key (created_by) references users (id)
+);
+
+create table uploads
+(
+    id              char(32)                            not null
+        primary key,
+    owner_id        int                                 not null,
+    game            int                                 not null,
+    filename        varchar(128)                        not null,
+    clip_created_at timestamp                           null,
+    timezone        varchar(64) default ''              not null,
+    length          bigint                              not null,
+    upload_offset   bigint    default 0                 not null,
+    hash_state      varbinary(256)                      null,
+    started_at      timestamp default CURRENT_TIMESTAMP not null,
+    expires_at      timestamp                           not null,
+    constraint uploads_games_id_fk
+        foreign key (game) references games (id),
+    constraint uploads_users_id_fk
+        foreign key (owner_id) references users (id)
+);
+
+create table reencode_jobs
+(
+    id                 int auto_increment
+        primary key,
+    clip_id            int                                            not null,
+    codec              varchar(16)                                    not null,
+    status             enum ('encoding', 'finished', 'kept', 'error') not null,
+    original_size      bigint                                         not null,
+    reencoded_size     bigint                                         null,
+    original_duration  double                                         null,
+    reencoded_duration double                                         null,
+    error_message      varchar(256)                                   null,
+    started_at         timestamp default CURRENT_TIMESTAMP            not null,
+    finished_at        timestamp                                      null,
+    constraint reencode_jobs_clips_id_fk
+        foreign key (clip_id) references clips (id)
+);
+
+create table ingested_files
+(
+    id           int auto_increment
+        primary key,
+    path         varchar(1024)                       not null,
+    size         bigint                              not null,
+    modified_at  datetime(6)                         not null,
+    content_hash char(64)                            not null,
+    clip_id      int                                 null,
+    ingested_at  timestamp default CURRENT_TIMESTAMP not null
+);
+
+create index sessions_owner_id_started_at_index
+    on sessions (owner_id, started_at);
+
+create index match_history_user_id_game_start_index
+    on match_history (user_id, game_start);
+
+create index clips_owner_id_created_at_index
+    on clips (owner_id, created_at);
+
+create index match_history_map_game_start_index
+    on match_history (map, game_start);
+
+create index clips_created_at_id_index
+    on clips (created_at, id);
+
+create index clips_game_created_at_index
+    on clips (game, created_at);
+
+create index clips_duration_index
+    on clips (duration);
+
+create index clips_ranked_point_gain_index
+    on clips (ranked_point_gain);
+
+create index clips_tags_tag_id_index
+    on clips_tags (tag_id);
+
+create fulltext index clips_title_description_fulltext
+    on clips (title, description);
+
+create fulltext index tags_name_fulltext
+    on tags (name);
+
+create fulltext index legends_name_fulltext
+    on legends (name);
+
+create fulltext index maps_name_fulltext
+    on maps (name);
+
+create index favorites_clip_id_index
+    on favorites (clip_id);
+
+create index clip_reactions_clip_id_emoji_index
+    on clip_reactions (clip_id, emoji);
+
+create index clip_views_clip_id_viewed_at_index
+    on clip_views (clip_id, viewed_at);
+
+create index clip_comments_clip_id_created_at_index
+    on clip_comments (clip_id, created_at);
+
+create index clip_comments_author_id_created_at_index
+    on clip_comments (author_id, created_at);
+
+create index clip_shares_clip_id_index
+    on clip_shares (clip_id);
+
+create index uploads_expires_at_index
+    on uploads (expires_at);
+
+create index clips_storage_tier_index
+    on clips (storage_tier);
+
+create index reencode_jobs_clip_id_index
+    on reencode_jobs (clip_id);
+
+create index ingested_files_path_index
+    on ingested_files (path(255));
+
+create index ingested_files_content_hash_index
+    on ingested_files (content_hash);
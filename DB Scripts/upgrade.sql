@@ -0,0 +1,495 @@
+-- Upgrades a database created from an older script.sql. Run the sections after the newest one the database already
+-- has, in order, since later sections depend on tables and columns added by earlier ones. Databases the previous
+-- server ran against already have match_history.ranked_image, ranked_point_gain and match_hash, and
+-- clips.ranked_image and ranked_point_gain.
+
+-- Player stats
+create index match_history_user_id_game_start_index
+    on match_history (user_id, game_start);
+
+create index clips_owner_id_created_at_index
+    on clips (owner_id, created_at);
+
+-- Ranked progression
+create table seasons
+(
+    id         int auto_increment
+        primary key,
+    name       varchar(32) not null,
+    started_at datetime    not null,
+    ended_at   datetime    null
+);
+
+insert into seasons (name, started_at, ended_at)
+values ('Season 1: Wild Frontier', '2019-03-19 17:00:00', '2019-07-02 17:00:00'),
+       ('Season 2: Battle Charge', '2019-07-02 17:00:00', '2019-10-01 17:00:00'),
+       ('Season 3: Meltdown', '2019-10-01 17:00:00', '2020-02-04 17:00:00'),
+       ('Season 4: Assimilation', '2020-02-04 17:00:00', '2020-05-12 17:00:00'),
+       ('Season 5: Fortune''s Favor', '2020-05-12 17:00:00', '2020-08-18 17:00:00'),
+       ('Season 6: Boosted', '2020-08-18 17:00:00', '2020-11-04 17:00:00'),
+       ('Season 7: Ascension', '2020-11-04 17:00:00', '2021-02-02 17:00:00'),
+       ('Season 8: Mayhem', '2021-02-02 17:00:00', '2021-05-04 17:00:00'),
+       ('Season 9: Legacy', '2021-05-04 17:00:00', '2021-08-03 17:00:00'),
+       ('Season 10: Emergence', '2021-08-03 17:00:00', '2021-11-02 17:00:00'),
+       ('Season 11: Escape', '2021-11-02 17:00:00', '2022-02-08 17:00:00'),
+       ('Season 12: Defiance', '2022-02-08 17:00:00', '2022-05-10 17:00:00'),
+       ('Season 13: Saviors', '2022-05-10 17:00:00', '2022-08-09 17:00:00'),
+       ('Season 14: Hunted', '2022-08-09 17:00:00', '2022-11-01 17:00:00'),
+       ('Season 15: Eclipse', '2022-11-01 17:00:00', '2023-02-14 17:00:00'),
+       ('Season 16: Revelry', '2023-02-14 17:00:00', '2023-05-09 17:00:00'),
+       ('Season 17: Arsenal', '2023-05-09 17:00:00', '2023-08-08 17:00:00'),
+       ('Season 18: Resurrection', '2023-08-08 17:00:00', '2023-10-31 17:00:00'),
+       ('Season 19: Ignite', '2023-10-31 17:00:00', '2024-02-13 17:00:00'),
+       ('Season 20: Breakout', '2024-02-13 17:00:00', '2024-05-07 17:00:00'),
+       ('Season 21: Upheaval', '2024-05-07 17:00:00', '2024-08-06 17:00:00'),
+       ('Season 22: Shockwave', '2024-08-06 17:00:00', '2024-11-05 17:00:00'),
+       ('Season 23: From the Rift', '2024-11-05 17:00:00', '2025-02-11 17:00:00'),
+       ('Season 24: Takeover', '2025-02-11 17:00:00', '2025-05-06 17:00:00'),
+       ('Season 25: Prodigy', '2025-05-06 17:00:00', '2025-08-05 17:00:00'),
+       ('Season 26: Showdown', '2025-08-05 17:00:00', null);
+
+-- Match groups
+create table match_groups
+(
+    id         int auto_increment
+        primary key,
+    map        int      null,
+    game_start datetime null,
+    game_end   datetime null,
+    constraint match_groups_maps_id_fk
+        foreign key (map) references maps (id)
+);
+
+create table match_group_members
+(
+    match_group_id   int not null,
+    match_history_id int not null
+        primary key,
+    constraint match_group_members_match_groups_id_fk
+        foreign key (match_group_id) references match_groups (id),
+    constraint match_group_members_match_history_id_fk
+        foreign key (match_history_id) references match_history (id)
+);
+
+create index match_history_map_game_start_index
+    on match_history (map, game_start);
+
+-- Games: everything that existed before is Apex, which is the first game
+create table games
+(
+    id   int auto_increment
+        primary key,
+    slug varchar(16) not null,
+    name varchar(64) not null,
+    constraint games_slug_uindex
+        unique (slug)
+);
+
+insert into games (slug, name)
+values ('apex', 'Apex Legends'),
+       ('valorant', 'Valorant'),
+       ('cs2', 'Counter-Strike 2');
+
+alter table legends
+    add game int default 1 not null,
+    add constraint legends_games_id_fk
+        foreign key (game) references games (id);
+
+alter table maps
+    add game int default 1 not null,
+    add constraint maps_games_id_fk
+        foreign key (game) references games (id);
+
+create table user_game_accounts
+(
+    user_id      int         not null,
+    game_id      int         not null,
+    account_name varchar(64) not null,
+    account_uid  varchar(64) not null,
+    primary key (user_id, game_id),
+    constraint user_game_accounts_games_id_fk
+        foreign key (game_id) references games (id),
+    constraint user_game_accounts_users_id_fk
+        foreign key (user_id) references users (id)
+);
+
+create index user_game_accounts_game_id_account_uid_index
+    on user_game_accounts (game_id, account_uid);
+
+-- Apex accounts used to live on the users table
+insert into user_game_accounts (user_id, game_id, account_name, account_uid)
+select users.id, games.id, coalesce(users.apex_username, ''), users.apex_uid
+from users
+         inner join games on games.slug = 'apex'
+where users.apex_uid is not null
+  and users.apex_uid <> ''
+  and not exists (select 1
+                  from user_game_accounts
+                  where user_game_accounts.user_id = users.id
+                    and user_game_accounts.game_id = games.id);
+
+-- Game modes are free text per game now. The old enum stored them in lower case, match history writes 'Pubs' and
+-- 'Ranked'
+alter table clips
+    modify game_mode varchar(32) null;
+
+update clips
+set game_mode = 'Pubs'
+where game_mode = 'pubs';
+
+update clips
+set game_mode = 'Ranked'
+where game_mode = 'ranked';
+
+alter table clips
+    add game int default 1 not null after ranked_point_gain,
+    add constraint clips_games_id_fk
+        foreign key (game) references games (id);
+
+-- Play sessions
+create table sessions
+(
+    id         int auto_increment
+        primary key,
+    owner_id   int      not null,
+    started_at datetime not null,
+    ended_at   datetime not null,
+    constraint sessions_users_id_fk
+        foreign key (owner_id) references users (id)
+);
+
+create index sessions_owner_id_started_at_index
+    on sessions (owner_id, started_at);
+
+-- Clip listing
+create index clips_created_at_id_index
+    on clips (created_at, id);
+
+create index clips_game_created_at_index
+    on clips (game, created_at);
+
+create index clips_duration_index
+    on clips (duration);
+
+create index clips_ranked_point_gain_index
+    on clips (ranked_point_gain);
+
+create index clips_tags_tag_id_index
+    on clips_tags (tag_id);
+
+-- Clip titles and search
+alter table clips
+    add title       varchar(128) default ''  not null after game,
+    add description varchar(2000) default '' not null after title;
+
+create fulltext index clips_title_description_fulltext
+    on clips (title, description);
+
+create fulltext index tags_name_fulltext
+    on tags (name);
+
+create fulltext index legends_name_fulltext
+    on legends (name);
+
+create fulltext index maps_name_fulltext
+    on maps (name);
+
+-- Tag management: tag names are unique and compared without case or accents
+alter table tags
+    modify name varchar(32) collate utf8mb4_0900_ai_ci null,
+    add color    char(7)     null,
+    add category varchar(32) null;
+
+-- Tags whose names only differ in case or accents are merged into the oldest one before the unique index is added
+create temporary table tag_merges
+select duplicate.id as source_id, min(original.id) as target_id
+from tags as duplicate
+         inner join tags as original on original.name = duplicate.name and original.id < duplicate.id
+group by duplicate.id;
+
+insert ignore into clips_tags (clip_id, tag_id)
+select clips_tags.clip_id, tag_merges.target_id
+from clips_tags
+         inner join tag_merges on clips_tags.tag_id = tag_merges.source_id;
+
+delete clips_tags
+from clips_tags
+         inner join tag_merges on clips_tags.tag_id = tag_merges.source_id;
+
+delete tags
+from tags
+         inner join tag_merges on tags.id = tag_merges.source_id;
+
+drop temporary table tag_merges;
+
+alter table tags
+    add constraint tags_name_uindex
+        unique (name);
+
+-- Collections
+create table collections
+(
+    id            int auto_increment
+        primary key,
+    owner_id      int                                 not null,
+    title         varchar(128)                        not null,
+    description   varchar(2000) default ''            not null,
+    cover_clip_id int                                 null,
+    is_public     tinyint(1) default 0                not null,
+    created_at    timestamp default CURRENT_TIMESTAMP not null,
+    constraint collections_clips_id_fk
+        foreign key (cover_clip_id) references clips (id),
+    constraint collections_users_id_fk
+        foreign key (owner_id) references users (id)
+);
+
+create table collection_clips
+(
+    collection_id int not null,
+    clip_id       int not null,
+    position      int not null,
+    primary key (collection_id, clip_id),
+    constraint collection_clips_clips_id_fk
+        foreign key (clip_id) references clips (id),
+    constraint collection_clips_collections_id_fk
+        foreign key (collection_id) references collections (id)
+);
+
+-- Favorites, reactions and views
+create table favorites
+(
+    user_id    int                                 not null,
+    clip_id    int                                 not null,
+    created_at timestamp default CURRENT_TIMESTAMP not null,
+    primary key (user_id, clip_id),
+    constraint favorites_clips_id_fk
+        foreign key (clip_id) references clips (id),
+    constraint favorites_users_id_fk
+        foreign key (user_id) references users (id)
+);
+
+create table clip_reactions
+(
+    user_id    int                                 not null,
+    clip_id    int                                 not null,
+    emoji      varchar(16) collate utf8mb4_bin     not null,
+    created_at timestamp default CURRENT_TIMESTAMP not null,
+    primary key (user_id, clip_id, emoji),
+    constraint clip_reactions_clips_id_fk
+        foreign key (clip_id) references clips (id),
+    constraint clip_reactions_users_id_fk
+        foreign key (user_id) references users (id)
+);
+
+create table clip_views
+(
+    id        int auto_increment
+        primary key,
+    clip_id   int                                 not null,
+    user_id   int                                 null,
+    address   varchar(45)                         not null,
+    viewed_at timestamp default CURRENT_TIMESTAMP not null,
+    constraint clip_views_clips_id_fk
+        foreign key (clip_id) references clips (id),
+    constraint clip_views_users_id_fk
+        foreign key (user_id) references users (id)
+);
+
+create index favorites_clip_id_index
+    on favorites (clip_id);
+
+create index clip_reactions_clip_id_emoji_index
+    on clip_reactions (clip_id, emoji);
+
+create index clip_views_clip_id_viewed_at_index
+    on clip_views (clip_id, viewed_at);
+
+-- Comments
+create table clip_comments
+(
+    id                int auto_increment
+        primary key,
+    clip_id           int                                 not null,
+    author_id         int                                 not null,
+    parent_id         int                                 null,
+    timestamp_seconds int                                 null,
+    body              varchar(2000)                       not null,
+    is_deleted        tinyint(1) default 0                not null,
+    created_at        timestamp default CURRENT_TIMESTAMP not null,
+    edited_at         timestamp                           null,
+    constraint clip_comments_clip_comments_id_fk
+        foreign key (parent_id) references clip_comments (id),
+    constraint clip_comments_clips_id_fk
+        foreign key (clip_id) references clips (id),
+    constraint clip_comments_users_id_fk
+        foreign key (author_id) references users (id)
+);
+
+create index clip_comments_clip_id_created_at_index
+    on clip_comments (clip_id, created_at);
+
+create index clip_comments_author_id_created_at_index
+    on clip_comments (author_id, created_at);
+
+-- Authentication: existing users have no credentials until the initial admin from the auth config or an
+-- admin gives them some
+alter table users
+    add username               varchar(32) null,
+    add password_hash          char(60)    null,
+    add credentials_changed_at timestamp   null,
+    add constraint users_username_uindex
+        unique (username);
+
+create table api_tokens
+(
+    id           int auto_increment
+        primary key,
+    user_id      int                                 not null,
+    name         varchar(64)                         not null,
+    token_hash   char(64)                            not null,
+    created_at   timestamp default CURRENT_TIMESTAMP not null,
+    last_used_at timestamp                           null,
+    expires_at   timestamp                           null,
+    constraint api_tokens_token_hash_uindex
+        unique (token_hash),
+    constraint api_tokens_users_id_fk
+        foreign key (user_id) references users (id)
+);
+
+-- Roles: existing users are members, the server makes the first user with credentials an admin
+alter table users
+    add role enum ('admin', 'member', 'viewer') default 'member' not null;
+
+-- Share links
+create table clip_shares
+(
+    id         int auto_increment
+        primary key,
+    clip_id    int                                 not null,
+    created_by int                                 not null,
+    token      varchar(32)                         not null,
+    created_at timestamp default CURRENT_TIMESTAMP not null,
+    expires_at timestamp                           null,
+    max_views  int                                 null,
+    view_count int default 0                       not null,
+    constraint clip_shares_token_uindex
+        unique (token),
+    constraint clip_shares_clips_id_fk
+        foreign key (clip_id) references clips (id),
+    constraint clip_shares_users_id_fk
+        foreign key (created_by) references users (id)
+);
+
+create index clip_shares_clip_id_index
+    on clip_shares (clip_id);
+
+-- Visibility: existing clips and users keep seeing what they could before, which is the group
+alter table users
+    add default_visibility enum ('private', 'group', 'public') default 'group' not null;
+
+alter table clips
+    add visibility enum ('private', 'group', 'public') default 'group' not null after description;
+
+-- Resumable uploads
+create table uploads
+(
+    id              char(32)                            not null
+        primary key,
+    owner_id        int                                 not null,
+    game            int                                 not null,
+    filename        varchar(128)                        not null,
+    clip_created_at timestamp                           not null,
+    length          bigint                              not null,
+    upload_offset   bigint    default 0                 not null,
+    started_at      timestamp default CURRENT_TIMESTAMP not null,
+    expires_at      timestamp                           not null,
+    constraint uploads_games_id_fk
+        foreign key (game) references games (id),
+    constraint uploads_users_id_fk
+        foreign key (owner_id) references users (id)
+);
+
+create index uploads_expires_at_index
+    on uploads (expires_at);
+
+-- Content hashes: clips added before uploads were hashed keep a null content_hash, which the unique index allows
+-- more than once, until the transcoder backfills it from their upload
+alter table clips
+    add original_filename varchar(128) default '' not null after visibility,
+    add content_hash      char(64)                null after original_filename;
+
+update clips
+set original_filename = filename;
+
+alter table uploads
+    add hash_state varbinary(256) null after upload_offset;
+
+-- Filenames were already checked before adding a clip, but nothing stopped two uploads racing. This has to return
+-- no rows before the unique indexes are added. Give the newer clips of any it returns a new filename, renaming their
+-- stored files with them
+select filename, count(*)
+from clips
+group by filename
+having count(*) > 1;
+
+alter table clips
+    add constraint clips_content_hash_uindex
+        unique (content_hash),
+    add constraint clips_filename_uindex
+        unique (filename);
+
+-- Creation time sources: existing clips used the time the client sent
+alter table clips
+    add created_at_source enum ('container', 'filename', 'client', 'upload') default 'client' not null after content_hash;
+
+alter table uploads
+    modify clip_created_at timestamp null,
+    add timezone varchar(64) default '' not null after clip_created_at;
+
+-- Ingested recordings
+create table ingested_files
+(
+    id           int auto_increment
+        primary key,
+    path         varchar(1024)                       not null,
+    size         bigint                              not null,
+    modified_at  datetime(6)                         not null,
+    content_hash char(64)                            not null,
+    clip_id      int                                 null,
+    ingested_at  timestamp default CURRENT_TIMESTAMP not null
+);
+
+create index ingested_files_path_index
+    on ingested_files (path(255));
+
+create index ingested_files_content_hash_index
+    on ingested_files (content_hash);
+
+-- Storage tiers: existing clips are in the clips store, which is the cold tier
+alter table clips
+    add storage_tier enum ('hot', 'cold') default 'cold' not null after created_at_source;
+
+create index clips_storage_tier_index
+    on clips (storage_tier);
+
+-- Re-encodes
+create table reencode_jobs
+(
+    id                 int auto_increment
+        primary key,
+    clip_id            int                                            not null,
+    codec              varchar(16)                                    not null,
+    status             enum ('encoding', 'finished', 'kept', 'error') not null,
+    original_size      bigint                                         not null,
+    reencoded_size     bigint                                         null,
+    original_duration  double                                         null,
+    reencoded_duration double                                         null,
+    error_message      varchar(256)                                   null,
+    started_at         timestamp default CURRENT_TIMESTAMP            not null,
+    finished_at        timestamp                                      null,
+    constraint reencode_jobs_clips_id_fk
+        foreign key (clip_id) references clips (id)
+);
+
+create index reencode_jobs_clip_id_index
+    on reencode_jobs (clip_id);
@@ -1,12 +1,22 @@
 package main
 
 import (
+	"ClipsArchiver/internal/auth"
 	"ClipsArchiver/internal/config"
 	"ClipsArchiver/internal/db"
+	"ClipsArchiver/internal/rabbitmq"
+	"ClipsArchiver/internal/rest/accounts"
 	"ClipsArchiver/internal/rest/clips"
+	"ClipsArchiver/internal/rest/collections"
+	"ClipsArchiver/internal/rest/comments"
 	"ClipsArchiver/internal/rest/files"
+	"ClipsArchiver/internal/rest/games"
 	"ClipsArchiver/internal/rest/legends"
 	"ClipsArchiver/internal/rest/maps"
+	"ClipsArchiver/internal/rest/matches"
+	"ClipsArchiver/internal/rest/seasons"
+	"ClipsArchiver/internal/rest/sessions"
+	"ClipsArchiver/internal/rest/shares"
 	"ClipsArchiver/internal/rest/tags"
 	"ClipsArchiver/internal/rest/transcodeRequests"
 	"ClipsArchiver/internal/rest/trimRequests"
@@ -16,9 +26,11 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"time"
 )
 
 const logFileLocation = "clipsarchiver.log"
+const uploadCleanupInterval = time.Hour
 
 var logger *slog.Logger
 
@@ -41,29 +53,121 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to setup database: %s", err.Error())
 	}
+	err = rabbitmq.Connect()
+	if err != nil {
+		log.Fatalf("Failed to connect to rabbitmq: %s", err.Error())
+	}
+	err = db.EnsureAdmin()
+	if err != nil {
+		log.Fatalf("Failed to check for an admin: %s", err.Error())
+	}
+	err = auth.BootstrapAdmin()
+	if err != nil {
+		log.Fatalf("Failed to set up the initial admin: %s", err.Error())
+	}
+
+	// abandoned resumable uploads are thrown away once they expire
+	go func() {
+		for {
+			files.RemoveExpiredUploads()
+			time.Sleep(uploadCleanupInterval)
+		}
+	}()
 
 	router := gin.Default()
 
-	router.GET("/clips/:clipId", clips.Get)
-	router.PUT("/clips/:clipId", clips.Update)
-	router.DELETE("/clips/:clipId", clips.Delete)
-	router.GET("/clips/date/:date", clips.GetForDate)
-	router.GET("/clips/filename/:filename", clips.GetByFilename)
-	router.GET("/users", users.GetAll)
-	router.GET("/tags", tags.GetAll)
-	router.GET("/maps", maps.GetAll)
-	router.GET("/legends", legends.GetAll)
-	router.GET("/clips/queue", transcodeRequests.GetAll)
-	router.GET("/clips/queue/:clipId", transcodeRequests.GetById)
-	router.GET("/clips/download/:clipId", files.DownloadClipById)
-	router.GET("/clips/download/thumbnail/:clipId", files.DownloadClipThumbnailById)
-	router.POST("/clips/upload/:ownerId", files.UploadClip)
-	router.POST("/clips/trim/:clipId", trimRequests.Create)
-	router.GET("clips/trim/:clipId", trimRequests.GetByClipId)
-	//router.POST("/clips/combine/:firstId/:secondId", files.CombineClips)
-	router.StaticFS("/clips/archive", http.Dir(config.GetOutputPath()))
-	router.StaticFS("/clips/thumbnails", http.Dir(config.GetThumbnailsPath()))
-	router.StaticFS("/resources", http.Dir(config.GetResourcesPath()))
+	router.POST("/auth/login", accounts.Login)
+	router.GET(db.SharePath+":token", shares.Page)
+	router.GET("/oembed", shares.OEmbed)
+	router.OPTIONS("/clips/uploads", files.RequireTusResumable, files.GetUploadOptions)
+	router.OPTIONS("/clips/uploads/:uploadId", files.RequireTusResumable, files.GetUploadOptions)
+
+	// clips and thumbnails are reached through the signed urls on each clip instead of a token
+	archive := router.Group(db.ArchivePath, files.RequireSignature, auth.Identify, files.RecordArchiveView)
+	archive.GET("/*filepath", files.ServeArchivedClip)
+	archive.HEAD("/*filepath", files.ServeArchivedClip)
+	thumbnails := router.Group(db.ThumbnailsPath, files.RequireSignature)
+	thumbnails.GET("/*filepath", files.ServeThumbnail)
+	thumbnails.HEAD("/*filepath", files.ServeThumbnail)
+
+	// everything else needs a token
+	api := router.Group("/", auth.Authenticate)
+
+	api.GET("/clips", clips.List)
+	api.GET("/clips/search", clips.Search)
+	api.POST("/clips/bulk", clips.Bulk)
+	api.GET("/clips/:clipId", clips.Get)
+	api.PUT("/clips/:clipId", clips.Update)
+	api.DELETE("/clips/:clipId", clips.Delete)
+	api.GET("/clips/date/:date", clips.GetForDate)
+	api.GET("/clips/filename/:filename", clips.GetByFilename)
+	api.GET("/clips/:clipId/match", clips.GetMatch)
+	api.GET("/clips/:clipId/reencodes", clips.GetReencodes)
+	api.PUT("/clips/:clipId/favorite", clips.AddFavorite)
+	api.DELETE("/clips/:clipId/favorite", clips.RemoveFavorite)
+	api.PUT("/clips/:clipId/reactions/:emoji", clips.AddReaction)
+	api.DELETE("/clips/:clipId/reactions/:emoji", clips.RemoveReaction)
+	api.GET("/clips/:clipId/comments", comments.GetForClip)
+	api.GET("/clips/:clipId/shares", shares.GetForClip)
+	api.POST("/clips/:clipId/shares", shares.Create)
+	api.DELETE("/shares/:shareId", shares.Delete)
+	api.POST("/clips/:clipId/comments", comments.Create)
+	api.GET("/auth/me", accounts.GetMe)
+	api.GET("/auth/tokens", accounts.GetTokens)
+	api.POST("/auth/tokens", accounts.CreateToken)
+	api.DELETE("/auth/tokens/:tokenId", accounts.DeleteToken)
+	api.GET("/users", users.GetAll)
+	api.POST("/users", accounts.CreateUser)
+	api.PUT("/users/:id/credentials", accounts.SetCredentials)
+	api.PUT("/users/:id/role", users.SetRole)
+	api.PUT("/users/:id/visibility", users.SetDefaultVisibility)
+	api.GET("/users/:id/stats", users.GetStats)
+	api.GET("/users/:id/ranked", users.GetRankedProgression)
+	api.GET("/users/:id/accounts", users.GetGameAccounts)
+	api.PUT("/users/:id/accounts/:gameId", users.SetGameAccount)
+	api.GET("/users/:id/favorites", users.GetFavorites)
+	api.GET("/users/:id/collections", collections.GetForUser)
+	api.GET("/users/:id/comments", comments.GetForUser)
+	api.GET("/tags", tags.GetAll)
+	api.GET("/tags/:tagId", tags.Get)
+	api.POST("/tags", tags.Create)
+	api.PUT("/tags/:tagId", tags.Update)
+	api.DELETE("/tags/:tagId", tags.Delete)
+	api.POST("/tags/:tagId/merge", tags.Merge)
+	api.GET("/games", games.GetAll)
+	api.GET("/maps", maps.GetAll)
+	api.GET("/legends", legends.GetAll)
+	api.GET("/seasons", seasons.GetAll)
+	api.POST("/seasons", seasons.Create)
+	api.GET("/matches/:id", matches.Get)
+	api.GET("/matches/:id/clips", matches.GetClips)
+	api.GET("/comments/:commentId", comments.Get)
+	api.PUT("/comments/:commentId", comments.Update)
+	api.DELETE("/comments/:commentId", comments.Delete)
+	api.GET("/collections", collections.GetAllPublic)
+	api.POST("/collections", collections.Create)
+	api.GET("/collections/:collectionId", collections.Get)
+	api.PUT("/collections/:collectionId", collections.Update)
+	api.DELETE("/collections/:collectionId", collections.Delete)
+	api.POST("/collections/:collectionId/clips", collections.AddClip)
+	api.DELETE("/collections/:collectionId/clips/:clipId", collections.RemoveClip)
+	api.PUT("/collections/:collectionId/order", collections.Reorder)
+	api.GET("/sessions", sessions.GetAll)
+	api.GET("/sessions/:id", sessions.Get)
+	api.GET("/sessions/:id/clips", sessions.GetClips)
+	api.GET("/clips/queue", transcodeRequests.GetAll)
+	api.GET("/clips/queue/:clipId", transcodeRequests.GetById)
+	api.GET("/clips/download/:clipId", files.DownloadClipById)
+	api.GET("/clips/download/thumbnail/:clipId", files.DownloadClipThumbnailById)
+	api.POST("/clips/upload", files.UploadClip)
+	api.POST("/clips/uploads", files.RequireTusResumable, files.CreateUpload)
+	api.HEAD("/clips/uploads/:uploadId", files.RequireTusResumable, files.GetUploadOffset)
+	api.PATCH("/clips/uploads/:uploadId", files.RequireTusResumable, files.PatchUpload)
+	api.DELETE("/clips/uploads/:uploadId", files.RequireTusResumable, files.DeleteUpload)
+	api.POST("/clips/trim/:clipId", trimRequests.Create)
+	api.GET("clips/trim/:clipId", trimRequests.GetByClipId)
+	//api.POST("/clips/combine/:firstId/:secondId", files.CombineClips)
+	api.StaticFS("/resources", http.Dir(config.GetResourcesPath()))
 
 	routerErr := router.Run()
 	if routerErr != nil {
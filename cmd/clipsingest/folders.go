@@ -0,0 +1,74 @@
+package main
+
+import (
+	"ClipsArchiver/internal/config"
+	"ClipsArchiver/internal/db"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// watchedFolder is an IngestFolder with its owner, game and timezone looked up
+type watchedFolder struct {
+	config.IngestFolder
+	gameId   int
+	location *time.Location
+}
+
+// loadWatchedFolders checks every configured folder before anything is watched, so a typo doesn't quietly
+// ingest clips for the wrong user or leave a folder unwatched
+func loadWatchedFolders(folders []config.IngestFolder) ([]watchedFolder, error) {
+	var watched []watchedFolder
+	for _, folder := range folders {
+		if folder.Path == "" {
+			return nil, fmt.Errorf("a folder has no path")
+		}
+		folder.Path = filepath.Clean(folder.Path)
+		info, err := os.Stat(folder.Path)
+		if err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("%s isn't a folder", folder.Path)
+		}
+
+		role, err := db.GetUserRole(folder.OwnerId)
+		if err != nil {
+			return nil, fmt.Errorf("%s: no user with id %d", folder.Path, folder.OwnerId)
+		}
+		if role == db.RoleViewer {
+			return nil, fmt.Errorf("%s: user %d is a viewer and can't own clips", folder.Path, folder.OwnerId)
+		}
+
+		gameSlug := db.GameSlugApexLegends
+		if folder.Game != "" {
+			gameSlug = folder.Game
+		}
+		game, err := db.GetGameBySlug(gameSlug)
+		if err != nil {
+			return nil, fmt.Errorf("%s: unknown game %s", folder.Path, gameSlug)
+		}
+
+		location := time.Local
+		if folder.Timezone != "" {
+			location, err = time.LoadLocation(folder.Timezone)
+			if err != nil {
+				return nil, fmt.Errorf("%s: unknown timezone %s", folder.Path, folder.Timezone)
+			}
+		}
+
+		switch folder.AfterIngest {
+		case "":
+			folder.AfterIngest = afterIngestKeep
+		case afterIngestKeep, afterIngestDelete:
+		case afterIngestMove:
+			if folder.MoveTo == "" {
+				return nil, fmt.Errorf("%s: moveTo is needed to move ingested recordings", folder.Path)
+			}
+			folder.MoveTo = filepath.Clean(folder.MoveTo)
+		default:
+			return nil, fmt.Errorf("%s: afterIngest should be keep, move or delete", folder.Path)
+		}
+
+		watched = append(watched, watchedFolder{IngestFolder: folder, gameId: game.Id, location: location})
+	}
+	return watched, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"ClipsArchiver/internal/config"
+	"ClipsArchiver/internal/db"
+	"ClipsArchiver/internal/rabbitmq"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+)
+
+const logFileLocation = "clipsingest.log"
+
+// What happens to a recording once it's a clip
+const (
+	afterIngestKeep   = "keep"
+	afterIngestMove   = "move"
+	afterIngestDelete = "delete"
+)
+
+// changeWatcher wakes the scanner as soon as something is written to a watched folder, so it doesn't have to wait
+// for the next poll
+type changeWatcher interface {
+	Add(path string) error
+	Changes() <-chan struct{}
+}
+
+var logger *slog.Logger
+
+func main() {
+	options := &slog.HandlerOptions{
+		Level:     slog.LevelDebug,
+		AddSource: true,
+	}
+
+	file, err := os.OpenFile(logFileLocation, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		log.Fatalf("Failed to get log file handle: %s", err.Error())
+	}
+
+	var handler slog.Handler = slog.NewJSONHandler(file, options)
+	logger = slog.New(handler)
+
+	err = db.SetupDb(logger)
+	if err != nil {
+		log.Fatalf("Failed to setup database: %s", err.Error())
+	}
+	err = rabbitmq.Connect()
+	if err != nil {
+		log.Fatalf("Failed to connect to rabbitmq: %s", err.Error())
+	}
+
+	folders, err := loadWatchedFolders(config.GetIngestFolders())
+	if err != nil {
+		log.Fatalf("Invalid ingest config: %s", err.Error())
+	}
+	if len(folders) == 0 {
+		log.Fatalf("No folders to watch, add them to the ingest config")
+	}
+
+	// network shares often don't deliver inotify events, so the folders are polled either way
+	watcher, err := newChangeWatcher()
+	var changes <-chan struct{}
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Falling back to polling only: %s", err.Error()))
+	} else {
+		changes = watcher.Changes()
+	}
+
+	pollInterval := config.GetIngestPollInterval()
+	stableTime := config.GetIngestStableTime()
+	scanner := newFolderScanner(watcher, stableTime)
+
+	//main loop
+	for {
+		waiting := false
+		for _, folder := range folders {
+			if scanner.Scan(folder) {
+				waiting = true
+			}
+		}
+
+		// files waiting to settle are looked at again as soon as they could be stable
+		wait := pollInterval
+		if waiting && stableTime < wait {
+			wait = stableTime
+		}
+		select {
+		case _, ok := <-changes:
+			if !ok {
+				logger.Warn("inotify stopped, falling back to polling only")
+				changes = nil
+			}
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"ClipsArchiver/internal/db"
+	"ClipsArchiver/internal/ingest"
+	"ClipsArchiver/internal/media"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// videoExtensions are the files worth probing, everything else in a watched folder is ignored
+var videoExtensions = map[string]bool{
+	".mp4":  true,
+	".mkv":  true,
+	".mov":  true,
+	".webm": true,
+	".avi":  true,
+	".ts":   true,
+	".flv":  true,
+}
+
+// seenFile is what a file looked like the last time it was scanned
+type seenFile struct {
+	size    int64
+	modTime time.Time
+	// unchangedSince is when the file was first seen at this size and modification time
+	unchangedSince time.Time
+	// done is set once the file is a clip or has been rejected, so it's left alone until it changes
+	done bool
+}
+
+type folderScanner struct {
+	watcher    changeWatcher
+	stableTime time.Duration
+	seen       map[string]*seenFile
+}
+
+func newFolderScanner(watcher changeWatcher, stableTime time.Duration) *folderScanner {
+	return &folderScanner{watcher: watcher, stableTime: stableTime, seen: make(map[string]*seenFile)}
+}
+
+// Scan ingests every recording in the folder that hasn't changed for the stable time, and reports whether any
+// are still waiting to settle
+func (s *folderScanner) Scan(folder watchedFolder) bool {
+	waiting := false
+	present := make(map[string]bool)
+	now := time.Now()
+
+	err := filepath.WalkDir(folder.Path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error scanning %s: %s", path, err.Error()))
+			return nil
+		}
+		if entry.IsDir() {
+			if path != folder.Path && (!folder.Recursive || path == folder.MoveTo || strings.HasPrefix(entry.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			if s.watcher != nil {
+				if err = s.watcher.Add(path); err != nil {
+					logger.Warn(fmt.Sprintf("Can't watch %s, it will only be polled: %s", path, err.Error()))
+				}
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") || !videoExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		present[path] = true
+
+		seen, ok := s.seen[path]
+		if !ok || seen.size != info.Size() || !seen.modTime.Equal(info.ModTime()) {
+			s.seen[path] = &seenFile{size: info.Size(), modTime: info.ModTime(), unchangedSince: now}
+			waiting = true
+			return nil
+		}
+		if seen.done {
+			return nil
+		}
+		if now.Sub(seen.unchangedSince) < s.stableTime {
+			waiting = true
+			return nil
+		}
+		seen.done = ingestFile(folder, path, info)
+		return nil
+	})
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error scanning %s: %s", folder.Path, err.Error()))
+	}
+
+	// forget files that were moved or deleted, so one showing up again under the same name is looked at again
+	for path := range s.seen {
+		if isInFolder(path, folder.Path) && !present[path] {
+			delete(s.seen, path)
+		}
+	}
+	return waiting
+}
+
+// ingestFile makes a recording into a clip through the same pipeline as uploads, then keeps, moves or deletes it.
+// It reports whether the file is finished with; it's tried again on the next scan when it isn't. Recordings are
+// hashed where they are first, so ones that were ingested before aren't copied again, even once their clip has
+// been deleted.
+func ingestFile(folder watchedFolder, path string, info fs.FileInfo) bool {
+	ingestedFile := db.IngestedFile{Path: path, Size: info.Size(), ModifiedAt: info.ModTime()}
+	ingested, err := db.IsFileIngested(path, ingestedFile.Size, ingestedFile.ModifiedAt)
+	if err != nil {
+		return false
+	}
+	if ingested {
+		// kept from before a restart
+		return true
+	}
+
+	logger.Debug(fmt.Sprintf("Ingesting %s for user %d", path, folder.OwnerId))
+	ingestedFile.ContentHash, err = hashFile(path)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error hashing %s: %s", path, err.Error()))
+		return false
+	}
+	ingested, err = db.IsContentIngested(ingestedFile.ContentHash)
+	if err != nil {
+		return false
+	}
+	if ingested {
+		logger.Info(fmt.Sprintf("%s was ingested before, leaving it alone", path))
+		_ = db.AddIngestedFile(ingestedFile)
+		return true
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error opening %s: %s", path, err.Error()))
+		return false
+	}
+	receivedPath, contentHash, err := ingest.SaveWithHash(file)
+	_ = file.Close()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error copying %s: %s", path, err.Error()))
+		return false
+	}
+	if contentHash != ingestedFile.ContentHash {
+		// changed while it was being read, it'll be looked at again once it settles
+		_ = os.Remove(receivedPath)
+		return false
+	}
+
+	clip, err := ingest.CreateClip(ingest.NewClip{
+		OwnerId:          folder.OwnerId,
+		GameId:           folder.gameId,
+		ReceivedPath:     receivedPath,
+		OriginalFilename: filepath.Base(path),
+		ContentHash:      contentHash,
+		// recorders write the file until the recording ends, which is the best guess left when the file
+		// and its name don't say
+		ClientCreatedAt: sql.NullTime{Time: info.ModTime(), Valid: true},
+		Location:        folder.location,
+	})
+	var validationErr *media.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		// left where it is for the user to look at
+		logger.Warn(fmt.Sprintf("Not ingesting %s: %s", path, validationErr.Message))
+		_ = db.AddIngestedFile(ingestedFile)
+		return true
+	case errors.Is(err, db.ErrDuplicateClip):
+		logger.Info(fmt.Sprintf("%s is already clip %d", path, clip.Id))
+	case err != nil:
+		logger.Error(fmt.Sprintf("Error ingesting %s: %s", path, err.Error()))
+		return false
+	default:
+		logger.Info(fmt.Sprintf("Ingested %s as clip %d", path, clip.Id))
+	}
+
+	ingestedFile.ClipId = sql.NullInt32{Int32: int32(clip.Id), Valid: clip.Id != 0}
+	_ = db.AddIngestedFile(ingestedFile)
+	if err = finishOriginal(folder, path); err != nil {
+		logger.Error(fmt.Sprintf("Error cleaning up %s after ingesting it: %s", path, err.Error()))
+	}
+	return true
+}
+
+// hashFile returns the sha256 of the file at path
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	contentHash := sha256.New()
+	if _, err = io.Copy(contentHash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(contentHash.Sum(nil)), nil
+}
+
+// finishOriginal keeps, moves or deletes a recording that's now a clip
+func finishOriginal(folder watchedFolder, path string) error {
+	switch folder.AfterIngest {
+	case afterIngestDelete:
+		return os.Remove(path)
+	case afterIngestMove:
+		relativePath, err := filepath.Rel(folder.Path, path)
+		if err != nil {
+			return err
+		}
+		return moveFile(path, filepath.Join(folder.MoveTo, relativePath))
+	}
+	return nil
+}
+
+// moveFile moves src to dst without overwriting anything already there, copying when they're on different
+// filesystems
+func moveFile(src string, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	dst = freePath(dst)
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// freePath returns path, or path with a number added before the extension if something's already there
+func freePath(path string) string {
+	extension := filepath.Ext(path)
+	base := strings.TrimSuffix(path, extension)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return path
+		}
+		path = base + " (" + strconv.Itoa(i) + ")" + extension
+	}
+}
+
+func isInFolder(path string, folder string) bool {
+	return strings.HasPrefix(path, folder+string(filepath.Separator))
+}
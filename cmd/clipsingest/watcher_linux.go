@@ -0,0 +1,57 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+)
+
+// inotifyEvents are the changes that can mean a recording was added or finished. Writes themselves aren't
+// watched, recorders make far too many of them.
+const inotifyEvents = unix.IN_CREATE | unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO
+
+type inotifyWatcher struct {
+	fd      int
+	changes chan struct{}
+}
+
+func newChangeWatcher() (changeWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	watcher := &inotifyWatcher{fd: fd, changes: make(chan struct{}, 1)}
+	go watcher.read()
+	return watcher, nil
+}
+
+// Add watches a folder. Adding a folder that's already watched does nothing, so every scan can add every folder
+// and pick up new and recreated ones.
+func (w *inotifyWatcher) Add(path string) error {
+	_, err := unix.InotifyAddWatch(w.fd, path, inotifyEvents)
+	return err
+}
+
+func (w *inotifyWatcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+func (w *inotifyWatcher) read() {
+	buffer := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := unix.Read(w.fd, buffer)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil || n <= 0 {
+			close(w.changes)
+			return
+		}
+		// which file changed doesn't matter, the scan finds it
+		select {
+		case w.changes <- struct{}{}:
+		default:
+		}
+	}
+}
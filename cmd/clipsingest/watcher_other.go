@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func newChangeWatcher() (changeWatcher, error) {
+	return nil, errors.New("inotify is only available on linux")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"ClipsArchiver/internal/db"
+	"ClipsArchiver/internal/storage"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// contentHashBatchSize limits how many clips are read per query while backfilling content hashes
+const contentHashBatchSize = 50
+
+// backfillContentHashes hashes the uploads of clips added before uploads were hashed, so uploading one of them
+// again is caught as a duplicate. It goes through them once, clips whose upload is gone are left without a hash.
+func backfillContentHashes() {
+	afterId := 0
+	for {
+		clips, err := db.GetClipsWithoutContentHash(afterId, contentHashBatchSize)
+		if err != nil || len(clips) == 0 {
+			return
+		}
+		for _, clip := range clips {
+			afterId = clip.Id
+			err = backfillContentHash(clip)
+			if errors.Is(err, db.ErrDuplicateClip) {
+				logger.Info(fmt.Sprintf("Clip %d has the same upload as another clip, leaving it without a content hash", clip.Id))
+			} else if err != nil {
+				logger.Error(fmt.Sprintf("Failed to hash the upload of clip %d: %s", clip.Id, err.Error()))
+			}
+		}
+	}
+}
+
+func backfillContentHash(clip db.Clip) error {
+	upload, err := storage.Uploads().Get(clip.Filename)
+	if err != nil {
+		return err
+	}
+	defer upload.Close()
+
+	contentHash := sha256.New()
+	if _, err = io.Copy(contentHash, upload); err != nil {
+		return err
+	}
+	return db.SetClipContentHash(clip.Id, hex.EncodeToString(contentHash.Sum(nil)))
+}
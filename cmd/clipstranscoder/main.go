@@ -4,6 +4,7 @@ import (
 	"ClipsArchiver/internal/config"
 	"ClipsArchiver/internal/db"
 	"ClipsArchiver/internal/media"
+	"ClipsArchiver/internal/storage"
 	"fmt"
 	"log"
 	"log/slog"
@@ -36,6 +37,14 @@ func main() {
 
 	jobs := make(chan db.TranscodeRequest)
 	go receiveTranscodeClipVTB(jobs)
+	go backfillContentHashes()
+
+	if config.GetTiering().Enabled {
+		go moveClipsBetweenTiers()
+	}
+	if config.GetReencode().Enabled {
+		go reencodeArchive()
+	}
 
 	var forever chan struct{}
 
@@ -95,7 +104,7 @@ func main() {
 
 func checkForQueueEntries(jobs chan<- db.TranscodeRequest) {
 	slog.Debug("Checking for Queue Entries")
-	queueEntries, err := db.GetAllPendingTranscodeRequests()
+	queueEntries, err := db.GetAllPendingTranscodeRequests(db.SystemViewer)
 	if err != nil {
 		logger.Error("Failed to get pending Queue Entries")
 		return
@@ -116,44 +125,81 @@ func receiveTranscodeClipVTB(jobs <-chan db.TranscodeRequest) {
 func transcodeClipVTB(queueEntry db.TranscodeRequest) {
 	err := db.UpdateTranscodeRequestStatusToTranscoding(queueEntry.ClipId)
 	if err != nil {
-		logger.Error("Failed to modify database entry: tried to set queue entry %d to transcoding", queueEntry.Id)
+		logger.Error(fmt.Sprintf("Failed to modify database entry: tried to set queue entry %d to transcoding", queueEntry.Id))
 		return
 	}
 
-	clip, err := db.GetClipById(queueEntry.ClipId)
+	clip, err := db.GetClipById(db.SystemViewer, queueEntry.ClipId)
 	if err != nil {
-		logger.Error("Failed to get clip for id: %d", queueEntry.Id)
+		logger.Error(fmt.Sprintf("Failed to get clip for id: %d", queueEntry.Id))
 		return
 	}
 
-	inputPath := config.GetInputPath() + clip.Filename
-	outputPath := config.GetOutputPath() + clip.Filename
+	input, err := storage.Fetch(storage.Uploads(), clip.Filename)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to fetch upload for clip %d: %s", clip.Id, err.Error()))
+		err = db.UpdateTranscodeRequestStatusToError(queueEntry.ClipId, "Failed to fetch uploaded video file")
+		return
+	}
+	defer input.Release()
+	// new clips start on the hot tier, since that's when they're watched most
+	tier, clipStore := db.StorageTierCold, storage.Clips()
+	if config.GetTiering().Enabled {
+		tier, clipStore = db.StorageTierHot, storage.HotClips()
+	}
+	output, err := storage.Stage(clipStore, clip.Filename)
+	if err != nil {
+		err = db.UpdateTranscodeRequestStatusToError(queueEntry.ClipId, "Failed to transcode video file")
+		return
+	}
+	defer output.Release()
 	fmt.Printf("Starting transcode on %s\n", clip.Filename)
-	err = media.TranscodeVideoFile(inputPath, outputPath)
+	err = media.TranscodeVideoFile(input.Path, output.Path)
 	if err != nil {
 		err = db.UpdateTranscodeRequestStatusToError(queueEntry.ClipId, "Failed to transcode video file")
 		return
 	}
 
-	imagePath := config.GetThumbnailsPath() + clip.Filename + ".png"
-	err = media.GenerateThumbnailFromVideo(outputPath, imagePath)
+	thumbnail, err := storage.Stage(storage.Thumbnails(), clip.Filename+".png")
 	if err != nil {
 		err = db.UpdateTranscodeRequestStatusToError(queueEntry.ClipId, "Failed to generate video thumbnail")
 		return
 	}
+	defer thumbnail.Release()
+	err = media.GenerateThumbnailFromVideo(output.Path, thumbnail.Path)
+	if err != nil {
+		err = db.UpdateTranscodeRequestStatusToError(queueEntry.ClipId, "Failed to generate video thumbnail")
+		return
+	}
+
+	// probed before the transcoded file is stored, while it's still local
+	probeData, err := media.GetVideoProbeData(output.Path)
+	if err != nil {
+		err = db.UpdateTranscodeRequestStatusToError(queueEntry.ClipId, "Failed to read transcoded video file")
+		return
+	}
+	if err = output.Commit(); err != nil {
+		logger.Error(fmt.Sprintf("Failed to store transcoded clip %d: %s", clip.Id, err.Error()))
+		err = db.UpdateTranscodeRequestStatusToError(queueEntry.ClipId, "Failed to store transcoded video file")
+		return
+	}
+	if err = thumbnail.Commit(); err != nil {
+		logger.Error(fmt.Sprintf("Failed to store thumbnail of clip %d: %s", clip.Id, err.Error()))
+		err = db.UpdateTranscodeRequestStatusToError(queueEntry.ClipId, "Failed to store video thumbnail")
+		return
+	}
 
 	err = db.UpdateTranscodeRequestStatusToFinished(queueEntry.ClipId)
 	if err != nil {
 		err = db.UpdateTranscodeRequestStatusToError(queueEntry.ClipId, "Failed to modify database entry")
-		logger.Error("Failed to modify database entry: tried to set queue entry %d to finished", queueEntry.Id)
+		logger.Error(fmt.Sprintf("Failed to modify database entry: tried to set queue entry %d to finished", queueEntry.Id))
 		return
 	}
 
-	probeData, err := media.GetVideoProbeData(outputPath)
-	err = db.UpdateClipOnTranscodeFinish(queueEntry.ClipId, probeData.Format.DurationSeconds)
+	err = db.UpdateClipOnTranscodeFinish(queueEntry.ClipId, probeData.Format.DurationSeconds, tier)
 	if err != nil {
 		err = db.UpdateTranscodeRequestStatusToError(queueEntry.ClipId, "Failed to modify database entry")
-		logger.Error("Failed to modify database entry: tried to set queue entry %d to error", queueEntry.Id)
+		logger.Error(fmt.Sprintf("Failed to modify database entry: tried to set queue entry %d to error", queueEntry.Id))
 		return
 	}
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"ClipsArchiver/internal/config"
+	"ClipsArchiver/internal/db"
+	"ClipsArchiver/internal/media"
+	"ClipsArchiver/internal/storage"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// reencodeBatchSize limits how many clips are re-encoded per run, so the transcoder gets back to new uploads
+const reencodeBatchSize = 10
+
+// reencodeDurationTolerance is how far a re-encode's duration can be from the original's, in seconds, before
+// it's thrown away as broken
+const reencodeDurationTolerance = 0.5
+
+// reencodeArchive re-encodes old clips forever, in between transcoding new uploads
+func reencodeArchive() {
+	policy := config.GetReencode()
+	if !media.IsSupportedArchiveCodec(policy.Codec) {
+		logger.Error(fmt.Sprintf("Re-encoding is enabled with unsupported codec %s, should be %s or %s", policy.Codec, media.ArchiveCodecHevc, media.ArchiveCodecAv1))
+		return
+	}
+	_ = db.FailInterruptedReencodeJobs()
+
+	for {
+		recordedBefore := time.Now().AddDate(0, 0, -policy.OlderThanDays)
+		clips, err := db.GetClipsToReencode(recordedBefore, reencodeBatchSize)
+		if err == nil {
+			for _, clip := range clips {
+				// new uploads come first
+				pending, err := db.GetAllPendingTranscodeRequests(db.SystemViewer)
+				if err != nil || len(pending) > 0 {
+					break
+				}
+				reencodeClip(clip, policy)
+			}
+		}
+		time.Sleep(time.Duration(policy.IntervalMinutes) * time.Minute)
+	}
+}
+
+// reencodeClip re-encodes a clip in the scratch folder and only swaps it in if it's as long as the original and
+// smaller, recording the space saved
+func reencodeClip(clip db.Clip, policy config.ReencodeConfig) {
+	archiveLock.Lock()
+	defer archiveLock.Unlock()
+
+	// the tiering mover may have moved it since it was listed
+	clip, err := db.GetClipById(db.SystemViewer, clip.Id)
+	if err != nil {
+		return
+	}
+	store, err := storage.Locate(clip.Filename, clipTiers(clip)...)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to find clip %d to re-encode: %s", clip.Id, err.Error()))
+		return
+	}
+	original, err := store.Stat(clip.Filename)
+	if err != nil {
+		return
+	}
+	jobId, err := db.CreateReencodeJob(clip.Id, policy.Codec, original.Size)
+	if err != nil {
+		return
+	}
+
+	fail := func(message string) {
+		logger.Error(fmt.Sprintf("Failed to re-encode clip %d: %s", clip.Id, message))
+		_ = db.FailReencodeJob(jobId, message)
+	}
+
+	input, err := storage.Fetch(store, clip.Filename)
+	if err != nil {
+		fail("failed to fetch the clip")
+		return
+	}
+	defer input.Release()
+	inputProbeData, err := media.GetVideoProbeData(input.Path)
+	if err != nil || inputProbeData.Format == nil {
+		fail("failed to read the clip")
+		return
+	}
+	// a re-encode can be stored without its job being finished, it's not done again
+	if media.VideoCodec(inputProbeData) == policy.Codec {
+		logger.Info(fmt.Sprintf("Keeping clip %d as is, it's already %s", clip.Id, policy.Codec))
+		_ = db.FinishReencodeJob(jobId, db.ReencodeStatusKept, original.Size, inputProbeData.Format.DurationSeconds, inputProbeData.Format.DurationSeconds)
+		return
+	}
+
+	output, err := storage.StageReplacement(store, clip.Filename)
+	if err != nil {
+		fail("failed to create a scratch file")
+		return
+	}
+	defer output.Release()
+	logger.Info(fmt.Sprintf("Starting re-encode of clip %d to %s", clip.Id, policy.Codec))
+	err = media.ReencodeVideoFile(input.Path, output.Path, policy.Codec, policy.Quality)
+	if err != nil {
+		fail("failed to re-encode the clip")
+		return
+	}
+
+	outputProbeData, err := media.GetVideoProbeData(output.Path)
+	if err != nil || outputProbeData.Format == nil {
+		fail("failed to read the re-encoded clip")
+		return
+	}
+	originalDuration := inputProbeData.Format.DurationSeconds
+	reencodedDuration := outputProbeData.Format.DurationSeconds
+	if math.Abs(originalDuration-reencodedDuration) > reencodeDurationTolerance {
+		fail(fmt.Sprintf("re-encoded clip is %.2f seconds long instead of %.2f", reencodedDuration, originalDuration))
+		return
+	}
+	outputInfo, err := os.Stat(output.Path)
+	if err != nil {
+		fail("failed to read the re-encoded clip")
+		return
+	}
+
+	if outputInfo.Size() >= original.Size {
+		logger.Info(fmt.Sprintf("Keeping clip %d as is, re-encoding it to %s didn't make it smaller", clip.Id, policy.Codec))
+		_ = db.FinishReencodeJob(jobId, db.ReencodeStatusKept, outputInfo.Size(), originalDuration, reencodedDuration)
+		return
+	}
+	if err = output.Commit(); err != nil {
+		fail("failed to store the re-encoded clip")
+		return
+	}
+	if err = db.FinishReencodeJob(jobId, db.ReencodeStatusFinished, outputInfo.Size(), originalDuration, reencodedDuration); err != nil {
+		return
+	}
+
+	totalSaved, _ := db.GetSpaceSavedByReencoding()
+	logger.Info(fmt.Sprintf("Re-encoded clip %d to %s, saving %d bytes, %d bytes saved in total", clip.Id, policy.Codec, original.Size-outputInfo.Size(), totalSaved))
+}
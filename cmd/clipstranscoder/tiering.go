@@ -0,0 +1,86 @@
+package main
+
+import (
+	"ClipsArchiver/internal/config"
+	"ClipsArchiver/internal/db"
+	"ClipsArchiver/internal/storage"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// archiveLock keeps the tiering mover and the re-encoder from changing stored clips at the same time
+var archiveLock sync.Mutex
+
+// tieringBatchSize limits how many clips move each way per run, so a policy change doesn't tie up the disks
+const tieringBatchSize = 50
+
+// moveClipsBetweenTiers applies the tiering policy forever, moving clips nobody watches to the cold tier and
+// clips that are watched again back to the hot tier
+func moveClipsBetweenTiers() {
+	if storage.SameLocation(storage.HotClips(), storage.Clips()) {
+		logger.Error("Tiering is enabled but both tiers are the same folder, set different cacheStorePath and storePath")
+		return
+	}
+	for {
+		tiering := config.GetTiering()
+		activeSince := time.Now().AddDate(0, 0, -tiering.ColdAfterDays)
+		moveClipsToTier(db.StorageTierCold, activeSince, tiering.KeepFavoritesHot)
+		moveClipsToTier(db.StorageTierHot, activeSince, tiering.KeepFavoritesHot)
+		time.Sleep(time.Duration(tiering.IntervalMinutes) * time.Minute)
+	}
+}
+
+func moveClipsToTier(tier string, activeSince time.Time, keepFavoritesHot bool) {
+	clips, err := db.GetClipsToMoveToTier(tier, activeSince, keepFavoritesHot, tieringBatchSize)
+	if err != nil {
+		return
+	}
+	for _, clip := range clips {
+		err = moveClipToTier(clip, tier)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to move clip %d to the %s tier: %s", clip.Id, tier, err.Error()))
+		}
+	}
+}
+
+// moveClipToTier copies the clip to its new tier before recording the move, and only then removes the old copy.
+// Downloads look on both tiers, so they keep working whichever step a failure happens at.
+func moveClipToTier(clip db.Clip, tier string) error {
+	archiveLock.Lock()
+	defer archiveLock.Unlock()
+
+	from, to := storage.HotClips(), storage.Clips()
+	if tier == db.StorageTierHot {
+		from, to = to, from
+	}
+
+	err := storage.Copy(from, to, clip.Filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		// an earlier run got as far as removing the old copy
+		if _, statErr := to.Stat(clip.Filename); statErr != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if err = db.SetClipStorageTier(clip.Id, tier); err != nil {
+		return err
+	}
+	logger.Info(fmt.Sprintf("Moved clip %d to the %s tier", clip.Id, tier))
+	if err = from.Delete(clip.Filename); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// clipTiers lists the tiers a clip can be on, the one it's recorded on first
+func clipTiers(clip db.Clip) []storage.Storage {
+	if clip.StorageTier == db.StorageTierHot {
+		return []storage.Storage{storage.HotClips(), storage.Clips()}
+	}
+	return []storage.Storage{storage.Clips(), storage.HotClips()}
+}
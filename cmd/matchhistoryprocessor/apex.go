@@ -0,0 +1,210 @@
+package main
+
+import (
+	"ClipsArchiver/internal/config"
+	"ClipsArchiver/internal/db"
+	"ClipsArchiver/internal/rabbitmq"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type matchHistory struct {
+	Uid                string `json:"uid"`
+	LegendPlayed       string `json:"legendPlayed"`
+	GameStartTimestamp int64  `json:"gameStartTimestamp"`
+	GameEndTimestamp   int64  `json:"gameEndTimestamp"`
+	BrScoreChange      int    `json:"BRScoreChange"`
+	BrRankImg          string `json:"BRRankImg"`
+	Map                string `json:"map"`
+	MatchHash          string `json:"matchHash"`
+}
+
+type MapRotationInfo struct {
+	Map           string `json:"map"`
+	RemainingMins int    `json:"remainingMins"`
+}
+
+type MapRotation struct {
+	Current MapRotationInfo `json:"current"`
+	Next    MapRotationInfo `json:"next"`
+}
+
+// apexPlugin pulls match history and map rotation from the Apex Legends Status API
+type apexPlugin struct {
+	game             db.Game
+	currentMapString string
+}
+
+func newApexPlugin() (*apexPlugin, error) {
+	game, err := db.GetGameBySlug(db.GameSlugApexLegends)
+	if err != nil {
+		return nil, err
+	}
+	return &apexPlugin{game: game}, nil
+}
+
+func (p *apexPlugin) Name() string {
+	return p.game.Name
+}
+
+func (p *apexPlugin) Poll() error {
+	historyErr := p.getMatchHistoryForAllUsers()
+	clipsErr := p.processMatchHistoriesForRecentClips()
+	mapErr := p.getMapUpdate()
+	return errors.Join(historyErr, clipsErr, mapErr)
+}
+
+func (p *apexPlugin) getMapUpdate() error {
+	url := fmt.Sprintf("https://api.mozambiquehe.re/maprotation?auth=%s", config.GetApiKey())
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	mapRotation := new(MapRotation)
+	err = json.NewDecoder(resp.Body).Decode(mapRotation)
+	if err != nil {
+		return err
+	}
+
+	if mapRotation.Current.Map != p.currentMapString {
+		p.currentMapString = mapRotation.Current.Map
+		mun := rabbitmq.MapUpdateNotification{
+			MapName:         p.currentMapString,
+			DurationMinutes: mapRotation.Current.RemainingMins,
+		}
+		err = rabbitmq.PublishMapUpdateNotification(mun)
+	}
+	return err
+}
+
+func (p *apexPlugin) getMatchHistoryForAllUsers() error {
+	accounts, err := db.GetGameAccountsForGame(p.game.Id)
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		url := fmt.Sprintf("https://api.mozambiquehe.re/games?auth=%s&uid=%s", config.GetApiKey(), account.AccountUid)
+		resp, err := http.Get(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		matchHistories := new([]matchHistory)
+		err = json.NewDecoder(resp.Body).Decode(matchHistories)
+		if err != nil {
+			return err
+		}
+		p.processMatchHistories(*matchHistories)
+	}
+	return err
+}
+
+func (p *apexPlugin) processMatchHistories(matchHistories []matchHistory) {
+	for _, history := range matchHistories {
+		userId, err := db.GetUserIdByGameAccountUid(p.game.Id, history.Uid)
+		if err != nil {
+			continue
+		}
+		//do we already have it?
+		hash := sha256.New()
+		jsonString, err := json.Marshal(history)
+		hash.Write(jsonString)
+		matchHash := fmt.Sprintf("%x", hash.Sum(nil))
+		_, err = db.GetMatchHistoryByMatchHash(matchHash)
+		if err == nil {
+			// we have it
+			continue
+		}
+		//we don't have it so add it
+		gameMap, err := db.GetMapByAlsName(history.Map)
+		hasGameMap := true
+		if err != nil {
+			hasGameMap = false
+		}
+		legend, err := db.GetLegendByName(p.game.Id, history.LegendPlayed)
+		hasLegend := true
+		if err != nil {
+			hasLegend = false
+		}
+		gameMode := "Pubs"
+		isRanked := false
+		if history.BrScoreChange != 0 {
+			gameMode = "Ranked"
+			isRanked = true
+		}
+
+		newHist := db.MatchHistory{
+			UserId:        userId,
+			GameStart:     sql.NullTime{Valid: true, Time: time.Unix(history.GameStartTimestamp, 0)},
+			GameEnd:       sql.NullTime{Valid: true, Time: time.Unix(history.GameEndTimestamp, 0)},
+			Map:           sql.NullInt32{Int32: int32(gameMap.Id), Valid: hasGameMap},
+			Legend:        sql.NullInt32{Int32: int32(legend.Id), Valid: hasLegend},
+			GameMode:      gameMode,
+			BrScoreChange: sql.NullInt32{Valid: isRanked, Int32: int32(history.BrScoreChange)},
+			BrRankImg:     sql.NullString{Valid: isRanked, String: history.BrRankImg},
+			MatchHash:     matchHash,
+		}
+		err = db.AddNewMatchHistory(newHist)
+		if err != nil {
+			continue
+		}
+	}
+}
+
+func (p *apexPlugin) processMatchHistoriesForRecentClips() error {
+	var err error
+
+	for i := 0; i < 14; i++ {
+		clips, err := db.GetClipsForDate(db.SystemViewer, time.Now().AddDate(0, 0, -1*i))
+		if err == nil {
+			p.processMatchHistoriesForClips(clips)
+		}
+	}
+	return err
+}
+
+func (p *apexPlugin) processMatchHistoriesForClips(clips []db.Clip) {
+	for _, clip := range clips {
+		if clip.Game != p.game.Id || clip.MatchHistoryFound {
+			continue
+		}
+		matchHistories, err := db.GetMatchHistoriesForClip(clip)
+		if err != nil {
+			continue
+		}
+		if len(matchHistories) == 0 {
+			continue
+		}
+
+		selectedHistory := matchHistories[len(matchHistories)-1]
+
+		if selectedHistory.Map.Valid {
+			clip.Map.Int32 = selectedHistory.Map.Int32
+			clip.Map.Valid = true
+		}
+
+		if selectedHistory.Legend.Valid {
+			clip.Legend.Int32 = selectedHistory.Legend.Int32
+			clip.Legend.Valid = true
+		}
+
+		if selectedHistory.BrScoreChange.Valid {
+			clip.BrScoreChange = selectedHistory.BrScoreChange
+		}
+
+		if selectedHistory.BrRankImg.Valid {
+			clip.BrRankImg = selectedHistory.BrRankImg
+		}
+
+		clip.GameMode.String = selectedHistory.GameMode
+		clip.GameMode.Valid = true
+		clip.MatchHistoryFound = true
+		_ = db.UpdateClipMatchData(clip)
+	}
+}
@@ -1,45 +1,25 @@
 package main
 
 import (
-	"ClipsArchiver/internal/config"
 	"ClipsArchiver/internal/db"
 	"ClipsArchiver/internal/rabbitmq"
-	"crypto/sha256"
-	"database/sql"
-	"encoding/json"
 	"fmt"
 	"log"
 	"log/slog"
-	"net/http"
 	"os"
+	"slices"
 	"time"
 )
 
-type matchHistory struct {
-	Uid                string `json:"uid"`
-	LegendPlayed       string `json:"legendPlayed"`
-	GameStartTimestamp int64  `json:"gameStartTimestamp"`
-	GameEndTimestamp   int64  `json:"gameEndTimestamp"`
-	BrScoreChange      int    `json:"BRScoreChange"`
-	BrRankImg          string `json:"BRRankImg"`
-	Map                string `json:"map"`
-	MatchHash          string `json:"matchHash"`
-}
-
-type MapRotationInfo struct {
-	Map           string `json:"map"`
-	RemainingMins int    `json:"remainingMins"`
-}
-
-type MapRotation struct {
-	Current MapRotationInfo `json:"current"`
-	Next    MapRotationInfo `json:"next"`
+// gamePlugin enriches the clips of one game with data from an external source.
+// Clips of games without a plugin are still stored, they just never get match data.
+type gamePlugin interface {
+	Name() string
+	Poll() error
 }
 
 const logFileLocation = "matchhistoryprocessor.log"
 
-var currentMapString = ""
-
 var logger *slog.Logger
 
 func main() {
@@ -60,164 +40,89 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to setup database: %s", err.Error())
 	}
+	err = rabbitmq.Connect()
+	if err != nil {
+		log.Fatalf("Failed to connect to rabbitmq: %s", err.Error())
+	}
+
+	plugins := loadPlugins()
 
-	_ = getMatchHistoryForAllUsers()
-	_ = processMatchHistoriesForRecentClips()
 	//main loop
 	for i := 0; true; i++ {
+		for _, plugin := range plugins {
+			err = plugin.Poll()
+			if err != nil {
+				logger.Error(fmt.Sprintf("Plugin %s failed to poll: %s", plugin.Name(), err.Error()))
+			}
+		}
+		_ = groupRecentMatchHistories()
+		_ = buildRecentSessions()
 		time.Sleep(5 * time.Second)
-		_ = getMatchHistoryForAllUsers()
-		_ = processMatchHistoriesForRecentClips()
-		_ = getMapUpdate()
 	}
 }
 
-func getMapUpdate() error {
-	url := fmt.Sprintf("https://api.mozambiquehe.re/maprotation?auth=%s", config.GetApiKey())
-	resp, err := http.Get(url)
+func loadPlugins() []gamePlugin {
+	var plugins []gamePlugin
+
+	apex, err := newApexPlugin()
 	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	mapRotation := new(MapRotation)
-	err = json.NewDecoder(resp.Body).Decode(mapRotation)
-	if err != nil {
-		return err
+		logger.Error(fmt.Sprintf("Failed to load apex plugin: %s", err.Error()))
+	} else {
+		plugins = append(plugins, apex)
 	}
 
-	if mapRotation.Current.Map != currentMapString {
-		currentMapString = mapRotation.Current.Map
-		mun := rabbitmq.MapUpdateNotification{
-			MapName:         currentMapString,
-			DurationMinutes: mapRotation.Current.RemainingMins,
-		}
-		err = rabbitmq.PublishMapUpdateNotification(mun)
-	}
-	return err
+	return plugins
 }
 
-func getMatchHistoryForAllUsers() error {
-	allUsers, err := db.GetAllUsers()
+func groupRecentMatchHistories() error {
+	matchHistories, err := db.GetUngroupedMatchHistoriesSince(time.Now().AddDate(0, 0, -14))
 	if err != nil {
 		return err
 	}
-	for _, user := range allUsers {
-		url := fmt.Sprintf("https://api.mozambiquehe.re/games?auth=%s&uid=%s", config.GetApiKey(), user.ApexUid)
-		resp, err := http.Get(url)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-		matchHistories := new([]matchHistory)
-		err = json.NewDecoder(resp.Body).Decode(matchHistories)
-		if err != nil {
-			return err
-		}
-		processMatchHistories(*matchHistories)
+	for _, matchHistory := range matchHistories {
+		groupMatchHistory(matchHistory)
 	}
-	return err
+	return nil
 }
 
-func processMatchHistories(matchHistories []matchHistory) {
-	for _, history := range matchHistories {
-		user, err := db.GetUserByApexUid(history.Uid)
-		if err != nil {
-			continue
-		}
-		//do we already have it?
-		hash := sha256.New()
-		jsonString, err := json.Marshal(history)
-		hash.Write(jsonString)
-		matchHash := fmt.Sprintf("%x", hash.Sum(nil))
-		_, err = db.GetMatchHistoryByMatchHash(matchHash)
-		if err == nil {
-			// we have it
-			continue
-		}
-		//we don't have it so add it
-		gameMap, err := db.GetMapByAlsName(history.Map)
-		hasGameMap := true
-		if err != nil {
-			hasGameMap = false
-		}
-		legend, err := db.GetLegendByName(history.LegendPlayed)
-		hasLegend := true
-		if err != nil {
-			hasLegend = false
-		}
-		gameMode := "Pubs"
-		isRanked := false
-		if history.BrScoreChange != 0 {
-			gameMode = "Ranked"
-			isRanked = true
-		}
-
-		newHist := db.MatchHistory{
-			UserId:        user.Id,
-			GameStart:     sql.NullTime{Valid: true, Time: time.Unix(history.GameStartTimestamp, 0)},
-			GameEnd:       sql.NullTime{Valid: true, Time: time.Unix(history.GameEndTimestamp, 0)},
-			Map:           sql.NullInt32{Int32: int32(gameMap.Id), Valid: hasGameMap},
-			Legend:        sql.NullInt32{Int32: int32(legend.Id), Valid: hasLegend},
-			GameMode:      gameMode,
-			BrScoreChange: sql.NullInt32{Valid: isRanked, Int32: int32(history.BrScoreChange)},
-			BrRankImg:     sql.NullString{Valid: isRanked, String: history.BrRankImg},
-			MatchHash:     matchHash,
-		}
-		err = db.AddNewMatchHistory(newHist)
-		if err != nil {
-			continue
-		}
+// groupMatchHistory puts a game into the same match group as any teammate who was on the same map at the same time
+func groupMatchHistory(matchHistory db.MatchHistory) {
+	//grouped earlier in this pass as someone else's teammate
+	if _, err := db.GetMatchGroupIdForMatchHistory(matchHistory.Id); err == nil {
+		return
 	}
-}
 
-func processMatchHistoriesForRecentClips() error {
-	var err error
+	teammates, err := db.GetOverlappingMatchHistories(matchHistory)
+	if err != nil || len(teammates) == 0 {
+		return
+	}
 
-	for i := 0; i < 14; i++ {
-		clips, err := db.GetClipsForDate(time.Now().AddDate(0, 0, -1*i))
-		if err == nil {
-			processMatchHistoriesForClips(clips)
+	// a game can overlap teammates who were grouped separately, it bridges their groups into one match
+	var matchGroupIds []int
+	for _, teammate := range teammates {
+		id, err := db.GetMatchGroupIdForMatchHistory(teammate.Id)
+		if err == nil && !slices.Contains(matchGroupIds, id) {
+			matchGroupIds = append(matchGroupIds, id)
 		}
 	}
-	return err
-}
 
-func processMatchHistoriesForClips(clips []db.Clip) {
-	for _, clip := range clips {
-		if clip.MatchHistoryFound {
-			continue
-		}
-		matchHistories, err := db.GetMatchHistoriesForClip(clip)
+	matchGroupId := 0
+	if len(matchGroupIds) == 0 {
+		matchGroupId, err = db.CreateMatchGroup(matchHistory)
 		if err != nil {
-			continue
-		}
-		if len(matchHistories) == 0 {
-			continue
-		}
-
-		selectedHistory := matchHistories[len(matchHistories)-1]
-
-		if selectedHistory.Map.Valid {
-			clip.Map.Int32 = selectedHistory.Map.Int32
-			clip.Map.Valid = true
+			return
 		}
-
-		if selectedHistory.Legend.Valid {
-			clip.Legend.Int32 = selectedHistory.Legend.Int32
-			clip.Legend.Valid = true
-		}
-
-		if selectedHistory.BrScoreChange.Valid {
-			clip.BrScoreChange = selectedHistory.BrScoreChange
-		}
-
-		if selectedHistory.BrRankImg.Valid {
-			clip.BrRankImg = selectedHistory.BrRankImg
+	} else {
+		matchGroupId = slices.Min(matchGroupIds)
+		for _, id := range matchGroupIds {
+			if id != matchGroupId {
+				_ = db.MergeMatchGroups(matchGroupId, id)
+			}
 		}
+	}
 
-		clip.GameMode.String = selectedHistory.GameMode
-		clip.GameMode.Valid = true
-		clip.MatchHistoryFound = true
-		_ = db.UpdateClip(clip)
+	_ = db.AddMatchHistoryToMatchGroup(matchGroupId, matchHistory.Id)
+	for _, teammate := range teammates {
+		_ = db.AddMatchHistoryToMatchGroup(matchGroupId, teammate.Id)
 	}
 }
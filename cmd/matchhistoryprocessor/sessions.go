@@ -0,0 +1,64 @@
+package main
+
+import (
+	"ClipsArchiver/internal/db"
+	"time"
+)
+
+// sessionGap is the longest break between clips or games that still counts as the same play session
+const sessionGap = 90 * time.Minute
+
+// sessionsBuiltUpTo is the newest activity sessions have been built from, so each run only rebuilds the sessions
+// of users who played since the last one
+var sessionsBuiltUpTo db.ActivityMark
+
+func buildRecentSessions() error {
+	mark, err := db.GetActivityMark()
+	if err != nil || mark == sessionsBuiltUpTo {
+		return err
+	}
+	userIds, err := db.GetUserIdsWithActivityAfter(sessionsBuiltUpTo)
+	if err != nil {
+		return err
+	}
+	since := time.Now().AddDate(0, 0, -14)
+	for _, userId := range userIds {
+		err = buildSessionsForUser(userId, since)
+		if err != nil {
+			return err
+		}
+	}
+	sessionsBuiltUpTo = mark
+	return nil
+}
+
+// buildSessionsForUser merges the user's clips and games since the given time into ranges of continuous play
+// and stores each range as a session. Running it again over the same period leaves the sessions unchanged.
+func buildSessionsForUser(userId int, since time.Time) error {
+	activities, err := db.GetActivityForUserSince(userId, since)
+	if err != nil {
+		return err
+	}
+
+	var ranges []db.Activity
+	for _, activity := range activities {
+		if len(ranges) > 0 {
+			last := &ranges[len(ranges)-1]
+			if !activity.Start.After(last.End.Add(sessionGap)) {
+				if activity.End.After(last.End) {
+					last.End = activity.End
+				}
+				continue
+			}
+		}
+		ranges = append(ranges, activity)
+	}
+
+	for _, r := range ranges {
+		err = db.MergeSession(userId, r.Start, r.End, sessionGap)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"ClipsArchiver/internal/config"
+	"ClipsArchiver/internal/db"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const userIdKey = "userId"
+const roleKey = "role"
+const apiTokenHashKey = "apiTokenHash"
+const apiTokenPrefix = "ca_"
+const minPasswordLength = 8
+const maxPasswordLength = 72
+
+var ErrInvalidToken = errors.New("invalid token")
+var ErrPasswordLength = errors.New("password must be between 8 and 72 characters")
+
+// dummyHash is compared against when a username doesn't exist so failed logins take the same time either way
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("clipsarchiver"), bcrypt.DefaultCost)
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+}
+
+type jwtClaims struct {
+	Subject   int   `json:"sub"`
+	IssuedAt  int64 `json:"iat"`
+	ExpiresAt int64 `json:"exp"`
+}
+
+func HashPassword(password string) (string, error) {
+	// bcrypt only looks at the first 72 bytes
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return "", ErrPasswordLength
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// CheckCredentials returns the id of the user with the username if the password matches
+func CheckCredentials(username string, password string) (int, bool) {
+	credentials, err := db.GetCredentialsByUsername(username)
+	if err != nil {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return 0, false
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(credentials.PasswordHash), []byte(password))
+	return credentials.UserId, err == nil
+}
+
+// BootstrapAdmin sets up the initial admin from the auth config while no user has credentials, so a new or
+// upgraded server never has an unauthenticated way to claim an account
+func BootstrapAdmin() error {
+	initialAdmin := config.GetInitialAdmin()
+	if initialAdmin.Username == "" {
+		return nil
+	}
+	if initialAdmin.UserId <= 0 && initialAdmin.Name == "" {
+		initialAdmin.Name = initialAdmin.Username
+	}
+	passwordHash, err := HashPassword(initialAdmin.Password)
+	if err != nil {
+		return err
+	}
+	_, err = db.BootstrapAdmin(initialAdmin.UserId, initialAdmin.Name, db.Credentials{Username: initialAdmin.Username, PasswordHash: passwordHash})
+	return err
+}
+
+// NewApiToken generates a random token, returning it along with the hash to store
+func NewApiToken() (string, string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", "", err
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(tokenBytes)
+	return token, hashApiToken(token), nil
+}
+
+func hashApiToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// IssueSession returns a token for a user who just logged in. Sessions are JWTs when a secret is configured,
+// otherwise they're API tokens that expire after the session lifetime.
+func IssueSession(userId int) (string, time.Time, error) {
+	expiresAt := time.Now().Add(config.GetSessionLifetime())
+	secret := config.GetJwtSecret()
+	if secret != "" {
+		token, err := signJwt(jwtClaims{Subject: userId, IssuedAt: time.Now().Unix(), ExpiresAt: expiresAt.Unix()}, secret)
+		return token, expiresAt, err
+	}
+
+	token, tokenHash, err := NewApiToken()
+	if err != nil {
+		return "", expiresAt, err
+	}
+	_, err = db.CreateApiToken(userId, "session", tokenHash, sql.NullTime{Time: expiresAt, Valid: true})
+	return token, expiresAt, err
+}
+
+func signJwt(claims jwtClaims, secret string) (string, error) {
+	headerJson, err := json.Marshal(jwtHeader{Algorithm: "HS256", Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claimsJson, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(headerJson) + "." + base64.RawURLEncoding.EncodeToString(claimsJson)
+	return unsigned + "." + jwtSignature(unsigned, secret), nil
+}
+
+func jwtSignature(unsigned string, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseJwt checks the signature and expiry of a HS256 JWT and returns its claims
+func parseJwt(token string, secret string) (jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return jwtClaims{}, ErrInvalidToken
+	}
+	expected := jwtSignature(parts[0]+"."+parts[1], secret)
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return jwtClaims{}, ErrInvalidToken
+	}
+
+	headerJson, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return jwtClaims{}, ErrInvalidToken
+	}
+	var header jwtHeader
+	if err = json.Unmarshal(headerJson, &header); err != nil || header.Algorithm != "HS256" {
+		return jwtClaims{}, ErrInvalidToken
+	}
+
+	claimsJson, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return jwtClaims{}, ErrInvalidToken
+	}
+	var claims jwtClaims
+	if err = json.Unmarshal(claimsJson, &claims); err != nil {
+		return jwtClaims{}, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return jwtClaims{}, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// userIdForToken resolves either kind of token to the user it belongs to
+func userIdForToken(token string) (int, error) {
+	if strings.HasPrefix(token, apiTokenPrefix) {
+		userId, err := db.GetUserIdForApiToken(hashApiToken(token))
+		if err != nil {
+			return 0, ErrInvalidToken
+		}
+		return userId, nil
+	}
+	secret := config.GetJwtSecret()
+	if secret == "" {
+		return 0, ErrInvalidToken
+	}
+	claims, err := parseJwt(token, secret)
+	if err != nil {
+		return 0, err
+	}
+	// sessions from before a password change are revoked by it
+	changedAt, err := db.GetCredentialsChangedAt(claims.Subject)
+	if err != nil || (changedAt.Valid && claims.IssuedAt < changedAt.Time.Unix()) {
+		return 0, ErrInvalidToken
+	}
+	return claims.Subject, nil
+}
+
+// requestToken reads the bearer token from the Authorization header. Tokens are never read from the url, where
+// they'd end up in access logs and referrers, video players use the signed clip urls instead.
+func requestToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if token, found := strings.CutPrefix(header, "Bearer "); found {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// Authenticate rejects requests that don't carry a valid token and makes the caller available through UserId
+func Authenticate(c *gin.Context) {
+	token := requestToken(c)
+	if token == "" {
+		c.String(http.StatusUnauthorized, "authentication required")
+		c.Abort()
+		return
+	}
+	if !identify(c, token) {
+		c.String(http.StatusUnauthorized, "invalid or expired token")
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// Identify is Authenticate for routes that also serve anonymous callers, it never rejects a request
+func Identify(c *gin.Context) {
+	if token := requestToken(c); token != "" {
+		identify(c, token)
+	}
+	c.Next()
+}
+
+// identify stores the user the token belongs to and their role on the context
+func identify(c *gin.Context, token string) bool {
+	userId, err := userIdForToken(token)
+	if err != nil {
+		return false
+	}
+	role, err := db.GetUserRole(userId)
+	if err != nil {
+		return false
+	}
+	SetCaller(c, userId, role)
+	if strings.HasPrefix(token, apiTokenPrefix) {
+		c.Set(apiTokenHashKey, hashApiToken(token))
+	}
+	return true
+}
+
+// SetCaller records who is making the request and their role, for UserId and the authorization checks
+func SetCaller(c *gin.Context, userId int, role string) {
+	c.Set(userIdKey, userId)
+	c.Set(roleKey, role)
+}
+
+// UserId returns the authenticated caller, or false for anonymous requests
+func UserId(c *gin.Context) (int, bool) {
+	userId, ok := c.Get(userIdKey)
+	if !ok {
+		return 0, false
+	}
+	return userId.(int), true
+}
+
+// ApiTokenHash returns the hash of the API token the request was made with, empty for JWT sessions
+func ApiTokenHash(c *gin.Context) string {
+	return c.GetString(apiTokenHashKey)
+}
+
+// IsUser reports whether the caller is the user with the id
+func IsUser(c *gin.Context, userId int) bool {
+	callerId, ok := UserId(c)
+	return ok && callerId == userId
+}
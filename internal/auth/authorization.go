@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"ClipsArchiver/internal/db"
+	"github.com/gin-gonic/gin"
+)
+
+// Admins can do anything, members can upload and manage their own clips and shared data like tags,
+// viewers can only watch and keep their own favorites and reactions.
+
+// Role returns the role of the caller, anonymous callers have no role
+func Role(c *gin.Context) string {
+	return c.GetString(roleKey)
+}
+
+func IsAdmin(c *gin.Context) bool {
+	return Role(c) == db.RoleAdmin
+}
+
+// CanContribute reports whether the caller can add content, such as uploading clips, commenting or editing tags
+func CanContribute(c *gin.Context) bool {
+	role := Role(c)
+	return role == db.RoleAdmin || role == db.RoleMember
+}
+
+// CanModify reports whether the caller can change or delete something owned by ownerId
+func CanModify(c *gin.Context, ownerId int) bool {
+	return IsAdmin(c) || (CanContribute(c) && IsUser(c, ownerId))
+}
+
+// Viewer returns who clip lookups are made for, so they leave out clips the caller isn't allowed to see
+func Viewer(c *gin.Context) db.Viewer {
+	userId, _ := UserId(c)
+	return db.Viewer{UserId: userId, IsAdmin: IsAdmin(c)}
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"ClipsArchiver/internal/db"
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"testing"
+)
+
+const ownerId = 1
+const otherId = 2
+
+// callerContext returns a context for a caller with the user id and role, or an anonymous one when userId is 0
+func callerContext(userId int, role string) *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if userId != 0 {
+		c.Set(userIdKey, userId)
+		c.Set(roleKey, role)
+	}
+	return c
+}
+
+func TestRoles(t *testing.T) {
+	tests := []struct {
+		name              string
+		c                 *gin.Context
+		wantAdmin         bool
+		wantCanContribute bool
+	}{
+		{"admin", callerContext(ownerId, db.RoleAdmin), true, true},
+		{"member", callerContext(ownerId, db.RoleMember), false, true},
+		{"viewer", callerContext(ownerId, db.RoleViewer), false, false},
+		{"anonymous", callerContext(0, ""), false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IsAdmin(test.c); got != test.wantAdmin {
+				t.Errorf("IsAdmin() = %v, want %v", got, test.wantAdmin)
+			}
+			if got := CanContribute(test.c); got != test.wantCanContribute {
+				t.Errorf("CanContribute() = %v, want %v", got, test.wantCanContribute)
+			}
+		})
+	}
+}
+
+func TestCanModify(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       *gin.Context
+		ownerId int
+		want    bool
+	}{
+		{"admin changing their own", callerContext(ownerId, db.RoleAdmin), ownerId, true},
+		{"admin changing someone else's", callerContext(ownerId, db.RoleAdmin), otherId, true},
+		{"member changing their own", callerContext(ownerId, db.RoleMember), ownerId, true},
+		{"member changing someone else's", callerContext(ownerId, db.RoleMember), otherId, false},
+		{"viewer changing their own", callerContext(ownerId, db.RoleViewer), ownerId, false},
+		{"viewer changing someone else's", callerContext(ownerId, db.RoleViewer), otherId, false},
+		{"anonymous", callerContext(0, ""), ownerId, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := CanModify(test.c, test.ownerId); got != test.want {
+				t.Errorf("CanModify(%d) = %v, want %v", test.ownerId, got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsUser(t *testing.T) {
+	c := callerContext(ownerId, db.RoleViewer)
+	if !IsUser(c, ownerId) {
+		t.Errorf("IsUser(%d) = false for the caller", ownerId)
+	}
+	if IsUser(c, otherId) {
+		t.Errorf("IsUser(%d) = true for another user", otherId)
+	}
+	if IsUser(callerContext(0, ""), 0) {
+		t.Error("IsUser(0) = true for an anonymous caller")
+	}
+}
+
+func TestViewer(t *testing.T) {
+	tests := []struct {
+		name string
+		c    *gin.Context
+		want db.Viewer
+	}{
+		{"admin", callerContext(ownerId, db.RoleAdmin), db.Viewer{UserId: ownerId, IsAdmin: true}},
+		{"member", callerContext(ownerId, db.RoleMember), db.Viewer{UserId: ownerId}},
+		{"viewer", callerContext(ownerId, db.RoleViewer), db.Viewer{UserId: ownerId}},
+		{"anonymous", callerContext(0, ""), db.Viewer{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Viewer(test.c); got != test.want {
+				t.Errorf("Viewer() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestViewerCanSee(t *testing.T) {
+	clip := func(visibility string) db.Clip {
+		return db.Clip{OwnerId: ownerId, Visibility: visibility}
+	}
+	tests := []struct {
+		name string
+		c    *gin.Context
+		clip db.Clip
+		want bool
+	}{
+		{"owner sees their private clip", callerContext(ownerId, db.RoleMember), clip(db.VisibilityPrivate), true},
+		{"member doesn't see someone else's private clip", callerContext(otherId, db.RoleMember), clip(db.VisibilityPrivate), false},
+		{"admin sees someone else's private clip", callerContext(otherId, db.RoleAdmin), clip(db.VisibilityPrivate), true},
+		{"viewer sees a group clip", callerContext(otherId, db.RoleViewer), clip(db.VisibilityGroup), true},
+		{"anonymous doesn't see a group clip", callerContext(0, ""), clip(db.VisibilityGroup), false},
+		{"anonymous sees a public clip", callerContext(0, ""), clip(db.VisibilityPublic), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Viewer(test.c).CanSee(test.clip); got != test.want {
+				t.Errorf("CanSee(%s clip) = %v, want %v", test.clip.Visibility, got, test.want)
+			}
+		})
+	}
+}
@@ -7,11 +7,55 @@ import (
 	"io"
 	"log"
 	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
+// StoreConfig says where files are kept and where they're served from. Backend is local, the default, or s3.
+// Partial uploads and scratch files are always kept under CacheStorePath, since they're written in place.
+// BaseUri is the address clients reach the server on, PublicBaseUri the one people outside the network reach it on
+// for share links, which falls back to BaseUri.
 type StoreConfig struct {
-	CacheStorePath string `json:"cacheStorePath"`
-	StorePath      string `json:"storePath"`
+	BaseUri        string         `json:"baseUri"`
+	PublicBaseUri  string         `json:"publicBaseUri"`
+	CacheStorePath string         `json:"cacheStorePath"`
+	StorePath      string         `json:"storePath"`
+	Backend        string         `json:"backend"`
+	S3             S3Config       `json:"s3"`
+	Tiering        TieringConfig  `json:"tiering"`
+	Reencode       ReencodeConfig `json:"reencode"`
+}
+
+// TieringConfig keeps clips people watch on a hot tier under CacheStorePath, and moves clips that haven't been
+// viewed for ColdAfterDays to the cold tier, which is StorePath or the bucket. Clips that are viewed again, or
+// favorited when KeepFavoritesHot is set, move back.
+type TieringConfig struct {
+	Enabled          bool `json:"enabled"`
+	ColdAfterDays    int  `json:"coldAfterDays"`
+	KeepFavoritesHot bool `json:"keepFavoritesHot"`
+	IntervalMinutes  int  `json:"intervalMinutes"`
+}
+
+// ReencodeConfig re-encodes clips recorded more than OlderThanDays ago to Codec, hevc or av1, to save space. Quality
+// is the encoder's crf, lower is better, and 0 uses the encoder's default.
+type ReencodeConfig struct {
+	Enabled         bool   `json:"enabled"`
+	OlderThanDays   int    `json:"olderThanDays"`
+	Codec           string `json:"codec"`
+	Quality         int    `json:"quality"`
+	IntervalMinutes int    `json:"intervalMinutes"`
+}
+
+// S3Config is an S3 compatible bucket. Endpoint is only needed for services other than AWS, such as MinIO, which
+// usually also need UsePathStyle.
+type S3Config struct {
+	Endpoint        string `json:"endpoint"`
+	Region          string `json:"region"`
+	Bucket          string `json:"bucket"`
+	AccessKeyId     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey"`
+	UsePathStyle    bool   `json:"usePathStyle"`
 }
 
 type MatchHistoryConfig struct {
@@ -25,19 +69,105 @@ type DatabaseConfig struct {
 	Name     string `json:"dbName"`
 }
 
+type AuthConfig struct {
+	JwtSecret              string       `json:"jwtSecret"`
+	SessionLifetimeMinutes int          `json:"sessionLifetimeMinutes"`
+	UrlSigningSecret       string       `json:"urlSigningSecret"`
+	InitialAdmin           InitialAdmin `json:"initialAdmin"`
+}
+
+// InitialAdmin is the admin set up on startup while nobody has credentials. UserId gives an existing user these
+// credentials, when it's 0 a new user called Name is created. It's ignored once any user has credentials.
+type InitialAdmin struct {
+	UserId   int    `json:"userId"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// UploadLimits decides which uploads are accepted as clips. Containers are matched against the format names
+// ffprobe reports, and a limit of 0 means no limit.
+type UploadLimits struct {
+	Containers         []string `json:"containers"`
+	MinDurationSeconds float64  `json:"minDurationSeconds"`
+	MaxDurationSeconds float64  `json:"maxDurationSeconds"`
+	MinWidth           int      `json:"minWidth"`
+	MinHeight          int      `json:"minHeight"`
+	MaxWidth           int      `json:"maxWidth"`
+	MaxHeight          int      `json:"maxHeight"`
+}
+
+// IngestFolder is a folder clipsingest watches for recordings, which become clips of OwnerId. Game is a game slug,
+// Apex Legends when empty, and Timezone is the IANA timezone the recordings were made in, the server's when empty.
+// AfterIngest is keep, move or delete, and moved originals go to MoveTo.
+type IngestFolder struct {
+	Path        string `json:"path"`
+	OwnerId     int    `json:"ownerId"`
+	Game        string `json:"game"`
+	Timezone    string `json:"timezone"`
+	Recursive   bool   `json:"recursive"`
+	AfterIngest string `json:"afterIngest"`
+	MoveTo      string `json:"moveTo"`
+}
+
+// IngestConfig is what clipsingest watches. Files are only ingested once their size and modification time haven't
+// changed for StableSeconds, so recordings still being written are left alone.
+type IngestConfig struct {
+	Folders             []IngestFolder `json:"folders"`
+	PollIntervalSeconds int            `json:"pollIntervalSeconds"`
+	StableSeconds       int            `json:"stableSeconds"`
+}
+
+// Where StoreConfig keeps files
+const (
+	StorageBackendLocal = "local"
+	StorageBackendS3    = "s3"
+)
+
 const configFileLoadError = "Error loading config file"
 const inputPath = "/Uploads/"
+const partialUploadsPath = "/PartialUploads/"
+const scratchPath = "/Scratch/"
+const hotClipsPath = "/Clips/"
 const outputPath = "/Clips/"
 const thumbnailsPath = "/Thumbnails/"
 const resourcesPath = "/Resources/"
 const storeConfigFile = "config.json"
 const matchHistoryConfigFile = "apiConfig.json"
 const dbConfigFile = "dbConfig.json"
+const authConfigFile = "authConfig.json"
+const uploadConfigFile = "uploadConfig.json"
+const ingestConfigFile = "ingestConfig.json"
+const defaultBaseUri = "http://10.0.0.10:8080"
+const defaultSessionLifetime = 12 * time.Hour
+const defaultColdAfterDays = 90
+const defaultTieringInterval = time.Hour
+const defaultReencodeAfterDays = 180
+const defaultReencodeCodec = "hevc"
+const defaultReencodeInterval = time.Hour
+const defaultIngestPollInterval = 30 * time.Second
+const defaultIngestStableTime = 10 * time.Second
 
 var storeConfig *StoreConfig
 var matchHistoryConfig *MatchHistoryConfig
 var databaseConfig *DatabaseConfig
-var configLoaded bool
+var authConfig *AuthConfig
+var uploadLimits *UploadLimits
+var ingestConfig *IngestConfig
+
+var defaultUploadLimits = UploadLimits{
+	Containers:         []string{"mov", "mp4", "matroska", "webm", "avi", "mpegts"},
+	MinDurationSeconds: 1,
+	MaxDurationSeconds: 30 * 60,
+	MinWidth:           320,
+	MinHeight:          240,
+	MaxWidth:           7680,
+	MaxHeight:          4320,
+}
+
+// configOnce loads the config the first time any of it is read. The files are only read once, so goroutines
+// like the background workers can read the config at the same time.
+var configOnce sync.Once
 
 func LoadConfig() {
 	if CheckCreateConfigFiles() {
@@ -48,6 +178,9 @@ func LoadConfig() {
 	storeConfig = &StoreConfig{}
 	matchHistoryConfig = &MatchHistoryConfig{}
 	databaseConfig = &DatabaseConfig{}
+	authConfig = &AuthConfig{}
+	uploadLimits = &UploadLimits{}
+	ingestConfig = &IngestConfig{}
 
 	file, err := os.Open(storeConfigFile)
 	if err != nil {
@@ -60,6 +193,9 @@ func LoadConfig() {
 		}
 	}(file)
 	fileBytes, err := io.ReadAll(file)
+	if err != nil {
+		log.Fatal(configFileLoadError)
+	}
 	err = json.Unmarshal(fileBytes, storeConfig)
 	if err != nil {
 		log.Fatal(configFileLoadError)
@@ -76,6 +212,9 @@ func LoadConfig() {
 		}
 	}(file)
 	fileBytes, err = io.ReadAll(file)
+	if err != nil {
+		log.Fatal(configFileLoadError)
+	}
 	err = json.Unmarshal(fileBytes, matchHistoryConfig)
 	if err != nil {
 		log.Fatal(configFileLoadError)
@@ -92,10 +231,70 @@ func LoadConfig() {
 		}
 	}(file)
 	fileBytes, err = io.ReadAll(file)
+	if err != nil {
+		log.Fatal(configFileLoadError)
+	}
 	err = json.Unmarshal(fileBytes, databaseConfig)
 	if err != nil {
 		log.Fatal(configFileLoadError)
 	}
+
+	file, err = os.Open(authConfigFile)
+	if err != nil {
+		log.Fatal(configFileLoadError)
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Fatal(configFileLoadError)
+		}
+	}(file)
+	fileBytes, err = io.ReadAll(file)
+	if err != nil {
+		log.Fatal(configFileLoadError)
+	}
+	err = json.Unmarshal(fileBytes, authConfig)
+	if err != nil {
+		log.Fatal(configFileLoadError)
+	}
+
+	file, err = os.Open(uploadConfigFile)
+	if err != nil {
+		log.Fatal(configFileLoadError)
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Fatal(configFileLoadError)
+		}
+	}(file)
+	fileBytes, err = io.ReadAll(file)
+	if err != nil {
+		log.Fatal(configFileLoadError)
+	}
+	err = json.Unmarshal(fileBytes, uploadLimits)
+	if err != nil {
+		log.Fatal(configFileLoadError)
+	}
+
+	file, err = os.Open(ingestConfigFile)
+	if err != nil {
+		log.Fatal(configFileLoadError)
+	}
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Fatal(configFileLoadError)
+		}
+	}(file)
+	fileBytes, err = io.ReadAll(file)
+	if err != nil {
+		log.Fatal(configFileLoadError)
+	}
+	err = json.Unmarshal(fileBytes, ingestConfig)
+	if err != nil {
+		log.Fatal(configFileLoadError)
+	}
 }
 
 func CheckCreateConfigFiles() bool {
@@ -106,7 +305,25 @@ func CheckCreateConfigFiles() bool {
 		if err != nil {
 			log.Fatal(err)
 		}
-		newStoreConfig := StoreConfig{CacheStorePath: "", StorePath: ""}
+		newStoreConfig := StoreConfig{
+			BaseUri:        defaultBaseUri,
+			PublicBaseUri:  "",
+			CacheStorePath: "",
+			StorePath:      "",
+			Backend:        StorageBackendLocal,
+			Tiering: TieringConfig{
+				Enabled:          false,
+				ColdAfterDays:    defaultColdAfterDays,
+				KeepFavoritesHot: true,
+				IntervalMinutes:  int(defaultTieringInterval.Minutes()),
+			},
+			Reencode: ReencodeConfig{
+				Enabled:         false,
+				OlderThanDays:   defaultReencodeAfterDays,
+				Codec:           defaultReencodeCodec,
+				IntervalMinutes: int(defaultReencodeInterval.Minutes()),
+			},
+		}
 		jsonBytes, err := json.Marshal(newStoreConfig)
 		if err != nil {
 			log.Fatal(err)
@@ -153,47 +370,221 @@ func CheckCreateConfigFiles() bool {
 			log.Fatal(err)
 		}
 	}
+	if _, err := os.Stat(authConfigFile); errors.Is(err, os.ErrNotExist) {
+		anyFilesCreated = true
+		file, err := os.Create(authConfigFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		newAuthConfig := AuthConfig{JwtSecret: "", SessionLifetimeMinutes: int(defaultSessionLifetime.Minutes()), UrlSigningSecret: ""}
+		jsonBytes, err := json.Marshal(newAuthConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		_, err = file.Write(jsonBytes)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(uploadConfigFile); errors.Is(err, os.ErrNotExist) {
+		anyFilesCreated = true
+		file, err := os.Create(uploadConfigFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		jsonBytes, err := json.Marshal(defaultUploadLimits)
+		if err != nil {
+			log.Fatal(err)
+		}
+		_, err = file.Write(jsonBytes)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(ingestConfigFile); errors.Is(err, os.ErrNotExist) {
+		anyFilesCreated = true
+		file, err := os.Create(ingestConfigFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		newIngestConfig := IngestConfig{
+			Folders:             []IngestFolder{},
+			PollIntervalSeconds: int(defaultIngestPollInterval.Seconds()),
+			StableSeconds:       int(defaultIngestStableTime.Seconds()),
+		}
+		jsonBytes, err := json.Marshal(newIngestConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		_, err = file.Write(jsonBytes)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 	return anyFilesCreated
 }
 
 func GetInputPath() string {
-	if !configLoaded {
-		LoadConfig()
-	}
+	configOnce.Do(LoadConfig)
 	return storeConfig.CacheStorePath + inputPath
 }
 
-func GetOutputPath() string {
-	if !configLoaded {
-		LoadConfig()
+// GetPartialUploadsPath is where resumable uploads are kept until every chunk has arrived
+func GetPartialUploadsPath() string {
+	configOnce.Do(LoadConfig)
+	return storeConfig.CacheStorePath + partialUploadsPath
+}
+
+// GetScratchPath is where files from a remote store are worked on locally
+func GetScratchPath() string {
+	configOnce.Do(LoadConfig)
+	return storeConfig.CacheStorePath + scratchPath
+}
+
+// GetStorageBackend returns local or s3, and the bucket to use for s3
+func GetStorageBackend() (string, S3Config) {
+	configOnce.Do(LoadConfig)
+	if storeConfig.Backend == "" {
+		return StorageBackendLocal, storeConfig.S3
 	}
+	return storeConfig.Backend, storeConfig.S3
+}
+
+// GetBaseUri returns the address clients reach the server on, without a trailing slash
+func GetBaseUri() string {
+	configOnce.Do(LoadConfig)
+	if storeConfig.BaseUri == "" {
+		return defaultBaseUri
+	}
+	return strings.TrimSuffix(storeConfig.BaseUri, "/")
+}
+
+// GetPublicBaseUri returns the address share links are handed out on, without a trailing slash
+func GetPublicBaseUri() string {
+	configOnce.Do(LoadConfig)
+	if storeConfig.PublicBaseUri == "" {
+		return GetBaseUri()
+	}
+	return strings.TrimSuffix(storeConfig.PublicBaseUri, "/")
+}
+
+// GetHotClipsPath is the hot tier, where clips are kept while they're being watched when tiering is enabled
+func GetHotClipsPath() string {
+	configOnce.Do(LoadConfig)
+	return storeConfig.CacheStorePath + hotClipsPath
+}
+
+// GetTiering returns the tiering policy, with the defaults filled in for anything left out
+func GetTiering() TieringConfig {
+	configOnce.Do(LoadConfig)
+	tiering := storeConfig.Tiering
+	if tiering.ColdAfterDays <= 0 {
+		tiering.ColdAfterDays = defaultColdAfterDays
+	}
+	if tiering.IntervalMinutes <= 0 {
+		tiering.IntervalMinutes = int(defaultTieringInterval.Minutes())
+	}
+	return tiering
+}
+
+// GetReencode returns the archive re-encoding policy, with the defaults filled in for anything left out
+func GetReencode() ReencodeConfig {
+	configOnce.Do(LoadConfig)
+	reencode := storeConfig.Reencode
+	if reencode.OlderThanDays <= 0 {
+		reencode.OlderThanDays = defaultReencodeAfterDays
+	}
+	if reencode.Codec == "" {
+		reencode.Codec = defaultReencodeCodec
+	}
+	if reencode.IntervalMinutes <= 0 {
+		reencode.IntervalMinutes = int(defaultReencodeInterval.Minutes())
+	}
+	return reencode
+}
+
+func GetOutputPath() string {
+	configOnce.Do(LoadConfig)
 	return storeConfig.StorePath + outputPath
 }
 
 func GetThumbnailsPath() string {
-	if !configLoaded {
-		LoadConfig()
-	}
+	configOnce.Do(LoadConfig)
 	return storeConfig.CacheStorePath + thumbnailsPath
 }
 
 func GetResourcesPath() string {
-	if !configLoaded {
-		LoadConfig()
-	}
+	configOnce.Do(LoadConfig)
 	return storeConfig.CacheStorePath + resourcesPath
 }
 
 func GetApiKey() string {
-	if !configLoaded {
-		LoadConfig()
-	}
+	configOnce.Do(LoadConfig)
 	return matchHistoryConfig.AlsApiKey
 }
 
 func GetDatabaseInfo() *DatabaseConfig {
-	if !configLoaded {
-		LoadConfig()
-	}
+	configOnce.Do(LoadConfig)
 	return databaseConfig
 }
+
+// GetJwtSecret returns the key used to sign session JWTs. Sessions are issued as API tokens instead when it's empty.
+func GetJwtSecret() string {
+	configOnce.Do(LoadConfig)
+	return authConfig.JwtSecret
+}
+
+func GetSessionLifetime() time.Duration {
+	configOnce.Do(LoadConfig)
+	if authConfig.SessionLifetimeMinutes <= 0 {
+		return defaultSessionLifetime
+	}
+	return time.Duration(authConfig.SessionLifetimeMinutes) * time.Minute
+}
+
+// GetInitialAdmin returns the admin to set up while nobody has credentials, its Username is empty when there isn't one
+func GetInitialAdmin() InitialAdmin {
+	configOnce.Do(LoadConfig)
+	return authConfig.InitialAdmin
+}
+
+// GetUrlSigningSecret returns the key used to sign clip and thumbnail urls
+func GetUrlSigningSecret() string {
+	configOnce.Do(LoadConfig)
+	return authConfig.UrlSigningSecret
+}
+
+// GetUploadLimits returns the limits uploads are validated against. Containers falls back to the defaults when
+// none are configured.
+func GetUploadLimits() UploadLimits {
+	configOnce.Do(LoadConfig)
+	limits := *uploadLimits
+	if len(limits.Containers) == 0 {
+		limits.Containers = defaultUploadLimits.Containers
+	}
+	return limits
+}
+
+// GetIngestFolders returns the folders clipsingest watches
+func GetIngestFolders() []IngestFolder {
+	configOnce.Do(LoadConfig)
+	return ingestConfig.Folders
+}
+
+// GetIngestPollInterval is how often clipsingest looks through its folders when inotify doesn't wake it first
+func GetIngestPollInterval() time.Duration {
+	configOnce.Do(LoadConfig)
+	if ingestConfig.PollIntervalSeconds <= 0 {
+		return defaultIngestPollInterval
+	}
+	return time.Duration(ingestConfig.PollIntervalSeconds) * time.Second
+}
+
+// GetIngestStableTime is how long a file has to stay the same before clipsingest takes it as finished
+func GetIngestStableTime() time.Duration {
+	configOnce.Do(LoadConfig)
+	if ingestConfig.StableSeconds <= 0 {
+		return defaultIngestStableTime
+	}
+	return time.Duration(ingestConfig.StableSeconds) * time.Second
+}
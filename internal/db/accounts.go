@@ -0,0 +1,287 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleViewer = "viewer"
+)
+
+// ErrUsernameTaken is returned when credentials are set with a username another user already has
+var ErrUsernameTaken = errors.New("username already taken")
+
+const userColumns = "users.id, users.name, users.apex_username, users.apex_uid, users.username, users.role, users.default_visibility"
+
+// Credentials are what a user logs in with. The password is only ever stored hashed.
+type Credentials struct {
+	UserId       int
+	Username     string
+	PasswordHash string
+}
+
+// ApiToken describes an issued token. The token itself is only shown once when it's created, only its hash is stored.
+type ApiToken struct {
+	Id         int          `json:"id"`
+	UserId     int          `json:"userId"`
+	Name       string       `json:"name"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	LastUsedAt sql.NullTime `json:"lastUsedAt"`
+	ExpiresAt  sql.NullTime `json:"expiresAt"`
+}
+
+const apiTokenColumns = "api_tokens.id, api_tokens.user_id, api_tokens.name, api_tokens.created_at, api_tokens.last_used_at, api_tokens.expires_at"
+
+func scanUser(row rowScanner, user *User) error {
+	return row.Scan(&user.Id, &user.Name, &user.ApexUsername, &user.ApexUid, &user.Username, &user.Role, &user.DefaultVisibility)
+}
+
+func scanApiToken(row rowScanner, apiToken *ApiToken) error {
+	return row.Scan(&apiToken.Id, &apiToken.UserId, &apiToken.Name, &apiToken.CreatedAt, &apiToken.LastUsedAt, &apiToken.ExpiresAt)
+}
+
+func GetUserById(userId int) (User, error) {
+	logger.Debug(fmt.Sprintf("Getting user with id %d", userId))
+	var user User
+	row := db.QueryRow("SELECT "+userColumns+" FROM users WHERE users.id = ?", userId)
+	err := scanUser(row, &user)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to get user with id %d: %s", userId, err.Error()))
+	}
+	return user, err
+}
+
+func IsValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleMember || role == RoleViewer
+}
+
+func GetUserRole(userId int) (string, error) {
+	var role string
+	err := db.QueryRow("SELECT users.role FROM users WHERE users.id = ?", userId).Scan(&role)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to get role for user %d: %s", userId, err.Error()))
+	}
+	return role, err
+}
+
+func SetUserRole(userId int, role string) error {
+	logger.Debug(fmt.Sprintf("Setting role of user %d to %s", userId, role))
+	_, err := db.Exec("UPDATE users SET users.role = ? WHERE users.id = ?", role, userId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error setting role for user %d: %s", userId, err.Error()))
+	}
+	return err
+}
+
+// EnsureAdmin makes the first user with credentials an admin when there are no admins. Databases that had
+// credentials before roles were added start out without one.
+func EnsureAdmin() error {
+	result, err := db.Exec("UPDATE users SET users.role = ? WHERE users.id = (SELECT first_user.id FROM (SELECT MIN(users.id) AS id FROM users WHERE users.password_hash IS NOT NULL) AS first_user) AND NOT EXISTS (SELECT 1 FROM (SELECT users.id FROM users WHERE users.role = ?) AS admins)", RoleAdmin, RoleAdmin)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error making sure there's an admin: %s", err.Error()))
+		return err
+	}
+	if promoted, err := result.RowsAffected(); err == nil && promoted > 0 {
+		logger.Info("There were no admins, made the first user with credentials an admin")
+	}
+	return nil
+}
+
+func CreateUser(name string, role string, credentials Credentials) (User, error) {
+	logger.Debug(fmt.Sprintf("Creating user %s", name))
+	result, err := db.Exec("INSERT INTO users (name, apex_username, apex_uid, username, password_hash, role) VALUES (?, '', '', ?, ?, ?)", name, credentials.Username, credentials.PasswordHash, role)
+	if isDuplicateEntry(err) {
+		return User{}, ErrUsernameTaken
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating user %s: %s", name, err.Error()))
+		return User{}, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating user %s: %s", name, err.Error()))
+		return User{}, err
+	}
+	return GetUserById(int(id))
+}
+
+func GetCredentialsByUsername(username string) (Credentials, error) {
+	var credentials Credentials
+	row := db.QueryRow("SELECT users.id, users.username, users.password_hash FROM users WHERE users.username = ? AND users.password_hash IS NOT NULL", username)
+	err := row.Scan(&credentials.UserId, &credentials.Username, &credentials.PasswordHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logger.Error(fmt.Sprintf("Failed to get credentials for %s: %s", username, err.Error()))
+	}
+	return credentials, err
+}
+
+// SetCredentials changes the user's username and password and revokes their API tokens, other than the one with
+// keepTokenHash, so a reset after a compromise locks out anyone holding an old token. JWT sessions issued before
+// the change stop working through GetCredentialsChangedAt.
+func SetCredentials(credentials Credentials, keepTokenHash string) error {
+	logger.Debug(fmt.Sprintf("Setting credentials for user %d", credentials.UserId))
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error setting credentials for user %d: %s", credentials.UserId, err.Error()))
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE users SET users.username = ?, users.password_hash = ?, users.credentials_changed_at = CURRENT_TIMESTAMP WHERE users.id = ?", credentials.Username, credentials.PasswordHash, credentials.UserId)
+	if isDuplicateEntry(err) {
+		return ErrUsernameTaken
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM api_tokens WHERE user_id = ? AND token_hash <> ?", credentials.UserId, keepTokenHash)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error setting credentials for user %d: %s", credentials.UserId, err.Error()))
+	}
+	return err
+}
+
+// GetCredentialsChangedAt returns when the user's credentials last changed, which is invalid if they never have
+func GetCredentialsChangedAt(userId int) (sql.NullTime, error) {
+	var changedAt sql.NullTime
+	err := db.QueryRow("SELECT users.credentials_changed_at FROM users WHERE users.id = ?", userId).Scan(&changedAt)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to get when credentials changed for user %d: %s", userId, err.Error()))
+	}
+	return changedAt, err
+}
+
+func HasCredentials(userId int) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM users WHERE users.id = ? AND users.password_hash IS NOT NULL", userId).Scan(&count)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error checking credentials for user %d: %s", userId, err.Error()))
+	}
+	return count > 0, err
+}
+
+// BootstrapAdmin gives the user with userId credentials and makes them an admin, or creates a new admin named name
+// when userId is 0, as long as nobody has credentials yet. It reports whether the admin was set up, the check and
+// the write are one statement so only one admin can ever be bootstrapped.
+func BootstrapAdmin(userId int, name string, credentials Credentials) (bool, error) {
+	logger.Debug("Bootstrapping the first admin")
+	var result sql.Result
+	var err error
+	if userId > 0 {
+		result, err = db.Exec("UPDATE users SET users.username = ?, users.password_hash = ?, users.role = ? WHERE users.id = ? AND NOT EXISTS (SELECT 1 FROM (SELECT users.id FROM users WHERE users.password_hash IS NOT NULL) AS with_credentials)", credentials.Username, credentials.PasswordHash, RoleAdmin, userId)
+	} else {
+		result, err = db.Exec("INSERT INTO users (name, apex_username, apex_uid, username, password_hash, role) SELECT ?, '', '', ?, ?, ? FROM DUAL WHERE NOT EXISTS (SELECT 1 FROM users WHERE users.password_hash IS NOT NULL)", name, credentials.Username, credentials.PasswordHash, RoleAdmin)
+	}
+	if isDuplicateEntry(err) {
+		return false, ErrUsernameTaken
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error bootstrapping the first admin: %s", err.Error()))
+		return false, err
+	}
+	created, err := result.RowsAffected()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error bootstrapping the first admin: %s", err.Error()))
+		return false, err
+	}
+	if created > 0 {
+		logger.Info("Nobody had credentials, set up the initial admin from the auth config")
+	}
+	return created > 0, nil
+}
+
+func CreateApiToken(userId int, name string, tokenHash string, expiresAt sql.NullTime) (ApiToken, error) {
+	logger.Debug(fmt.Sprintf("Creating api token %s for user %d", name, userId))
+	var apiToken ApiToken
+	result, err := db.Exec("INSERT INTO api_tokens (user_id, name, token_hash, expires_at) VALUES (?, ?, ?, ?)", userId, name, tokenHash, expiresAt)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating api token for user %d: %s", userId, err.Error()))
+		return apiToken, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating api token for user %d: %s", userId, err.Error()))
+		return apiToken, err
+	}
+	row := db.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE api_tokens.id = ?", id)
+	err = scanApiToken(row, &apiToken)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating api token for user %d: %s", userId, err.Error()))
+	}
+	return apiToken, err
+}
+
+func GetApiTokensForUser(userId int) ([]ApiToken, error) {
+	logger.Debug(fmt.Sprintf("Fetching api tokens for user %d", userId))
+	apiTokens := []ApiToken{}
+
+	rows, err := db.Query("SELECT "+apiTokenColumns+" FROM api_tokens WHERE api_tokens.user_id = ? ORDER BY api_tokens.created_at DESC", userId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching api tokens for user %d: %s", userId, err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var apiToken ApiToken
+		if err = scanApiToken(rows, &apiToken); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching api tokens for user %d: %s", userId, err.Error()))
+			return nil, err
+		}
+		apiTokens = append(apiTokens, apiToken)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching api tokens for user %d: %s", userId, err.Error()))
+		return nil, err
+	}
+	return apiTokens, nil
+}
+
+// GetUserIdForApiToken returns the owner of an unexpired token and marks the token as used
+func GetUserIdForApiToken(tokenHash string) (int, error) {
+	var tokenId, userId int
+	row := db.QueryRow("SELECT api_tokens.id, api_tokens.user_id FROM api_tokens WHERE api_tokens.token_hash = ? AND (api_tokens.expires_at IS NULL OR api_tokens.expires_at > CURRENT_TIMESTAMP)", tokenHash)
+	err := row.Scan(&tokenId, &userId)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Error(fmt.Sprintf("Error looking up api token: %s", err.Error()))
+		}
+		return 0, err
+	}
+
+	_, err = db.Exec("UPDATE api_tokens SET api_tokens.last_used_at = CURRENT_TIMESTAMP WHERE api_tokens.id = ?", tokenId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error marking api token %d as used: %s", tokenId, err.Error()))
+	}
+	return userId, nil
+}
+
+// DeleteApiToken revokes one of the user's tokens, returning sql.ErrNoRows if the user has no such token
+func DeleteApiToken(userId int, tokenId int) error {
+	logger.Debug(fmt.Sprintf("Deleting api token %d for user %d", tokenId, userId))
+	result, err := db.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", tokenId, userId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error deleting api token %d: %s", tokenId, err.Error()))
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error deleting api token %d: %s", tokenId, err.Error()))
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
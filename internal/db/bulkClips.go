@@ -0,0 +1,197 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const (
+	BulkAddTags          = "addTags"
+	BulkRemoveTags       = "removeTags"
+	BulkSetLegend        = "setLegend"
+	BulkSetMap           = "setMap"
+	BulkSetGameMode      = "setGameMode"
+	BulkDelete           = "delete"
+	BulkRequeueTranscode = "requeueTranscode"
+
+	BulkStatusOk       = "ok"
+	BulkStatusNotFound = "notFound"
+	BulkStatusInvalid  = "invalid"
+	BulkStatusFailed   = "failed"
+)
+
+var ErrUnknownBulkOperation = errors.New("unknown bulk operation")
+
+type BulkClipOperation struct {
+	ClipIds   []int          `json:"clipIds"`
+	Operation string         `json:"operation"`
+	Tags      []string       `json:"tags"`
+	Legend    sql.NullInt32  `json:"legend"`
+	Map       sql.NullInt32  `json:"map"`
+	GameMode  sql.NullString `json:"gameMode"`
+}
+
+type BulkClipResult struct {
+	ClipId int    `json:"clipId"`
+	Status string `json:"status"`
+}
+
+// GetClipOwners maps each of the clip ids that exist to the id of its owner
+func GetClipOwners(clipIds []int) (map[int]int, error) {
+	owners := make(map[int]int)
+
+	rows, err := db.Query("SELECT clips.id, clips.owner_id FROM clips WHERE clips.id IN ("+placeholders(len(clipIds))+")", intsToArgs(clipIds)...)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching clip owners: %s", err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var clipId, ownerId int
+		if err = rows.Scan(&clipId, &ownerId); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching clip owners: %s", err.Error()))
+			return nil, err
+		}
+		owners[clipId] = ownerId
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching clip owners: %s", err.Error()))
+		return nil, err
+	}
+	return owners, nil
+}
+
+// RunBulkClipOperation applies one operation to every clip in a single transaction. Clips that don't exist are
+// reported and skipped, any other failure rolls back the whole batch and marks every clip as failed.
+func RunBulkClipOperation(operation BulkClipOperation) ([]BulkClipResult, error) {
+	logger.Debug(fmt.Sprintf("Running bulk %s on %d clips", operation.Operation, len(operation.ClipIds)))
+	results := make([]BulkClipResult, len(operation.ClipIds))
+	for i, clipId := range operation.ClipIds {
+		results[i] = BulkClipResult{ClipId: clipId, Status: BulkStatusFailed}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error running bulk %s: %s", operation.Operation, err.Error()))
+		return results, err
+	}
+	defer tx.Rollback()
+
+	statuses := make([]string, len(operation.ClipIds))
+	for i, clipId := range operation.ClipIds {
+		var gameId int
+		err = tx.QueryRow("SELECT game FROM clips WHERE id = ? FOR UPDATE", clipId).Scan(&gameId)
+		if errors.Is(err, sql.ErrNoRows) {
+			statuses[i] = BulkStatusNotFound
+			continue
+		}
+		var valid bool
+		if err == nil {
+			valid, err = isValidBulkValueForGame(tx, operation, gameId)
+		}
+		if err == nil && !valid {
+			statuses[i] = BulkStatusInvalid
+			continue
+		}
+		if err == nil {
+			err = applyBulkClipOperation(tx, operation, clipId)
+		}
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error running bulk %s on clip %d: %s", operation.Operation, clipId, err.Error()))
+			return results, err
+		}
+		statuses[i] = BulkStatusOk
+	}
+
+	if err = tx.Commit(); err != nil {
+		logger.Error(fmt.Sprintf("Error running bulk %s: %s", operation.Operation, err.Error()))
+		return results, err
+	}
+
+	for i := range results {
+		results[i].Status = statuses[i]
+	}
+	return results, nil
+}
+
+// isValidBulkValueForGame checks that the legend or map being set belongs to the clip's game, so a bad id is
+// reported for that clip instead of failing the whole batch on the foreign key
+func isValidBulkValueForGame(tx *sql.Tx, operation BulkClipOperation, gameId int) (bool, error) {
+	var query string
+	var id sql.NullInt32
+	switch operation.Operation {
+	case BulkSetLegend:
+		query, id = "SELECT COUNT(*) FROM legends WHERE legends.id = ? AND legends.game = ?", operation.Legend
+	case BulkSetMap:
+		query, id = "SELECT COUNT(*) FROM maps WHERE maps.id = ? AND maps.game = ?", operation.Map
+	default:
+		return true, nil
+	}
+	// clearing the legend or map is always allowed
+	if !id.Valid {
+		return true, nil
+	}
+	var count int
+	if err := tx.QueryRow(query, id.Int32, gameId).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func applyBulkClipOperation(tx *sql.Tx, operation BulkClipOperation, clipId int) error {
+	switch operation.Operation {
+	case BulkAddTags:
+		for _, tag := range operation.Tags {
+			_, err := tx.Exec("INSERT IGNORE INTO tags (name) VALUES (?)", tag)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec("INSERT IGNORE INTO clips_tags (clip_id, tag_id) SELECT ?, id FROM tags WHERE tags.name = ?", clipId, tag)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case BulkRemoveTags:
+		for _, tag := range operation.Tags {
+			_, err := tx.Exec("DELETE clips_tags FROM clips_tags INNER JOIN tags ON clips_tags.tag_id = tags.id WHERE clips_tags.clip_id = ? AND tags.name = ?", clipId, tag)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case BulkSetLegend:
+		_, err := tx.Exec("UPDATE clips SET clips.legend = ? WHERE clips.id = ?", operation.Legend, clipId)
+		return err
+	case BulkSetMap:
+		_, err := tx.Exec("UPDATE clips SET clips.map = ? WHERE clips.id = ?", operation.Map, clipId)
+		return err
+	case BulkSetGameMode:
+		_, err := tx.Exec("UPDATE clips SET clips.game_mode = ? WHERE clips.id = ?", operation.GameMode, clipId)
+		return err
+	case BulkDelete:
+		return deleteClip(tx, clipId)
+	case BulkRequeueTranscode:
+		return requeueTranscode(tx, clipId)
+	}
+	return ErrUnknownBulkOperation
+}
+
+// requeueTranscode puts the clip back into the pending state so the transcoder picks it up again
+func requeueTranscode(tx *sql.Tx, clipId int) error {
+	var existing int
+	err := tx.QueryRow("SELECT COUNT(*) FROM transcode_requests WHERE transcode_requests.clip_id = ?", clipId).Scan(&existing)
+	if err != nil {
+		return err
+	}
+	if existing == 0 {
+		_, err = tx.Exec("INSERT INTO transcode_requests (clip_id, status) VALUES (?, ?)", clipId, "pending")
+		return err
+	}
+	_, err = tx.Exec("UPDATE transcode_requests SET transcode_requests.status = 'pending', transcode_requests.started_at = NULL, transcode_requests.finished_at = NULL, transcode_requests.error_message = NULL WHERE transcode_requests.clip_id = ?", clipId)
+	return err
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+func GetFavoriteClipsForUser(viewer Viewer, userId int) ([]Clip, error) {
+	logger.Debug(fmt.Sprintf("Fetching favorite clips for user id: %d", userId))
+	clips := []Clip{}
+
+	rows, err := db.Query("SELECT "+clipColumns+" FROM favorites INNER JOIN clips ON favorites.clip_id = clips.id WHERE favorites.user_id = ? AND "+viewer.clipCondition()+" ORDER BY favorites.created_at DESC", userId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching favorite clips for user id: %d. %s", userId, err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var clip Clip
+		if err = scanClip(rows, &clip); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching favorite clips for user id: %d. %s", userId, err.Error()))
+			return nil, err
+		}
+		clips = append(clips, clip)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching favorite clips for user id: %d. %s", userId, err.Error()))
+		return nil, err
+	}
+
+	for i := range clips {
+		populateClip(&clips[i], viewer)
+	}
+	return clips, nil
+}
+
+func AddFavorite(userId int, clipId int) error {
+	logger.Debug(fmt.Sprintf("User %d favoriting clip %d", userId, clipId))
+	_, err := db.Exec("INSERT IGNORE INTO favorites (user_id, clip_id) VALUES (?, ?)", userId, clipId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error favoriting clip %d for user %d: %s", clipId, userId, err.Error()))
+	}
+	return err
+}
+
+func RemoveFavorite(userId int, clipId int) error {
+	logger.Debug(fmt.Sprintf("User %d unfavoriting clip %d", userId, clipId))
+	_, err := db.Exec("DELETE FROM favorites WHERE user_id = ? AND clip_id = ?", userId, clipId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error unfavoriting clip %d for user %d: %s", clipId, userId, err.Error()))
+	}
+	return err
+}
+
+func AddReaction(userId int, clipId int, emoji string) error {
+	logger.Debug(fmt.Sprintf("User %d reacting %s to clip %d", userId, emoji, clipId))
+	_, err := db.Exec("INSERT IGNORE INTO clip_reactions (user_id, clip_id, emoji) VALUES (?, ?, ?)", userId, clipId, emoji)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error adding reaction to clip %d for user %d: %s", clipId, userId, err.Error()))
+	}
+	return err
+}
+
+func RemoveReaction(userId int, clipId int, emoji string) error {
+	logger.Debug(fmt.Sprintf("User %d removing reaction %s from clip %d", userId, emoji, clipId))
+	_, err := db.Exec("DELETE FROM clip_reactions WHERE user_id = ? AND clip_id = ? AND emoji = ?", userId, clipId, emoji)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error removing reaction from clip %d for user %d: %s", clipId, userId, err.Error()))
+	}
+	return err
+}
+
+// GetReactionCountsForClip counts the reactions on a clip by emoji
+func GetReactionCountsForClip(clipId int) (map[string]int, error) {
+	reactions := make(map[string]int)
+
+	rows, err := db.Query("SELECT emoji, COUNT(*) FROM clip_reactions WHERE clip_id = ? GROUP BY emoji", clipId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error getting reactions for clip with id %d: %s", clipId, err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var emoji string
+		var count int
+		if err = rows.Scan(&emoji, &count); err != nil {
+			logger.Error(fmt.Sprintf("Error getting reactions for clip with id %d: %s", clipId, err.Error()))
+			return nil, err
+		}
+		reactions[emoji] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error getting reactions for clip with id %d: %s", clipId, err.Error()))
+		return nil, err
+	}
+	return reactions, nil
+}
+
+// RecordClipView counts a view of the clip unless the same viewer already viewed it within window.
+// Viewers are identified by user id when known, otherwise by address.
+func RecordClipView(clipId int, userId sql.NullInt32, address string, window time.Duration) error {
+	since := time.Now().Add(-window)
+	var err error
+	if userId.Valid {
+		_, err = db.Exec("INSERT INTO clip_views (clip_id, user_id, address) SELECT ?, ?, ? FROM DUAL WHERE NOT EXISTS (SELECT 1 FROM clip_views WHERE clip_id = ? AND user_id = ? AND viewed_at >= ?)", clipId, userId, address, clipId, userId, since)
+	} else {
+		_, err = db.Exec("INSERT INTO clip_views (clip_id, user_id, address) SELECT ?, NULL, ? FROM DUAL WHERE NOT EXISTS (SELECT 1 FROM clip_views WHERE clip_id = ? AND user_id IS NULL AND address = ? AND viewed_at >= ?)", clipId, address, clipId, address, since)
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error recording view of clip %d: %s", clipId, err.Error()))
+	}
+	return err
+}
@@ -0,0 +1,392 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	ClipSortCreatedAt   = "createdAt"
+	ClipSortDuration    = "duration"
+	ClipSortScoreChange = "scoreChange"
+	ClipSortViews       = "views"
+	ClipSortWeeklyViews = "weeklyViews"
+	ClipSortFavorites   = "favorites"
+
+	TagMatchAny = "any"
+	TagMatchAll = "all"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type clipSortField struct {
+	expression string
+	isTime     bool
+	// nullable fields sort their NULLs first ascending and last descending, the way MySQL does
+	nullable bool
+}
+
+var clipSortFields = map[string]clipSortField{
+	ClipSortCreatedAt: {
+		expression: "clips.created_at",
+		isTime:     true,
+	},
+	ClipSortDuration: {
+		expression: "clips.duration",
+		nullable:   true,
+	},
+	ClipSortScoreChange: {
+		expression: "clips.ranked_point_gain",
+		nullable:   true,
+	},
+	ClipSortViews: {
+		expression: "(SELECT COUNT(*) FROM clip_views WHERE clip_views.clip_id = clips.id)",
+	},
+	ClipSortWeeklyViews: {
+		expression: "(SELECT COUNT(*) FROM clip_views WHERE clip_views.clip_id = clips.id AND clip_views.viewed_at >= NOW() - INTERVAL 7 DAY)",
+	},
+	ClipSortFavorites: {
+		expression: "(SELECT COUNT(*) FROM favorites WHERE favorites.clip_id = clips.id)",
+	},
+}
+
+type ClipFilter struct {
+	OwnerIds       []int
+	GameId         sql.NullInt32
+	From           sql.NullTime
+	To             sql.NullTime
+	MapIds         []int
+	LegendIds      []int
+	GameMode       sql.NullString
+	Tags           []string
+	TagMatch       string
+	MinDuration    sql.NullInt32
+	MaxDuration    sql.NullInt32
+	MinScoreChange sql.NullInt32
+	MaxScoreChange sql.NullInt32
+	IsProcessed    sql.NullBool
+	Sort           string
+	Descending     bool
+	Cursor         string
+	Limit          int
+	Viewer         Viewer
+}
+
+type ClipPage struct {
+	Clips      []Clip `json:"clips"`
+	NextCursor string `json:"nextCursor"`
+}
+
+// clipCursor is the position of the last clip on a page, in terms of the sort value and the id used to break ties
+type clipCursor struct {
+	Value  string `json:"v"`
+	IsNull bool   `json:"n,omitempty"`
+	Id     int    `json:"id"`
+}
+
+func IsValidClipSort(sort string) bool {
+	_, ok := clipSortFields[sort]
+	return ok
+}
+
+func placeholders(count int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", count), ", ")
+}
+
+func intsToArgs(values []int) []any {
+	args := make([]any, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return args
+}
+
+// uniqueTagNames drops repeated tag names, ignoring case like the tags table does
+func uniqueTagNames(names []string) []string {
+	var unique []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		key := strings.ToLower(name)
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
+
+func (f ClipFilter) whereClause() (string, []any) {
+	conditions := []string{f.Viewer.clipCondition()}
+	var args []any
+	if len(f.OwnerIds) > 0 {
+		conditions = append(conditions, "clips.owner_id IN ("+placeholders(len(f.OwnerIds))+")")
+		args = append(args, intsToArgs(f.OwnerIds)...)
+	}
+	if f.GameId.Valid {
+		conditions = append(conditions, "clips.game = ?")
+		args = append(args, f.GameId.Int32)
+	}
+	if f.From.Valid {
+		conditions = append(conditions, "clips.created_at >= ?")
+		args = append(args, f.From.Time)
+	}
+	if f.To.Valid {
+		conditions = append(conditions, "clips.created_at < ?")
+		args = append(args, f.To.Time)
+	}
+	if len(f.MapIds) > 0 {
+		conditions = append(conditions, "clips.map IN ("+placeholders(len(f.MapIds))+")")
+		args = append(args, intsToArgs(f.MapIds)...)
+	}
+	if len(f.LegendIds) > 0 {
+		conditions = append(conditions, "clips.legend IN ("+placeholders(len(f.LegendIds))+")")
+		args = append(args, intsToArgs(f.LegendIds)...)
+	}
+	if f.GameMode.Valid {
+		conditions = append(conditions, "clips.game_mode = ?")
+		args = append(args, f.GameMode.String)
+	}
+	if len(f.Tags) > 0 {
+		// matching all tags counts the distinct tags found, so the same tag can't be asked for twice
+		tags := uniqueTagNames(f.Tags)
+		tagQuery := "clips.id IN (SELECT clips_tags.clip_id FROM clips_tags INNER JOIN tags ON clips_tags.tag_id = tags.id WHERE tags.name IN (" + placeholders(len(tags)) + ")"
+		for _, tag := range tags {
+			args = append(args, tag)
+		}
+		if f.TagMatch == TagMatchAll {
+			tagQuery += " GROUP BY clips_tags.clip_id HAVING COUNT(DISTINCT tags.id) = ?"
+			args = append(args, len(tags))
+		}
+		conditions = append(conditions, tagQuery+")")
+	}
+	if f.MinDuration.Valid {
+		conditions = append(conditions, "clips.duration >= ?")
+		args = append(args, f.MinDuration.Int32)
+	}
+	if f.MaxDuration.Valid {
+		conditions = append(conditions, "clips.duration <= ?")
+		args = append(args, f.MaxDuration.Int32)
+	}
+	if f.MinScoreChange.Valid {
+		conditions = append(conditions, "clips.ranked_point_gain >= ?")
+		args = append(args, f.MinScoreChange.Int32)
+	}
+	if f.MaxScoreChange.Valid {
+		conditions = append(conditions, "clips.ranked_point_gain <= ?")
+		args = append(args, f.MaxScoreChange.Int32)
+	}
+	if f.IsProcessed.Valid {
+		conditions = append(conditions, "clips.is_processed = ?")
+		args = append(args, f.IsProcessed.Bool)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+func encodeClipCursor(value any, clipId int) string {
+	cursor := clipCursor{Value: fmt.Sprint(value), Id: clipId}
+	switch value := value.(type) {
+	case time.Time:
+		cursor.Value = value.Format(time.RFC3339Nano)
+	case sql.NullInt64:
+		cursor.Value, cursor.IsNull = fmt.Sprint(value.Int64), !value.Valid
+	}
+	jsonBytes, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(jsonBytes)
+}
+
+// decodeClipCursor returns the sort value of the cursor, nil for a NULL, and the clip id
+func decodeClipCursor(sortField clipSortField, encoded string) (any, int, error) {
+	jsonBytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, 0, ErrInvalidCursor
+	}
+	var cursor clipCursor
+	if err = json.Unmarshal(jsonBytes, &cursor); err != nil {
+		return nil, 0, ErrInvalidCursor
+	}
+	if cursor.IsNull {
+		if !sortField.nullable {
+			return nil, 0, ErrInvalidCursor
+		}
+		return nil, cursor.Id, nil
+	}
+	if sortField.isTime {
+		value, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return nil, 0, ErrInvalidCursor
+		}
+		return value, cursor.Id, nil
+	}
+	var value int
+	if _, err = fmt.Sscan(cursor.Value, &value); err != nil {
+		return nil, 0, ErrInvalidCursor
+	}
+	return value, cursor.Id, nil
+}
+
+// afterCursor is the condition for clips that come after the cursor. NULLs come before every value ascending
+// and after every value descending.
+func (sortField clipSortField) afterCursor(value any, id int, descending bool) (string, []any) {
+	comparison := ">"
+	if descending {
+		comparison = "<"
+	}
+	if value == nil {
+		condition := fmt.Sprintf("(%s IS NULL AND clips.id %s ?)", sortField.expression, comparison)
+		if !descending {
+			condition = fmt.Sprintf("(%s OR %s IS NOT NULL)", condition, sortField.expression)
+		}
+		return condition, []any{id}
+	}
+	condition := fmt.Sprintf("%[1]s %[2]s ? OR (%[1]s = ? AND clips.id %[2]s ?)", sortField.expression, comparison)
+	if sortField.nullable && descending {
+		condition += fmt.Sprintf(" OR %s IS NULL", sortField.expression)
+	}
+	return "(" + condition + ")", []any{value, value, id}
+}
+
+// SearchClips returns one page of clips matching the filter. Pages are keyed on the sort value and clip id
+// of the last clip rather than an offset, so clips uploaded while paging don't shift later pages.
+func SearchClips(filter ClipFilter) (ClipPage, error) {
+	logger.Debug("Searching clips")
+	page := ClipPage{Clips: []Clip{}}
+
+	sortField, ok := clipSortFields[filter.Sort]
+	if !ok {
+		sortField = clipSortFields[ClipSortCreatedAt]
+	}
+
+	where, args := filter.whereClause()
+	direction := "ASC"
+	if filter.Descending {
+		direction = "DESC"
+	}
+
+	if filter.Cursor != "" {
+		value, id, err := decodeClipCursor(sortField, filter.Cursor)
+		if err != nil {
+			return page, err
+		}
+		condition, cursorArgs := sortField.afterCursor(value, id, filter.Descending)
+		where += " AND " + condition
+		args = append(args, cursorArgs...)
+	}
+
+	// the sort value is selected alongside the clip so the cursor can be built from it
+	query := fmt.Sprintf("SELECT %s, %s FROM clips WHERE %s ORDER BY %s %s, clips.id %s LIMIT ?", clipColumns, sortField.expression, where, sortField.expression, direction, direction)
+	args = append(args, filter.Limit+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error searching clips: %s", err.Error()))
+		return page, err
+	}
+
+	defer rows.Close()
+
+	var sortValues []any
+	for rows.Next() {
+		var clip Clip
+		var timeValue time.Time
+		var intValue sql.NullInt64
+		sortValue := any(&intValue)
+		if sortField.isTime {
+			sortValue = &timeValue
+		}
+		if err = scanClip(rows, &clip, sortValue); err != nil {
+			logger.Error(fmt.Sprintf("Error searching clips: %s", err.Error()))
+			return page, err
+		}
+		page.Clips = append(page.Clips, clip)
+		if sortField.isTime {
+			sortValues = append(sortValues, timeValue)
+		} else {
+			sortValues = append(sortValues, intValue)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error searching clips: %s", err.Error()))
+		return page, err
+	}
+
+	if len(page.Clips) > filter.Limit {
+		page.Clips = page.Clips[:filter.Limit]
+		page.NextCursor = encodeClipCursor(sortValues[filter.Limit-1], page.Clips[filter.Limit-1].Id)
+	}
+	for i := range page.Clips {
+		populateClip(&page.Clips[i], filter.Viewer)
+	}
+	return page, nil
+}
+
+type ClipSearchResult struct {
+	Clip
+	Relevance float64 `json:"relevance"`
+}
+
+// fullTextQuery turns free text into a boolean mode query that prefix matches any of the words,
+// dropping characters that have a meaning in boolean mode
+func fullTextQuery(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = word + "*"
+	}
+	return strings.Join(words, " ")
+}
+
+// FullTextSearchClips ranks processed clips by how well their title and description, tag names, legend and map
+// match the text. Title and description matches weigh double.
+func FullTextSearchClips(viewer Viewer, text string, limit int, offset int) ([]ClipSearchResult, error) {
+	logger.Debug(fmt.Sprintf("Full text searching clips for: %s", text))
+	results := []ClipSearchResult{}
+
+	query := fullTextQuery(text)
+	if query == "" {
+		return results, nil
+	}
+
+	rows, err := db.Query("SELECT "+clipColumns+", "+
+		"(MATCH(clips.title, clips.description) AGAINST (? IN BOOLEAN MODE) * 2 + COALESCE(tag_matches.score, 0) + COALESCE(MATCH(legends.name) AGAINST (? IN BOOLEAN MODE), 0) + COALESCE(MATCH(maps.name) AGAINST (? IN BOOLEAN MODE), 0)) AS relevance "+
+		"FROM clips "+
+		"LEFT JOIN legends ON clips.legend = legends.id "+
+		"LEFT JOIN maps ON clips.map = maps.id "+
+		"LEFT JOIN (SELECT clips_tags.clip_id, SUM(MATCH(tags.name) AGAINST (? IN BOOLEAN MODE)) AS score FROM clips_tags INNER JOIN tags ON clips_tags.tag_id = tags.id WHERE MATCH(tags.name) AGAINST (? IN BOOLEAN MODE) GROUP BY clips_tags.clip_id) AS tag_matches ON tag_matches.clip_id = clips.id "+
+		"WHERE clips.is_processed = 1 AND "+viewer.clipCondition()+" "+
+		"HAVING relevance > 0 "+
+		"ORDER BY relevance DESC, clips.id DESC LIMIT ? OFFSET ?",
+		query, query, query, query, query, limit, offset)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error full text searching clips: %s", err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var result ClipSearchResult
+		if err = scanClip(rows, &result.Clip, &result.Relevance); err != nil {
+			logger.Error(fmt.Sprintf("Error full text searching clips: %s", err.Error()))
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error full text searching clips: %s", err.Error()))
+		return nil, err
+	}
+
+	for i := range results {
+		populateClip(&results[i].Clip, viewer)
+	}
+	return results, nil
+}
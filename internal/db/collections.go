@@ -0,0 +1,285 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// ErrCollectionOrder is returned when a reorder doesn't list exactly the clips already in the collection
+var ErrCollectionOrder = errors.New("order must contain every clip in the collection exactly once")
+
+// Collection is a user's ordered list of clips, such as a best of season reel
+type Collection struct {
+	Id          int           `json:"id"`
+	OwnerId     int           `json:"ownerId"`
+	Title       string        `json:"title"`
+	Description string        `json:"description"`
+	CoverClipId sql.NullInt32 `json:"coverClipId"`
+	IsPublic    bool          `json:"isPublic"`
+	CreatedAt   time.Time     `json:"createdAt"`
+	ClipCount   int           `json:"clipCount"`
+	Clips       []Clip        `json:"clips,omitempty"`
+}
+
+const collectionColumns = "collections.id, collections.owner_id, collections.title, collections.description, collections.cover_clip_id, collections.is_public, collections.created_at, (SELECT COUNT(*) FROM collection_clips WHERE collection_clips.collection_id = collections.id)"
+
+func scanCollection(row rowScanner, collection *Collection) error {
+	return row.Scan(&collection.Id, &collection.OwnerId, &collection.Title, &collection.Description, &collection.CoverClipId, &collection.IsPublic, &collection.CreatedAt, &collection.ClipCount)
+}
+
+func GetPublicCollections() ([]Collection, error) {
+	logger.Debug("Fetching public collections")
+	return getCollections("SELECT " + collectionColumns + " FROM collections WHERE collections.is_public = 1 ORDER BY collections.created_at DESC")
+}
+
+func GetCollectionsForUser(userId int) ([]Collection, error) {
+	logger.Debug(fmt.Sprintf("Fetching collections for user id: %d", userId))
+	return getCollections("SELECT "+collectionColumns+" FROM collections WHERE collections.owner_id = ? ORDER BY collections.created_at DESC", userId)
+}
+
+func getCollections(query string, args ...any) ([]Collection, error) {
+	collections := []Collection{}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching collections: %s", err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var collection Collection
+		if err = scanCollection(rows, &collection); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching collections: %s", err.Error()))
+			return nil, err
+		}
+		collections = append(collections, collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching collections: %s", err.Error()))
+		return nil, err
+	}
+	return collections, nil
+}
+
+func GetCollectionById(collectionId int) (Collection, error) {
+	logger.Debug(fmt.Sprintf("Getting collection with id %d", collectionId))
+	var collection Collection
+	row := db.QueryRow("SELECT "+collectionColumns+" FROM collections WHERE collections.id = ?", collectionId)
+	err := scanCollection(row, &collection)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to get collection with id %d: %s", collectionId, err.Error()))
+	}
+	return collection, err
+}
+
+// GetClipsForCollection returns the clips of a collection in their playlist order
+func GetClipsForCollection(viewer Viewer, collectionId int) ([]Clip, error) {
+	logger.Debug(fmt.Sprintf("Fetching clips for collection %d", collectionId))
+	clips := []Clip{}
+
+	rows, err := db.Query("SELECT "+clipColumns+" FROM collection_clips INNER JOIN clips ON collection_clips.clip_id = clips.id WHERE collection_clips.collection_id = ? AND "+viewer.clipCondition()+" ORDER BY collection_clips.position", collectionId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching clips for collection %d: %s", collectionId, err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var clip Clip
+		if err = scanClip(rows, &clip); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching clips for collection %d: %s", collectionId, err.Error()))
+			return nil, err
+		}
+		clips = append(clips, clip)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching clips for collection %d: %s", collectionId, err.Error()))
+		return nil, err
+	}
+
+	for i := range clips {
+		populateClip(&clips[i], viewer)
+	}
+	return clips, nil
+}
+
+func CreateCollection(collection Collection) (Collection, error) {
+	logger.Debug(fmt.Sprintf("Creating collection %s for user %d", collection.Title, collection.OwnerId))
+	result, err := db.Exec("INSERT INTO collections (owner_id, title, description, cover_clip_id, is_public) VALUES (?, ?, ?, ?, ?)", collection.OwnerId, collection.Title, collection.Description, collection.CoverClipId, collection.IsPublic)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating collection %s: %s", collection.Title, err.Error()))
+		return collection, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating collection %s: %s", collection.Title, err.Error()))
+		return collection, err
+	}
+	return GetCollectionById(int(id))
+}
+
+func UpdateCollection(collection Collection) error {
+	logger.Debug(fmt.Sprintf("Updating collection %d", collection.Id))
+	_, err := db.Exec("UPDATE collections SET collections.title = ?, collections.description = ?, collections.cover_clip_id = ?, collections.is_public = ? WHERE collections.id = ?", collection.Title, collection.Description, collection.CoverClipId, collection.IsPublic, collection.Id)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error updating collection %d: %s", collection.Id, err.Error()))
+	}
+	return err
+}
+
+func DeleteCollectionById(collectionId int) error {
+	logger.Debug(fmt.Sprintf("Deleting collection with id: %d", collectionId))
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to delete collection %d: %s", collectionId, err.Error()))
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM collection_clips WHERE collection_id = ?", collectionId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to delete collection %d: %s", collectionId, err.Error()))
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM collections WHERE id = ?", collectionId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to delete collection %d: %s", collectionId, err.Error()))
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddClipToCollection appends the clip to the end of the collection. Adding a clip that's already there does nothing.
+func AddClipToCollection(collectionId int, clipId int) error {
+	logger.Debug(fmt.Sprintf("Adding clip %d to collection %d", clipId, collectionId))
+	_, err := db.Exec("INSERT IGNORE INTO collection_clips (collection_id, clip_id, position) SELECT ?, ?, COALESCE(MAX(position) + 1, 0) FROM collection_clips WHERE collection_id = ?", collectionId, clipId, collectionId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error adding clip %d to collection %d: %s", clipId, collectionId, err.Error()))
+	}
+	return err
+}
+
+func RemoveClipFromCollection(collectionId int, clipId int) error {
+	logger.Debug(fmt.Sprintf("Removing clip %d from collection %d", clipId, collectionId))
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error removing clip %d from collection %d: %s", clipId, collectionId, err.Error()))
+		return err
+	}
+	defer tx.Rollback()
+
+	err = removeClipFromCollection(tx, collectionId, clipId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error removing clip %d from collection %d: %s", clipId, collectionId, err.Error()))
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeClipFromCollection deletes the membership and closes the gap it leaves in the positions
+func removeClipFromCollection(tx *sql.Tx, collectionId int, clipId int) error {
+	var position int
+	err := tx.QueryRow("SELECT position FROM collection_clips WHERE collection_id = ? AND clip_id = ? FOR UPDATE", collectionId, clipId).Scan(&position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM collection_clips WHERE collection_id = ? AND clip_id = ?", collectionId, clipId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE collection_clips SET position = position - 1 WHERE collection_id = ? AND position > ? ORDER BY position", collectionId, position)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE collections SET cover_clip_id = NULL WHERE id = ? AND cover_clip_id = ?", collectionId, clipId)
+	return err
+}
+
+// removeClipFromAllCollections is used when a clip is deleted
+func removeClipFromAllCollections(tx *sql.Tx, clipId int) error {
+	rows, err := tx.Query("SELECT collection_id FROM collection_clips WHERE clip_id = ?", clipId)
+	if err != nil {
+		return err
+	}
+	var collectionIds []int
+	for rows.Next() {
+		var collectionId int
+		if err = rows.Scan(&collectionId); err != nil {
+			rows.Close()
+			return err
+		}
+		collectionIds = append(collectionIds, collectionId)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, collectionId := range collectionIds {
+		if err = removeClipFromCollection(tx, collectionId, clipId); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("UPDATE collections SET cover_clip_id = NULL WHERE cover_clip_id = ?", clipId)
+	return err
+}
+
+// ReorderCollection sets the order of the collection to clipIds, which must hold exactly the clips already in it
+func ReorderCollection(collectionId int, clipIds []int) error {
+	logger.Debug(fmt.Sprintf("Reordering collection %d", collectionId))
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error reordering collection %d: %s", collectionId, err.Error()))
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT clip_id FROM collection_clips WHERE collection_id = ? FOR UPDATE", collectionId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error reordering collection %d: %s", collectionId, err.Error()))
+		return err
+	}
+	var existing []int
+	for rows.Next() {
+		var clipId int
+		if err = rows.Scan(&clipId); err != nil {
+			rows.Close()
+			logger.Error(fmt.Sprintf("Error reordering collection %d: %s", collectionId, err.Error()))
+			return err
+		}
+		existing = append(existing, clipId)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error reordering collection %d: %s", collectionId, err.Error()))
+		return err
+	}
+
+	requested := slices.Clone(clipIds)
+	slices.Sort(existing)
+	slices.Sort(requested)
+	if !slices.Equal(existing, requested) {
+		return ErrCollectionOrder
+	}
+
+	for position, clipId := range clipIds {
+		_, err = tx.Exec("UPDATE collection_clips SET position = ? WHERE collection_id = ? AND clip_id = ?", position, collectionId, clipId)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error reordering collection %d: %s", collectionId, err.Error()))
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,185 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Comment is a comment on a clip, optionally pinned to a position in the video and optionally replying to another comment
+type Comment struct {
+	Id        int           `json:"id"`
+	ClipId    int           `json:"clipId"`
+	AuthorId  int           `json:"authorId"`
+	ParentId  sql.NullInt32 `json:"parentId"`
+	Timestamp sql.NullInt32 `json:"timestamp"`
+	Body      string        `json:"body"`
+	IsDeleted bool          `json:"isDeleted"`
+	CreatedAt time.Time     `json:"createdAt"`
+	EditedAt  sql.NullTime  `json:"editedAt"`
+	Replies   []Comment     `json:"replies,omitempty"`
+}
+
+const commentColumns = "clip_comments.id, clip_comments.clip_id, clip_comments.author_id, clip_comments.parent_id, clip_comments.timestamp_seconds, clip_comments.body, clip_comments.is_deleted, clip_comments.created_at, clip_comments.edited_at"
+
+func scanComment(row rowScanner, comment *Comment) error {
+	return row.Scan(&comment.Id, &comment.ClipId, &comment.AuthorId, &comment.ParentId, &comment.Timestamp, &comment.Body, &comment.IsDeleted, &comment.CreatedAt, &comment.EditedAt)
+}
+
+// GetCommentsForClip returns the top level comments on a clip, oldest first, with their replies nested under them
+func GetCommentsForClip(viewer Viewer, clipId int) ([]Comment, error) {
+	logger.Debug(fmt.Sprintf("Fetching comments for clip %d", clipId))
+	comments, err := getComments("SELECT "+commentColumns+" FROM clip_comments INNER JOIN clips ON clip_comments.clip_id = clips.id WHERE clip_comments.clip_id = ? AND "+viewer.clipCondition()+" ORDER BY clip_comments.created_at, clip_comments.id", clipId)
+	if err != nil {
+		return nil, err
+	}
+	return buildCommentThreads(comments), nil
+}
+
+// GetCommentsForUser returns the comments the user left on clips the viewer can see
+func GetCommentsForUser(viewer Viewer, userId int) ([]Comment, error) {
+	logger.Debug(fmt.Sprintf("Fetching comments for user %d", userId))
+	return getComments("SELECT "+commentColumns+" FROM clip_comments INNER JOIN clips ON clip_comments.clip_id = clips.id WHERE clip_comments.author_id = ? AND clip_comments.is_deleted = 0 AND "+viewer.clipCondition()+" ORDER BY clip_comments.created_at DESC", userId)
+}
+
+func getComments(query string, args ...any) ([]Comment, error) {
+	comments := []Comment{}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching comments: %s", err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var comment Comment
+		if err = scanComment(rows, &comment); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching comments: %s", err.Error()))
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching comments: %s", err.Error()))
+		return nil, err
+	}
+	return comments, nil
+}
+
+// buildCommentThreads nests each comment under its parent. comments must be ordered oldest first.
+func buildCommentThreads(comments []Comment) []Comment {
+	children := make(map[int][]Comment)
+	for _, comment := range comments {
+		if comment.ParentId.Valid {
+			parentId := int(comment.ParentId.Int32)
+			children[parentId] = append(children[parentId], comment)
+		}
+	}
+
+	var attachReplies func(comment Comment) Comment
+	attachReplies = func(comment Comment) Comment {
+		for _, reply := range children[comment.Id] {
+			comment.Replies = append(comment.Replies, attachReplies(reply))
+		}
+		return comment
+	}
+
+	threads := []Comment{}
+	for _, comment := range comments {
+		if !comment.ParentId.Valid {
+			threads = append(threads, attachReplies(comment))
+		}
+	}
+	return threads
+}
+
+// GetCommentById returns sql.ErrNoRows for comments on clips the viewer can't see
+func GetCommentById(viewer Viewer, commentId int) (Comment, error) {
+	logger.Debug(fmt.Sprintf("Getting comment with id %d", commentId))
+	var comment Comment
+	row := db.QueryRow("SELECT "+commentColumns+" FROM clip_comments INNER JOIN clips ON clip_comments.clip_id = clips.id WHERE clip_comments.id = ? AND "+viewer.clipCondition(), commentId)
+	err := scanComment(row, &comment)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to get comment with id %d: %s", commentId, err.Error()))
+	}
+	return comment, err
+}
+
+func CreateComment(comment Comment) (Comment, error) {
+	logger.Debug(fmt.Sprintf("Creating comment on clip %d for user %d", comment.ClipId, comment.AuthorId))
+	result, err := db.Exec("INSERT INTO clip_comments (clip_id, author_id, parent_id, timestamp_seconds, body) VALUES (?, ?, ?, ?, ?)", comment.ClipId, comment.AuthorId, comment.ParentId, comment.Timestamp, comment.Body)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating comment on clip %d: %s", comment.ClipId, err.Error()))
+		return comment, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating comment on clip %d: %s", comment.ClipId, err.Error()))
+		return comment, err
+	}
+	return GetCommentById(SystemViewer, int(id))
+}
+
+func UpdateComment(comment Comment) error {
+	logger.Debug(fmt.Sprintf("Updating comment %d", comment.Id))
+	_, err := db.Exec("UPDATE clip_comments SET clip_comments.body = ?, clip_comments.timestamp_seconds = ?, clip_comments.edited_at = CURRENT_TIMESTAMP WHERE clip_comments.id = ?", comment.Body, comment.Timestamp, comment.Id)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error updating comment %d: %s", comment.Id, err.Error()))
+	}
+	return err
+}
+
+// DeleteCommentById removes a comment. Comments that have replies are blanked out instead so the thread stays intact,
+// and blanked out parents are removed once their last reply is gone.
+func DeleteCommentById(commentId int) error {
+	logger.Debug(fmt.Sprintf("Deleting comment with id: %d", commentId))
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to delete comment %d: %s", commentId, err.Error()))
+		return err
+	}
+	defer tx.Rollback()
+
+	err = deleteComment(tx, commentId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to delete comment %d: %s", commentId, err.Error()))
+		return err
+	}
+	return tx.Commit()
+}
+
+func deleteComment(tx *sql.Tx, commentId int) error {
+	var replies int
+	err := tx.QueryRow("SELECT COUNT(*) FROM clip_comments WHERE clip_comments.parent_id = ?", commentId).Scan(&replies)
+	if err != nil {
+		return err
+	}
+	if replies > 0 {
+		_, err = tx.Exec("UPDATE clip_comments SET clip_comments.body = '', clip_comments.timestamp_seconds = NULL, clip_comments.is_deleted = 1 WHERE clip_comments.id = ?", commentId)
+		return err
+	}
+
+	var parentId sql.NullInt32
+	err = tx.QueryRow("SELECT parent_id FROM clip_comments WHERE id = ?", commentId).Scan(&parentId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM clip_comments WHERE id = ?", commentId)
+	if err != nil {
+		return err
+	}
+	if !parentId.Valid {
+		return nil
+	}
+
+	var parentDeleted bool
+	err = tx.QueryRow("SELECT is_deleted FROM clip_comments WHERE id = ?", parentId.Int32).Scan(&parentDeleted)
+	if err != nil || !parentDeleted {
+		return err
+	}
+	return deleteComment(tx, int(parentId.Int32))
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"ClipsArchiver/internal/config"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/go-sql-driver/mysql"
 	"log/slog"
@@ -11,10 +12,11 @@ import (
 )
 
 var logger *slog.Logger
+
+var ErrDuplicateClip = errors.New("clip has already been uploaded")
 var db *sql.DB
 
 func SetupDb(l *slog.Logger) error {
-	logger = l
 	dbConfig := config.GetDatabaseInfo()
 	cfg := mysql.Config{
 		User:      dbConfig.Username,
@@ -24,26 +26,43 @@ func SetupDb(l *slog.Logger) error {
 		DBName:    dbConfig.Name,
 		ParseTime: true,
 	}
-	var err error
-	db, err = sql.Open("mysql", cfg.FormatDSN())
+	conn, err := sql.Open("mysql", cfg.FormatDSN())
+	UseConnection(l, conn)
 
 	l.Debug(fmt.Sprintf("Opened database connection to %s", dbConfig.Address))
 	return err
 }
 
+// UseConnection makes every query go through conn, SetupDb opens it from the database config
+func UseConnection(l *slog.Logger, conn *sql.DB) {
+	logger = l
+	baseUri = config.GetBaseUri()
+	publicBaseUri = config.GetPublicBaseUri()
+	db = conn
+}
+
 type User struct {
-	Id           int    `json:"id"`
-	Name         string `json:"name"`
-	ApexUsername string `json:"apexUsername"`
-	ApexUid      string `json:"apexUid"`
+	Id                int            `json:"id"`
+	Name              string         `json:"name"`
+	ApexUsername      string         `json:"apexUsername"`
+	ApexUid           string         `json:"apexUid"`
+	Username          sql.NullString `json:"username"`
+	Role              string         `json:"role"`
+	DefaultVisibility string         `json:"defaultVisibility"`
 }
 
 type Clip struct {
 	Id                int            `json:"id"`
 	OwnerId           int            `json:"ownerId"`
+	Game              int            `json:"game"`
 	Filename          string         `json:"filename"`
+	OriginalFilename  string         `json:"originalFilename"`
+	ContentHash       sql.NullString `json:"contentHash"`
+	Title             string         `json:"title"`
+	Description       string         `json:"description"`
 	IsProcessed       bool           `json:"isProcessed"`
 	CreatedAt         sql.NullTime   `json:"createdOn"`
+	CreatedAtSource   string         `json:"createdOnSource"`
 	Duration          int            `json:"duration"`
 	Map               sql.NullInt32  `json:"map"`
 	GameMode          sql.NullString `json:"gameMode"`
@@ -54,6 +73,11 @@ type Clip struct {
 	VideoUri          string         `json:"videoUri"`
 	BrRankImg         sql.NullString `json:"brRankImg"`
 	BrScoreChange     sql.NullInt32  `json:"brScoreChange"`
+	ViewCount         int            `json:"viewCount"`
+	FavoriteCount     int            `json:"favoriteCount"`
+	Reactions         map[string]int `json:"reactions"`
+	Visibility        string         `json:"visibility"`
+	StorageTier       string         `json:"storageTier"`
 }
 
 type TranscodeRequest struct {
@@ -89,8 +113,11 @@ type CombineRequest struct {
 }
 
 type Tag struct {
-	Id   int    `json:"id"`
-	Name string `json:"name"`
+	Id         int            `json:"id"`
+	Name       string         `json:"name"`
+	Color      sql.NullString `json:"color"`
+	Category   sql.NullString `json:"category"`
+	UsageCount int            `json:"usageCount"`
 }
 
 type ClipTag struct {
@@ -113,6 +140,7 @@ type MatchHistory struct {
 
 type Map struct {
 	Id        int
+	Game      int
 	Name      string
 	CardImage string
 	AlsName   string
@@ -120,15 +148,53 @@ type Map struct {
 
 type Legend struct {
 	Id        int
+	Game      int
 	Name      string
 	CardImage string
 }
 
+// clipColumns lists the clips columns in the order scanClip expects them
+const clipColumns = "clips.id, clips.owner_id, clips.filename, clips.is_processed, clips.created_at, COALESCE(clips.duration, 0), clips.map, clips.game_mode, clips.legend, clips.match_history_found, clips.ranked_image, clips.ranked_point_gain, clips.game, clips.title, clips.description, clips.visibility, clips.original_filename, clips.content_hash, clips.created_at_source, clips.storage_tier, " +
+	"(SELECT COUNT(*) FROM clip_views WHERE clip_views.clip_id = clips.id), (SELECT COUNT(*) FROM favorites WHERE favorites.clip_id = clips.id)"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanClip scans a row selected with clipColumns, followed by any extra selected columns into extra
+func scanClip(row rowScanner, clip *Clip, extra ...any) error {
+	dest := []any{&clip.Id, &clip.OwnerId, &clip.Filename, &clip.IsProcessed, &clip.CreatedAt, &clip.Duration, &clip.Map, &clip.GameMode, &clip.Legend, &clip.MatchHistoryFound, &clip.BrRankImg, &clip.BrScoreChange, &clip.Game, &clip.Title, &clip.Description, &clip.Visibility, &clip.OriginalFilename, &clip.ContentHash, &clip.CreatedAtSource, &clip.StorageTier, &clip.ViewCount, &clip.FavoriteCount}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// baseUri is the address clients reach the server on, and publicBaseUri the one share links are handed out on
+var baseUri string
+var publicBaseUri string
+
+// ArchivePath and ThumbnailsPath are where the static file routes serve clips and thumbnails from
+const ArchivePath = "/clips/archive/"
+const ThumbnailsPath = "/clips/thumbnails/"
+
+// populateClip fills in the fields of a scanned clip that don't come from the clips table. The file uris are
+// signed for the viewer.
+func populateClip(clip *Clip, viewer Viewer) {
+	tags, err := GetTagsForClip(clip.Id)
+	if err == nil {
+		clip.Tags = tags
+	}
+	reactions, err := GetReactionCountsForClip(clip.Id)
+	if err == nil {
+		clip.Reactions = reactions
+	}
+	clip.VideoUri = viewer.baseUri() + viewer.signPath(ArchivePath+clip.Filename)
+	clip.ThumbnailUri = viewer.baseUri() + viewer.signPath(ThumbnailsPath+clip.Filename+".png")
+}
+
 func GetAllUsers() ([]User, error) {
 	logger.Debug("Fetching all users")
 	var users []User
 
-	rows, err := db.Query("SELECT * FROM users")
+	rows, err := db.Query("SELECT " + userColumns + " FROM users")
 	if err != nil {
 		logger.Error(fmt.Sprintf("Error fetching all users: %s", err.Error()))
 		return nil, err
@@ -138,7 +204,7 @@ func GetAllUsers() ([]User, error) {
 
 	for rows.Next() {
 		var user User
-		if err = rows.Scan(&user.Id, &user.Name, &user.ApexUsername, &user.ApexUid); err != nil {
+		if err = scanUser(rows, &user); err != nil {
 			logger.Error(fmt.Sprintf("Error fetching all users: %s", err.Error()))
 			return nil, err
 		}
@@ -156,7 +222,7 @@ func GetAllTags() ([]Tag, error) {
 	logger.Debug("Fetching all tags")
 	var tags []Tag
 
-	rows, err := db.Query("SELECT * FROM tags")
+	rows, err := db.Query("SELECT tags.id, tags.name, tags.color, tags.category, COUNT(clips_tags.clip_id) FROM tags LEFT JOIN clips_tags ON clips_tags.tag_id = tags.id GROUP BY tags.id ORDER BY tags.name")
 	if err != nil {
 		logger.Error(fmt.Sprintf("Error fetching all tags: %s", err.Error()))
 		return nil, err
@@ -166,7 +232,7 @@ func GetAllTags() ([]Tag, error) {
 
 	for rows.Next() {
 		var tag Tag
-		if err = rows.Scan(&tag.Id, &tag.Name); err != nil {
+		if err = rows.Scan(&tag.Id, &tag.Name, &tag.Color, &tag.Category, &tag.UsageCount); err != nil {
 			logger.Error(fmt.Sprintf("Error fetching all tags: %s", err.Error()))
 			return nil, err
 		}
@@ -184,7 +250,7 @@ func GetAllLegends() ([]Legend, error) {
 	logger.Debug("Fetching all legends")
 	var legends []Legend
 
-	rows, err := db.Query("SELECT * FROM legends")
+	rows, err := db.Query("SELECT id,game,name,card_image FROM legends")
 	if err != nil {
 		logger.Error(fmt.Sprintf("Error fetching all legends: %s", err.Error()))
 		return nil, err
@@ -194,7 +260,7 @@ func GetAllLegends() ([]Legend, error) {
 
 	for rows.Next() {
 		var legend Legend
-		if err = rows.Scan(&legend.Id, &legend.Name, &legend.CardImage); err != nil {
+		if err = rows.Scan(&legend.Id, &legend.Game, &legend.Name, &legend.CardImage); err != nil {
 			logger.Error(fmt.Sprintf("Error fetching all legends: %s", err.Error()))
 			return nil, err
 		}
@@ -212,7 +278,7 @@ func GetAllMaps() ([]Map, error) {
 	logger.Debug("Fetching all maps")
 	var maps []Map
 
-	rows, err := db.Query("SELECT id,name,card_image FROM maps")
+	rows, err := db.Query("SELECT id,game,name,card_image FROM maps")
 	if err != nil {
 		logger.Error(fmt.Sprintf("Error fetching all maps: %s", err.Error()))
 		return nil, err
@@ -222,7 +288,7 @@ func GetAllMaps() ([]Map, error) {
 
 	for rows.Next() {
 		var gameMap Map
-		if err = rows.Scan(&gameMap.Id, &gameMap.Name, &gameMap.CardImage); err != nil {
+		if err = rows.Scan(&gameMap.Id, &gameMap.Game, &gameMap.Name, &gameMap.CardImage); err != nil {
 			logger.Error(fmt.Sprintf("Error fetching all maps: %s", err.Error()))
 			return nil, err
 		}
@@ -236,11 +302,11 @@ func GetAllMaps() ([]Map, error) {
 	return maps, nil
 }
 
-func GetAllTranscodeRequests() ([]TranscodeRequest, error) {
+func GetAllTranscodeRequests(viewer Viewer) ([]TranscodeRequest, error) {
 	logger.Debug("Fetching all transcode requests")
 	var transcodeRequests []TranscodeRequest
 
-	rows, err := db.Query("SELECT * FROM transcode_requests")
+	rows, err := db.Query("SELECT transcode_requests.* FROM transcode_requests INNER JOIN clips ON clips.id = transcode_requests.clip_id WHERE " + viewer.clipCondition())
 	if err != nil {
 		logger.Error(fmt.Sprintf("Error fetching all transcode requests: %s", err.Error()))
 		return nil, err
@@ -264,11 +330,11 @@ func GetAllTranscodeRequests() ([]TranscodeRequest, error) {
 	return transcodeRequests, nil
 }
 
-func GetAllPendingTranscodeRequests() ([]TranscodeRequest, error) {
+func GetAllPendingTranscodeRequests(viewer Viewer) ([]TranscodeRequest, error) {
 	logger.Debug("Fetching all transcode requests")
 	var transcodeRequests []TranscodeRequest
 
-	rows, err := db.Query("SELECT * FROM transcode_requests WHERE transcode_requests.status = 'pending'")
+	rows, err := db.Query("SELECT transcode_requests.* FROM transcode_requests INNER JOIN clips ON clips.id = transcode_requests.clip_id WHERE transcode_requests.status = 'pending' AND " + viewer.clipCondition())
 	if err != nil {
 		logger.Error(fmt.Sprintf("Error fetching all transcode requests: %s", err.Error()))
 		return nil, err
@@ -292,10 +358,10 @@ func GetAllPendingTranscodeRequests() ([]TranscodeRequest, error) {
 	return transcodeRequests, nil
 }
 
-func GetTranscodeRequestByClipId(id int) (TranscodeRequest, error) {
+func GetTranscodeRequestByClipId(viewer Viewer, id int) (TranscodeRequest, error) {
 	logger.Debug(fmt.Sprintf("Fetching transcode requests for clip id: %d", id))
 	var transcodeRequest TranscodeRequest
-	row := db.QueryRow("SELECT * FROM transcode_requests WHERE transcode_requests.clip_id = ?", id)
+	row := db.QueryRow("SELECT transcode_requests.* FROM transcode_requests INNER JOIN clips ON clips.id = transcode_requests.clip_id WHERE transcode_requests.clip_id = ? AND "+viewer.clipCondition(), id)
 
 	err := row.Scan(&transcodeRequest.Id, &transcodeRequest.ClipId, &transcodeRequest.Status, &transcodeRequest.StartedAt, &transcodeRequest.FinishedAt, &transcodeRequest.ErrorMessage)
 	if err != nil {
@@ -316,13 +382,13 @@ func GetTranscodeRequestById(id int) (TranscodeRequest, error) {
 	return transcodeRequest, err
 }
 
-func GetClipsForDate(dateOf time.Time) ([]Clip, error) {
+func GetClipsForDate(viewer Viewer, dateOf time.Time) ([]Clip, error) {
 	logger.Debug(fmt.Sprintf("Fetching clips for date: %s", dateOf.String()))
 	var clips []Clip
 
 	dateAfter := dateOf.AddDate(0, 0, 1)
 
-	rows, err := db.Query("SELECT * FROM clips WHERE clips.is_processed = 1 AND clips.created_at >= ? AND clips.created_at < ?", dateOf, dateAfter)
+	rows, err := db.Query("SELECT "+clipColumns+" FROM clips WHERE clips.is_processed = 1 AND clips.created_at >= ? AND clips.created_at < ? AND "+viewer.clipCondition(), dateOf, dateAfter)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Error fetching clips for date: %s. %s", dateOf.String(), err.Error()))
 		return nil, err
@@ -332,17 +398,12 @@ func GetClipsForDate(dateOf time.Time) ([]Clip, error) {
 
 	for rows.Next() {
 		var clip Clip
-		if err = rows.Scan(&clip.Id, &clip.OwnerId, &clip.Filename, &clip.IsProcessed, &clip.CreatedAt, &clip.Duration, &clip.Map, &clip.GameMode, &clip.Legend, &clip.MatchHistoryFound, &clip.BrRankImg, &clip.BrScoreChange); err != nil {
+		if err = scanClip(rows, &clip); err != nil {
 			logger.Error(fmt.Sprintf("Error fetching clips for date: %s. %s", dateOf.String(), err.Error()))
 			return nil, err
 		}
 
-		tags, err := GetTagsForClip(clip.Id)
-		if err == nil {
-			clip.Tags = tags
-		}
-		clip.VideoUri = fmt.Sprintf("http://10.0.0.10:8080/clips/archive/%s", clip.Filename)
-		clip.ThumbnailUri = fmt.Sprintf("http://10.0.0.10:8080/clips/thumbnails/%s", clip.Filename+".png")
+		populateClip(&clip, viewer)
 		clips = append(clips, clip)
 	}
 
@@ -353,10 +414,16 @@ func GetClipsForDate(dateOf time.Time) ([]Clip, error) {
 	return clips, nil
 }
 
-func AddClip(ownerId int, filename string, createdAt time.Time) (Clip, error) {
-	logger.Debug(fmt.Sprintf("Adding clip with owner ID: %d, filename: %s, createdAt: %s", ownerId, filename, createdAt.String()))
+// AddClip adds a clip for a file stored as filename. createdAtSource records where createdAt came from. It returns
+// ErrDuplicateClip when a clip with the same content hash already exists.
+func AddClip(ownerId int, gameId int, filename string, originalFilename string, contentHash string, createdAt time.Time, createdAtSource string) (Clip, error) {
+	logger.Debug(fmt.Sprintf("Adding clip with owner ID: %d, game ID: %d, filename: %s, createdAt: %s from %s", ownerId, gameId, filename, createdAt.String(), createdAtSource))
 	var clip Clip
-	clipResult, err := db.Exec("INSERT INTO clips (owner_id, filename, is_processed, created_at, duration) VALUES (?, ?, ?, ?, ?)", ownerId, filename, 0, createdAt, 0)
+	// new clips get the owner's default visibility
+	clipResult, err := db.Exec("INSERT INTO clips (owner_id, game, filename, original_filename, content_hash, is_processed, created_at, created_at_source, duration, visibility) SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, users.default_visibility FROM users WHERE users.id = ?", ownerId, gameId, filename, originalFilename, contentHash, 0, createdAt, createdAtSource, 0, ownerId)
+	if isDuplicateEntry(err) {
+		return clip, ErrDuplicateClip
+	}
 	if err != nil {
 		logger.Error(fmt.Sprintf("Error adding clip: %s", err.Error()))
 		return clip, err
@@ -368,7 +435,7 @@ func AddClip(ownerId int, filename string, createdAt time.Time) (Clip, error) {
 		return clip, err
 	}
 
-	clip, err = GetClipById(int(id))
+	clip, err = GetClipById(Viewer{UserId: ownerId}, int(id))
 	if err != nil {
 		logger.Error(fmt.Sprintf("Error adding clip: %s", err.Error()))
 		return clip, err
@@ -437,7 +504,7 @@ func UpdateClipTags(old Clip, new Clip) error {
 
 	for _, tag := range new.Tags {
 		var existingTag Tag
-		row := db.QueryRow("SELECT * FROM tags WHERE tags.name = ?", tag)
+		row := db.QueryRow("SELECT id, name FROM tags WHERE tags.name = ?", tag)
 
 		err := row.Scan(&existingTag.Id, &existingTag.Name)
 		if err != nil {
@@ -446,7 +513,7 @@ func UpdateClipTags(old Clip, new Clip) error {
 				logger.Error(fmt.Sprintf("Error adding tag %s to clip %d: %s", tag, old.Id, err.Error()))
 				return err
 			}
-			row = db.QueryRow("SELECT * FROM tags WHERE tags.name = ?", tag)
+			row = db.QueryRow("SELECT id, name FROM tags WHERE tags.name = ?", tag)
 			err = row.Scan(&existingTag.Id, &existingTag.Name)
 			if err != nil {
 				logger.Error(fmt.Sprintf("Error adding tag %s to clip %d: %s", tag, old.Id, err.Error()))
@@ -468,7 +535,7 @@ func UpdateClipTags(old Clip, new Clip) error {
 
 	for _, tag := range tagsToRemove {
 		var existingTag Tag
-		row := db.QueryRow("SELECT * FROM tags WHERE tags.name = ?", tag)
+		row := db.QueryRow("SELECT id, name FROM tags WHERE tags.name = ?", tag)
 
 		err := row.Scan(&existingTag.Id, &existingTag.Name)
 		if err != nil {
@@ -485,72 +552,158 @@ func UpdateClipTags(old Clip, new Clip) error {
 	return nil
 }
 
+// UpdateClip writes every field a user can edit, for the clips REST handler
 func UpdateClip(clip Clip) error {
 	logger.Debug(fmt.Sprintf("Updating clip %d", clip.Id))
-	_, err := db.Exec("UPDATE clips SET clips.map = ?, clips.game_mode = ?, clips.legend = ?, clips.match_history_found = ?, clips.ranked_image = ?, clips.ranked_point_gain = ? WHERE clips.id = ?", clip.Map, clip.GameMode, clip.Legend, clip.MatchHistoryFound, clip.BrRankImg, clip.BrScoreChange, clip.Id)
+	_, err := db.Exec("UPDATE clips SET clips.game = ?, clips.title = ?, clips.description = ?, clips.map = ?, clips.game_mode = ?, clips.legend = ?, clips.match_history_found = ?, clips.ranked_image = ?, clips.ranked_point_gain = ?, clips.visibility = ? WHERE clips.id = ?", clip.Game, clip.Title, clip.Description, clip.Map, clip.GameMode, clip.Legend, clip.MatchHistoryFound, clip.BrRankImg, clip.BrScoreChange, clip.Visibility, clip.Id)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Error updating clip %d: %s", clip.Id, err.Error()))
 	}
 	return err
 }
 
-func GetClipById(clipId int) (Clip, error) {
+// UpdateClipMatchData only writes the fields that come from match history, so a clip the processor read a while
+// ago doesn't put back a title, description or visibility the owner has changed since
+func UpdateClipMatchData(clip Clip) error {
+	logger.Debug(fmt.Sprintf("Updating match data of clip %d", clip.Id))
+	_, err := db.Exec("UPDATE clips SET clips.map = ?, clips.game_mode = ?, clips.legend = ?, clips.match_history_found = ?, clips.ranked_image = ?, clips.ranked_point_gain = ? WHERE clips.id = ?", clip.Map, clip.GameMode, clip.Legend, clip.MatchHistoryFound, clip.BrRankImg, clip.BrScoreChange, clip.Id)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error updating match data of clip %d: %s", clip.Id, err.Error()))
+	}
+	return err
+}
+
+// GetClipById returns sql.ErrNoRows for clips the viewer can't see, the same as for clips that don't exist
+func GetClipById(viewer Viewer, clipId int) (Clip, error) {
 	logger.Debug(fmt.Sprintf("Getting clip with id %d", clipId))
 	var clip Clip
-	row := db.QueryRow("SELECT * FROM clips WHERE clips.id = ?", clipId)
+	row := db.QueryRow("SELECT "+clipColumns+" FROM clips WHERE clips.id = ? AND "+viewer.clipCondition(), clipId)
 
-	err := row.Scan(&clip.Id, &clip.OwnerId, &clip.Filename, &clip.IsProcessed, &clip.CreatedAt, &clip.Duration, &clip.Map, &clip.GameMode, &clip.Legend, &clip.MatchHistoryFound, &clip.BrRankImg, &clip.BrScoreChange)
-	tags, err := GetTagsForClip(clip.Id)
-	if err == nil {
-		clip.Tags = tags
-	}
-	clip.VideoUri = fmt.Sprintf("http://10.0.0.10:8080/clips/archive/%s", clip.Filename)
-	clip.ThumbnailUri = fmt.Sprintf("http://10.0.0.10:8080/clips/archive/Thumbnails/%s", clip.Filename+".png")
+	err := scanClip(row, &clip)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Failed to get clip with id %d: %s", clipId, err.Error()))
+		return clip, err
 	}
-	return clip, err
+
+	populateClip(&clip, viewer)
+	return clip, nil
 }
 
-func GetClipByFilename(filename string) (Clip, error) {
+func GetClipByFilename(viewer Viewer, filename string) (Clip, error) {
 	logger.Debug(fmt.Sprintf("Getting clip with filename: %s", filename))
 	var clip Clip
-	row := db.QueryRow("SELECT * FROM clips WHERE clips.filename = ?", filename)
+	row := db.QueryRow("SELECT "+clipColumns+" FROM clips WHERE clips.filename = ? AND "+viewer.clipCondition(), filename)
 
-	err := row.Scan(&clip.Id, &clip.OwnerId, &clip.Filename, &clip.IsProcessed, &clip.CreatedAt, &clip.Duration, &clip.Map, &clip.GameMode, &clip.Legend, &clip.MatchHistoryFound, &clip.BrRankImg, &clip.BrScoreChange)
+	err := scanClip(row, &clip)
 
 	if err != nil {
 		logger.Error(fmt.Sprintf("Failed to get clip with filename %s: %s", filename, err.Error()))
 		return clip, err
 	}
 
-	tags, err := GetTagsForClip(clip.Id)
-	if err == nil {
-		clip.Tags = tags
+	populateClip(&clip, viewer)
+	return clip, nil
+}
+
+func GetClipByContentHash(viewer Viewer, contentHash string) (Clip, error) {
+	logger.Debug(fmt.Sprintf("Getting clip with content hash: %s", contentHash))
+	var clip Clip
+	row := db.QueryRow("SELECT "+clipColumns+" FROM clips WHERE clips.content_hash = ? AND "+viewer.clipCondition(), contentHash)
+
+	err := scanClip(row, &clip)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Error(fmt.Sprintf("Failed to get clip with content hash %s: %s", contentHash, err.Error()))
+		}
+		return clip, err
 	}
-	clip.VideoUri = fmt.Sprintf("http://10.0.0.10:8080/clips/archive/%s", clip.Filename)
-	clip.ThumbnailUri = fmt.Sprintf("http://10.0.0.10:8080/clips/archive/Thumbnails/%s", clip.Filename+".png")
 
-	return clip, err
+	populateClip(&clip, viewer)
+	return clip, nil
+}
+
+// GetClipsWithoutContentHash returns up to limit clips added before uploads were hashed, with ids above afterId
+func GetClipsWithoutContentHash(afterId int, limit int) ([]Clip, error) {
+	logger.Debug(fmt.Sprintf("Fetching clips without a content hash after id %d", afterId))
+	var clips []Clip
+
+	rows, err := db.Query("SELECT "+clipColumns+" FROM clips WHERE clips.content_hash IS NULL AND clips.id > ? ORDER BY clips.id LIMIT ?", afterId, limit)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching clips without a content hash: %s", err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var clip Clip
+		if err = scanClip(rows, &clip); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching clips without a content hash: %s", err.Error()))
+			return nil, err
+		}
+		clips = append(clips, clip)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching clips without a content hash: %s", err.Error()))
+		return nil, err
+	}
+	return clips, nil
+}
+
+// SetClipContentHash records the sha256 of a clip's upload. It returns ErrDuplicateClip when another clip already
+// has the same content hash.
+func SetClipContentHash(clipId int, contentHash string) error {
+	_, err := db.Exec("UPDATE clips SET clips.content_hash = ? WHERE clips.id = ?", contentHash, clipId)
+	if isDuplicateEntry(err) {
+		return ErrDuplicateClip
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error setting content hash of clip %d: %s", clipId, err.Error()))
+	}
+	return err
 }
 
 func DeleteClipById(clipId int) error {
 	logger.Debug(fmt.Sprintf("Deleting clip with id: %d", clipId))
-	_, err := db.Exec("DELETE FROM transcode_requests WHERE clip_id = ?", clipId)
+	tx, err := db.Begin()
 	if err != nil {
 		logger.Error(fmt.Sprintf("Failed to delete clip %d: %s", clipId, err.Error()))
 		return err
 	}
-	_, err = db.Exec("DELETE FROM clips_tags WHERE clip_id = ?", clipId)
+	defer tx.Rollback()
+
+	err = deleteClip(tx, clipId)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Failed to delete clip %d: %s", clipId, err.Error()))
 		return err
 	}
-	_, err = db.Exec("DELETE FROM clips WHERE id = ?", clipId)
+	return tx.Commit()
+}
+
+// deleteClip removes a clip along with every row that references it
+func deleteClip(tx *sql.Tx, clipId int) error {
+	err := removeClipFromAllCollections(tx, clipId)
 	if err != nil {
-		logger.Error(fmt.Sprintf("Failed to delete clip %d: %s", clipId, err.Error()))
+		return err
 	}
-	return err
+	statements := []string{
+		"DELETE FROM transcode_requests WHERE clip_id = ?",
+		"DELETE FROM clips_tags WHERE clip_id = ?",
+		"DELETE FROM favorites WHERE clip_id = ?",
+		"DELETE FROM clip_reactions WHERE clip_id = ?",
+		"DELETE FROM clip_views WHERE clip_id = ?",
+		"DELETE FROM clip_shares WHERE clip_id = ?",
+		"UPDATE clip_comments SET parent_id = NULL WHERE clip_id = ?",
+		"DELETE FROM clip_comments WHERE clip_id = ?",
+		"DELETE FROM reencode_jobs WHERE clip_id = ?",
+		"DELETE FROM clips WHERE id = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, clipId); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func GetMatchHistoryByMatchHash(matchHash string) (MatchHistory, error) {
@@ -561,27 +714,18 @@ func GetMatchHistoryByMatchHash(matchHash string) (MatchHistory, error) {
 	return matchHistory, err
 }
 
-func GetUserByApexUid(uid string) (User, error) {
-	var user User
-
-	row := db.QueryRow("SELECT * FROM users WHERE users.apex_uid = ?", uid)
-	err := row.Scan(&user.Id, &user.Name, &user.ApexUsername, &user.ApexUid)
-
-	return user, err
-}
-
 func GetMapByAlsName(alsName string) (Map, error) {
 	var gameMap Map
-	row := db.QueryRow("SELECT * FROM maps WHERE maps.als_name = ?", alsName)
-	err := row.Scan(&gameMap.Id, &gameMap.Name, &gameMap.CardImage, &gameMap.AlsName)
+	row := db.QueryRow("SELECT id,game,name,card_image,als_name FROM maps WHERE maps.als_name = ?", alsName)
+	err := row.Scan(&gameMap.Id, &gameMap.Game, &gameMap.Name, &gameMap.CardImage, &gameMap.AlsName)
 
 	return gameMap, err
 }
 
-func GetLegendByName(name string) (Legend, error) {
+func GetLegendByName(gameId int, name string) (Legend, error) {
 	var legend Legend
-	row := db.QueryRow("SELECT * FROM legends WHERE legends.name = ?", name)
-	err := row.Scan(&legend.Id, &legend.Name, &legend.CardImage)
+	row := db.QueryRow("SELECT id,game,name,card_image FROM legends WHERE legends.game = ? AND legends.name = ?", gameId, name)
+	err := row.Scan(&legend.Id, &legend.Game, &legend.Name, &legend.CardImage)
 
 	return legend, err
 }
@@ -630,15 +774,16 @@ func UpdateTranscodeRequestStatusToError(clipId int, errorMessage string) error
 	return err
 }
 
-func UpdateClipOnTranscodeFinish(clipId int, durationSeconds float64) error {
-	_, err := db.Exec("UPDATE clips SET clips.is_processed = 1, clips.duration = ? WHERE clips.id = ?", durationSeconds, clipId)
+// UpdateClipOnTranscodeFinish marks a clip as processed, stored on storageTier
+func UpdateClipOnTranscodeFinish(clipId int, durationSeconds float64, storageTier string) error {
+	_, err := db.Exec("UPDATE clips SET clips.is_processed = 1, clips.duration = ?, clips.storage_tier = ? WHERE clips.id = ?", durationSeconds, storageTier, clipId)
 	return err
 }
 
-func GetTrimRequestByClipId(clipId int) (TrimRequest, error) {
+func GetTrimRequestByClipId(viewer Viewer, clipId int) (TrimRequest, error) {
 	logger.Debug(fmt.Sprintf("Fetching trim requests for clip id: %d", clipId))
 	var trimRequest TrimRequest
-	row := db.QueryRow("SELECT * FROM trim_requests WHERE trim_requests.clip_id = ?", clipId)
+	row := db.QueryRow("SELECT trim_requests.* FROM trim_requests INNER JOIN clips ON clips.id = trim_requests.clip_id WHERE trim_requests.clip_id = ? AND "+viewer.clipCondition(), clipId)
 
 	err := row.Scan(&trimRequest.Id, &trimRequest.ClipId, &trimRequest.DesiredStartTime, &trimRequest.DesiredEndTime, &trimRequest.Status, &trimRequest.ErrorMessage, &trimRequest.StartedAt, &trimRequest.FinishedAt)
 	if err != nil {
@@ -647,11 +792,11 @@ func GetTrimRequestByClipId(clipId int) (TrimRequest, error) {
 	return trimRequest, err
 }
 
-func GetAllTrimRequests() ([]TrimRequest, error) {
+func GetAllTrimRequests(viewer Viewer) ([]TrimRequest, error) {
 	logger.Debug("Fetching all trim requests")
 	var trimRequests []TrimRequest
 
-	rows, err := db.Query("SELECT * FROM trim_requests")
+	rows, err := db.Query("SELECT trim_requests.* FROM trim_requests INNER JOIN clips ON clips.id = trim_requests.clip_id WHERE " + viewer.clipCondition())
 	if err != nil {
 		logger.Error(fmt.Sprintf("Error fetching all trim requests: %s", err.Error()))
 		return nil, err
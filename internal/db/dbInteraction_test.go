@@ -0,0 +1,87 @@
+package db
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var configFiles = []string{"config.json", "apiConfig.json", "dbConfig.json", "authConfig.json", "uploadConfig.json", "ingestConfig.json"}
+
+// TestMain runs the tests in a folder with empty config files, so signing clip urls doesn't create them and exit
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "clipsarchiver-db")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, name := range configFiles {
+		if err = os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0666); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err = os.Chdir(dir); err != nil {
+		log.Fatal(err)
+	}
+
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// mockDb points the package at a mock that expects exactly the statements it's given, in order
+func mockDb(t *testing.T) sqlmock.Sqlmock {
+	return mockDbMatching(t, sqlmock.QueryMatcherEqual)
+}
+
+// mockDbMatching is mockDb for expectations that only describe part of a statement
+func mockDbMatching(t *testing.T, matcher sqlmock.QueryMatcher) sqlmock.Sqlmock {
+	conn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(matcher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	db = conn
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return mock
+}
+
+// TestDeleteClipById deletes a clip that has been re-encoded, whose reencode_jobs rows reference it and have to go
+// before the clip does
+func TestDeleteClipById(t *testing.T) {
+	const clipId = 10
+	mock := mockDb(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT collection_id FROM collection_clips WHERE clip_id = ?").WithArgs(clipId).
+		WillReturnRows(sqlmock.NewRows([]string{"collection_id"}))
+	mock.ExpectExec("UPDATE collections SET cover_clip_id = NULL WHERE cover_clip_id = ?").WithArgs(clipId).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, statement := range []string{
+		"DELETE FROM transcode_requests WHERE clip_id = ?",
+		"DELETE FROM clips_tags WHERE clip_id = ?",
+		"DELETE FROM favorites WHERE clip_id = ?",
+		"DELETE FROM clip_reactions WHERE clip_id = ?",
+		"DELETE FROM clip_views WHERE clip_id = ?",
+		"DELETE FROM clip_shares WHERE clip_id = ?",
+		"UPDATE clip_comments SET parent_id = NULL WHERE clip_id = ?",
+		"DELETE FROM clip_comments WHERE clip_id = ?",
+	} {
+		mock.ExpectExec(statement).WithArgs(clipId).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec("DELETE FROM reencode_jobs WHERE clip_id = ?").WithArgs(clipId).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM clips WHERE id = ?").WithArgs(clipId).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := DeleteClipById(clipId); err != nil {
+		t.Fatalf("DeleteClipById() = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrGameAccountTaken = errors.New("game account belongs to another user")
+
+// GameSlugApexLegends is the slug of the game enriched from the Apex Legends Status API
+const GameSlugApexLegends = "apex"
+
+type Game struct {
+	Id   int    `json:"id"`
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+}
+
+// GameAccount is a user's account in one game, used to match their clips to the game's match history
+type GameAccount struct {
+	UserId      int    `json:"userId"`
+	GameId      int    `json:"gameId"`
+	AccountName string `json:"accountName"`
+	AccountUid  string `json:"accountUid"`
+}
+
+func GetAllGames() ([]Game, error) {
+	logger.Debug("Fetching all games")
+	var games []Game
+
+	rows, err := db.Query("SELECT * FROM games")
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching all games: %s", err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var game Game
+		if err = rows.Scan(&game.Id, &game.Slug, &game.Name); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching all games: %s", err.Error()))
+			return nil, err
+		}
+		games = append(games, game)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching all games: %s", err.Error()))
+		return nil, err
+	}
+	return games, nil
+}
+
+func GetGameBySlug(slug string) (Game, error) {
+	var game Game
+	row := db.QueryRow("SELECT * FROM games WHERE games.slug = ?", slug)
+	err := row.Scan(&game.Id, &game.Slug, &game.Name)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to get game with slug %s: %s", slug, err.Error()))
+	}
+	return game, err
+}
+
+func GetGameById(gameId int) (Game, error) {
+	var game Game
+	row := db.QueryRow("SELECT * FROM games WHERE games.id = ?", gameId)
+	err := row.Scan(&game.Id, &game.Slug, &game.Name)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to get game with id %d: %s", gameId, err.Error()))
+	}
+	return game, err
+}
+
+func GetGameAccountsForUser(userId int) ([]GameAccount, error) {
+	logger.Debug(fmt.Sprintf("Fetching game accounts for user id: %d", userId))
+	return getGameAccounts("SELECT * FROM user_game_accounts WHERE user_game_accounts.user_id = ?", userId)
+}
+
+func GetGameAccountsForGame(gameId int) ([]GameAccount, error) {
+	logger.Debug(fmt.Sprintf("Fetching game accounts for game id: %d", gameId))
+	return getGameAccounts("SELECT * FROM user_game_accounts WHERE user_game_accounts.game_id = ?", gameId)
+}
+
+func getGameAccounts(query string, id int) ([]GameAccount, error) {
+	gameAccounts := []GameAccount{}
+
+	rows, err := db.Query(query, id)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching game accounts: %s", err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var gameAccount GameAccount
+		if err = rows.Scan(&gameAccount.UserId, &gameAccount.GameId, &gameAccount.AccountName, &gameAccount.AccountUid); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching game accounts: %s", err.Error()))
+			return nil, err
+		}
+		gameAccounts = append(gameAccounts, gameAccount)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching game accounts: %s", err.Error()))
+		return nil, err
+	}
+	return gameAccounts, nil
+}
+
+func GetUserIdByGameAccountUid(gameId int, accountUid string) (int, error) {
+	var userId int
+	row := db.QueryRow("SELECT user_id FROM user_game_accounts WHERE game_id = ? AND account_uid = ?", gameId, accountUid)
+	err := row.Scan(&userId)
+	return userId, err
+}
+
+// SetGameAccount adds or replaces the user's account in a game. It returns ErrGameAccountTaken when another user
+// already has the account, since match history is matched to users by it.
+func SetGameAccount(gameAccount GameAccount) error {
+	logger.Debug(fmt.Sprintf("Setting game %d account of user %d to %s", gameAccount.GameId, gameAccount.UserId, gameAccount.AccountUid))
+	ownerId, err := GetUserIdByGameAccountUid(gameAccount.GameId, gameAccount.AccountUid)
+	if err == nil && ownerId != gameAccount.UserId {
+		return ErrGameAccountTaken
+	}
+
+	_, err = db.Exec("INSERT INTO user_game_accounts (user_id, game_id, account_name, account_uid) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE account_name = VALUES(account_name), account_uid = VALUES(account_uid)", gameAccount.UserId, gameAccount.GameId, gameAccount.AccountName, gameAccount.AccountUid)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error setting game %d account of user %d: %s", gameAccount.GameId, gameAccount.UserId, err.Error()))
+	}
+	return err
+}
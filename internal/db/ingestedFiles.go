@@ -0,0 +1,50 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// IngestedFile records a recording from a watched folder that's been dealt with, so it's left alone after a
+// restart, and so is the same recording anywhere else once its clip has been deleted. ClipId isn't set for
+// recordings that were rejected.
+type IngestedFile struct {
+	Id          int
+	Path        string
+	Size        int64
+	ModifiedAt  time.Time
+	ContentHash string
+	ClipId      sql.NullInt32
+}
+
+// IsFileIngested reports whether the file at path was ingested while it had this size and modification time
+func IsFileIngested(path string, size int64, modifiedAt time.Time) (bool, error) {
+	var count int
+	row := db.QueryRow("SELECT COUNT(*) FROM ingested_files WHERE ingested_files.path = ? AND ingested_files.size = ? AND ingested_files.modified_at = ?", path, size, modifiedAt.Truncate(time.Microsecond))
+	if err := row.Scan(&count); err != nil {
+		logger.Error(fmt.Sprintf("Error checking whether %s was ingested: %s", path, err.Error()))
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// IsContentIngested reports whether a recording with this content hash was ingested from any path
+func IsContentIngested(contentHash string) (bool, error) {
+	var count int
+	row := db.QueryRow("SELECT COUNT(*) FROM ingested_files WHERE ingested_files.content_hash = ?", contentHash)
+	if err := row.Scan(&count); err != nil {
+		logger.Error(fmt.Sprintf("Error checking whether content hash %s was ingested: %s", contentHash, err.Error()))
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func AddIngestedFile(file IngestedFile) error {
+	logger.Debug(fmt.Sprintf("Recording %s as ingested", file.Path))
+	_, err := db.Exec("INSERT INTO ingested_files (path, size, modified_at, content_hash, clip_id) VALUES (?, ?, ?, ?, ?)", file.Path, file.Size, file.ModifiedAt.Truncate(time.Microsecond), file.ContentHash, file.ClipId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error recording %s as ingested: %s", file.Path, err.Error()))
+	}
+	return err
+}
@@ -0,0 +1,209 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// MatchGroup ties together the match history rows of several users who were in the same game
+type MatchGroup struct {
+	Id        int           `json:"id"`
+	Map       sql.NullInt32 `json:"map"`
+	GameStart sql.NullTime  `json:"gameStart"`
+	GameEnd   sql.NullTime  `json:"gameEnd"`
+	UserIds   []int         `json:"userIds"`
+}
+
+func GetUngroupedMatchHistoriesSince(since time.Time) ([]MatchHistory, error) {
+	logger.Debug(fmt.Sprintf("Fetching ungrouped match histories since: %s", since.String()))
+	var matchHistories []MatchHistory
+
+	rows, err := db.Query("SELECT match_history.* FROM match_history LEFT JOIN match_group_members ON match_group_members.match_history_id = match_history.id WHERE match_group_members.match_group_id IS NULL AND match_history.map IS NOT NULL AND match_history.game_start >= ? ORDER BY match_history.game_start", since)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching ungrouped match histories: %s", err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var matchHistory MatchHistory
+		if err = rows.Scan(&matchHistory.Id, &matchHistory.UserId, &matchHistory.GameStart, &matchHistory.GameEnd, &matchHistory.Map, &matchHistory.Legend, &matchHistory.GameMode, &matchHistory.BrRankImg, &matchHistory.BrScoreChange, &matchHistory.MatchHash); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching ungrouped match histories: %s", err.Error()))
+			return nil, err
+		}
+		matchHistories = append(matchHistories, matchHistory)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching ungrouped match histories: %s", err.Error()))
+		return nil, err
+	}
+	return matchHistories, nil
+}
+
+// GetOverlappingMatchHistories finds games of other users on the same map whose time range overlaps matchHistory,
+// which in practice means they were in the same lobby
+func GetOverlappingMatchHistories(matchHistory MatchHistory) ([]MatchHistory, error) {
+	var matchHistories []MatchHistory
+
+	rows, err := db.Query("SELECT * FROM match_history WHERE match_history.user_id <> ? AND match_history.map = ? AND match_history.game_start < ? AND match_history.game_end > ?", matchHistory.UserId, matchHistory.Map, matchHistory.GameEnd, matchHistory.GameStart)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching overlapping match histories for match history %d: %s", matchHistory.Id, err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var other MatchHistory
+		if err = rows.Scan(&other.Id, &other.UserId, &other.GameStart, &other.GameEnd, &other.Map, &other.Legend, &other.GameMode, &other.BrRankImg, &other.BrScoreChange, &other.MatchHash); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching overlapping match histories for match history %d: %s", matchHistory.Id, err.Error()))
+			return nil, err
+		}
+		matchHistories = append(matchHistories, other)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching overlapping match histories for match history %d: %s", matchHistory.Id, err.Error()))
+		return nil, err
+	}
+	return matchHistories, nil
+}
+
+func GetMatchGroupIdForMatchHistory(matchHistoryId int) (int, error) {
+	var matchGroupId int
+	row := db.QueryRow("SELECT match_group_id FROM match_group_members WHERE match_history_id = ?", matchHistoryId)
+	err := row.Scan(&matchGroupId)
+	return matchGroupId, err
+}
+
+func CreateMatchGroup(matchHistory MatchHistory) (int, error) {
+	logger.Debug(fmt.Sprintf("Creating match group from match history %d", matchHistory.Id))
+	result, err := db.Exec("INSERT INTO match_groups (map, game_start, game_end) VALUES (?, ?, ?)", matchHistory.Map, matchHistory.GameStart, matchHistory.GameEnd)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating match group from match history %d: %s", matchHistory.Id, err.Error()))
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating match group from match history %d: %s", matchHistory.Id, err.Error()))
+		return 0, err
+	}
+	return int(id), nil
+}
+
+func AddMatchHistoryToMatchGroup(matchGroupId int, matchHistoryId int) error {
+	_, err := db.Exec("INSERT IGNORE INTO match_group_members (match_group_id, match_history_id) VALUES (?, ?)", matchGroupId, matchHistoryId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error adding match history %d to match group %d: %s", matchHistoryId, matchGroupId, err.Error()))
+	}
+	return err
+}
+
+// MergeMatchGroups moves the members of the group with id fromId into the group with id intoId, widening its time
+// range to cover both, and deletes the emptied group
+func MergeMatchGroups(intoId int, fromId int) error {
+	logger.Debug(fmt.Sprintf("Merging match group %d into %d", fromId, intoId))
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error merging match group %d into %d: %s", fromId, intoId, err.Error()))
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE match_groups AS target INNER JOIN match_groups AS source ON source.id = ? SET target.game_start = LEAST(target.game_start, source.game_start), target.game_end = GREATEST(target.game_end, source.game_end) WHERE target.id = ?", fromId, intoId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error merging match group %d into %d: %s", fromId, intoId, err.Error()))
+		return err
+	}
+	_, err = tx.Exec("UPDATE match_group_members SET match_group_id = ? WHERE match_group_id = ?", intoId, fromId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error merging match group %d into %d: %s", fromId, intoId, err.Error()))
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM match_groups WHERE id = ?", fromId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error merging match group %d into %d: %s", fromId, intoId, err.Error()))
+		return err
+	}
+	return tx.Commit()
+}
+
+func GetMatchGroupById(matchGroupId int) (MatchGroup, error) {
+	logger.Debug(fmt.Sprintf("Getting match group with id %d", matchGroupId))
+	var matchGroup MatchGroup
+	row := db.QueryRow("SELECT * FROM match_groups WHERE match_groups.id = ?", matchGroupId)
+	err := row.Scan(&matchGroup.Id, &matchGroup.Map, &matchGroup.GameStart, &matchGroup.GameEnd)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to get match group with id %d: %s", matchGroupId, err.Error()))
+		return matchGroup, err
+	}
+
+	rows, err := db.Query("SELECT match_history.user_id FROM match_group_members INNER JOIN match_history ON match_group_members.match_history_id = match_history.id WHERE match_group_members.match_group_id = ?", matchGroupId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to get members of match group %d: %s", matchGroupId, err.Error()))
+		return matchGroup, err
+	}
+
+	defer rows.Close()
+
+	matchGroup.UserIds = []int{}
+	for rows.Next() {
+		var userId int
+		if err = rows.Scan(&userId); err != nil {
+			logger.Error(fmt.Sprintf("Failed to get members of match group %d: %s", matchGroupId, err.Error()))
+			return matchGroup, err
+		}
+		matchGroup.UserIds = append(matchGroup.UserIds, userId)
+	}
+
+	return matchGroup, rows.Err()
+}
+
+// GetMatchGroupIdForClip finds the match group of the game the clip was recorded in
+func GetMatchGroupIdForClip(clip Clip) (int, error) {
+	matchHistories, err := GetMatchHistoriesForClip(clip)
+	if err != nil {
+		return 0, err
+	}
+	for i := len(matchHistories) - 1; i >= 0; i-- {
+		matchGroupId, err := GetMatchGroupIdForMatchHistory(matchHistories[i].Id)
+		if err == nil {
+			return matchGroupId, nil
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+// GetClipsForMatchGroup returns every processed clip recorded by any member of the group during their game
+func GetClipsForMatchGroup(viewer Viewer, matchGroupId int) ([]Clip, error) {
+	logger.Debug(fmt.Sprintf("Fetching clips for match group %d", matchGroupId))
+	clips := []Clip{}
+
+	rows, err := db.Query("SELECT DISTINCT "+clipColumns+" FROM match_group_members INNER JOIN match_history ON match_group_members.match_history_id = match_history.id INNER JOIN clips ON clips.owner_id = match_history.user_id AND clips.created_at >= match_history.game_start AND DATE_SUB(clips.created_at, INTERVAL COALESCE(clips.duration, 0) SECOND) <= match_history.game_end WHERE match_group_members.match_group_id = ? AND clips.is_processed = 1 AND "+viewer.clipCondition()+" ORDER BY clips.created_at", matchGroupId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching clips for match group %d: %s", matchGroupId, err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var clip Clip
+		if err = scanClip(rows, &clip); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching clips for match group %d: %s", matchGroupId, err.Error()))
+			return nil, err
+		}
+		populateClip(&clip, viewer)
+		clips = append(clips, clip)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching clips for match group %d: %s", matchGroupId, err.Error()))
+		return nil, err
+	}
+	return clips, nil
+}
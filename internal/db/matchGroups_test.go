@@ -0,0 +1,33 @@
+package db
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// TestGetClipsForMatchGroupWithoutDuration loads a match group with a clip that hasn't been transcoded yet. Its
+// duration is still NULL, which has to count as 0 both when matching it to the game and when it's read.
+func TestGetClipsForMatchGroupWithoutDuration(t *testing.T) {
+	const matchGroupId = 3
+	mock := mockDbMatching(t, sqlmock.QueryMatcherRegexp)
+
+	rows := sqlmock.NewRows([]string{"id", "owner_id", "filename", "is_processed", "created_at", "duration", "map", "game_mode", "legend", "match_history_found", "ranked_image", "ranked_point_gain", "game", "title", "description", "visibility", "original_filename", "content_hash", "created_at_source", "storage_tier", "views", "favorites"}).
+		AddRow(10, 1, "clip.mp4", true, time.Now(), 0, nil, nil, nil, false, nil, nil, 1, "", "", VisibilityGroup, "clip.mp4", nil, "upload", "hot", 0, 0)
+	mock.ExpectQuery(regexp.QuoteMeta("COALESCE(clips.duration, 0), clips.map") + ".*" +
+		regexp.QuoteMeta("DATE_SUB(clips.created_at, INTERVAL COALESCE(clips.duration, 0) SECOND) <= match_history.game_end")).
+		WithArgs(matchGroupId).
+		WillReturnRows(rows)
+
+	clips, err := GetClipsForMatchGroup(Viewer{UserId: 1}, matchGroupId)
+	if err != nil {
+		t.Fatalf("GetClipsForMatchGroup() = %v", err)
+	}
+	if len(clips) != 1 || clips[0].Id != 10 {
+		t.Errorf("GetClipsForMatchGroup() = %+v, want clip 10", clips)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
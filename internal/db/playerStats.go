@@ -0,0 +1,153 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type StatsFilter struct {
+	From     sql.NullTime
+	To       sql.NullTime
+	GameMode sql.NullString
+}
+
+type PickRate struct {
+	Id    int     `json:"id"`
+	Name  string  `json:"name"`
+	Games int     `json:"games"`
+	Rate  float64 `json:"rate"`
+}
+
+type RankedPoint struct {
+	Date        time.Time `json:"date"`
+	Games       int       `json:"games"`
+	ScoreChange int       `json:"scoreChange"`
+	TotalChange int       `json:"totalChange"`
+}
+
+type UserStats struct {
+	UserId        int           `json:"userId"`
+	GamesPlayed   int           `json:"gamesPlayed"`
+	ClipCount     int           `json:"clipCount"`
+	ClipsPerGame  float64       `json:"clipsPerGame"`
+	Legends       []PickRate    `json:"legends"`
+	Maps          []PickRate    `json:"maps"`
+	RankedHistory []RankedPoint `json:"rankedHistory"`
+}
+
+// filterClause builds the WHERE clause shared by the stats queries against match_history and clips.
+func (f StatsFilter) filterClause(table string, ownerColumn string, timeColumn string, userId int) (string, []any) {
+	conditions := []string{fmt.Sprintf("%s.%s = ?", table, ownerColumn)}
+	args := []any{userId}
+	if f.From.Valid {
+		conditions = append(conditions, fmt.Sprintf("%s.%s >= ?", table, timeColumn))
+		args = append(args, f.From.Time)
+	}
+	if f.To.Valid {
+		conditions = append(conditions, fmt.Sprintf("%s.%s < ?", table, timeColumn))
+		args = append(args, f.To.Time)
+	}
+	if f.GameMode.Valid {
+		conditions = append(conditions, fmt.Sprintf("%s.game_mode = ?", table))
+		args = append(args, f.GameMode.String)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// GetUserStats sums up a user's games, counting only the clips the viewer can see
+func GetUserStats(viewer Viewer, userId int, filter StatsFilter) (UserStats, error) {
+	logger.Debug(fmt.Sprintf("Fetching stats for user id: %d", userId))
+	stats := UserStats{UserId: userId, Legends: []PickRate{}, Maps: []PickRate{}, RankedHistory: []RankedPoint{}}
+	where, args := filter.filterClause("match_history", "user_id", "game_start", userId)
+
+	row := db.QueryRow("SELECT COUNT(*) FROM match_history WHERE "+where, args...)
+	if err := row.Scan(&stats.GamesPlayed); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching stats for user id: %d. %s", userId, err.Error()))
+		return stats, err
+	}
+
+	clipWhere, clipArgs := filter.filterClause("clips", "owner_id", "created_at", userId)
+	row = db.QueryRow("SELECT COUNT(*) FROM clips WHERE "+clipWhere+" AND "+viewer.clipCondition(), clipArgs...)
+	if err := row.Scan(&stats.ClipCount); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching stats for user id: %d. %s", userId, err.Error()))
+		return stats, err
+	}
+	if stats.GamesPlayed > 0 {
+		stats.ClipsPerGame = float64(stats.ClipCount) / float64(stats.GamesPlayed)
+	}
+
+	var err error
+	stats.Legends, err = getPickRates("SELECT legends.id, legends.name, COUNT(*) FROM match_history INNER JOIN legends ON match_history.legend = legends.id WHERE "+where+" GROUP BY legends.id, legends.name ORDER BY COUNT(*) DESC", args, stats.GamesPlayed)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching legend stats for user id: %d. %s", userId, err.Error()))
+		return stats, err
+	}
+
+	stats.Maps, err = getPickRates("SELECT maps.id, maps.name, COUNT(*) FROM match_history INNER JOIN maps ON match_history.map = maps.id WHERE "+where+" GROUP BY maps.id, maps.name ORDER BY COUNT(*) DESC", args, stats.GamesPlayed)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching map stats for user id: %d. %s", userId, err.Error()))
+		return stats, err
+	}
+
+	stats.RankedHistory, err = getRankedHistory(where, args)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching ranked stats for user id: %d. %s", userId, err.Error()))
+		return stats, err
+	}
+
+	return stats, nil
+}
+
+func getPickRates(query string, args []any, gamesPlayed int) ([]PickRate, error) {
+	pickRates := []PickRate{}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var pickRate PickRate
+		if err = rows.Scan(&pickRate.Id, &pickRate.Name, &pickRate.Games); err != nil {
+			return nil, err
+		}
+		if gamesPlayed > 0 {
+			pickRate.Rate = float64(pickRate.Games) / float64(gamesPlayed)
+		}
+		pickRates = append(pickRates, pickRate)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return pickRates, nil
+}
+
+func getRankedHistory(where string, args []any) ([]RankedPoint, error) {
+	rankedHistory := []RankedPoint{}
+	rows, err := db.Query("SELECT DATE(match_history.game_start), COUNT(*), SUM(match_history.ranked_point_gain) FROM match_history WHERE "+where+" AND match_history.game_mode = 'Ranked' AND match_history.ranked_point_gain IS NOT NULL GROUP BY DATE(match_history.game_start) ORDER BY DATE(match_history.game_start)", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	total := 0
+	for rows.Next() {
+		var point RankedPoint
+		if err = rows.Scan(&point.Date, &point.Games, &point.ScoreChange); err != nil {
+			return nil, err
+		}
+		total += point.ScoreChange
+		point.TotalChange = total
+		rankedHistory = append(rankedHistory, point)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return rankedHistory, nil
+}
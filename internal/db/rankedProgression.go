@@ -0,0 +1,275 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rankTiers lists the ranked tiers in ascending order, named as they appear in the ALS rank image filenames
+var rankTiers = []string{"rookie", "bronze", "silver", "gold", "platinum", "diamond", "master", "apexpredator"}
+
+const (
+	RankEventNone      = ""
+	RankEventPromotion = "promotion"
+	RankEventDemotion  = "demotion"
+)
+
+type Season struct {
+	Id        int          `json:"id"`
+	Name      string       `json:"name"`
+	StartedAt time.Time    `json:"startedAt"`
+	EndedAt   sql.NullTime `json:"endedAt"`
+}
+
+type Rank struct {
+	Tier     string `json:"tier"`
+	Division int    `json:"division"`
+}
+
+type RankedGame struct {
+	MatchHistoryId int           `json:"matchHistoryId"`
+	GameStart      sql.NullTime  `json:"gameStart"`
+	GameEnd        sql.NullTime  `json:"gameEnd"`
+	Map            sql.NullInt32 `json:"map"`
+	Legend         sql.NullInt32 `json:"legend"`
+	ScoreChange    int           `json:"scoreChange"`
+	TotalChange    int           `json:"totalChange"`
+	Rank           Rank          `json:"rank"`
+	Event          string        `json:"event"`
+	Clips          []Clip        `json:"clips"`
+}
+
+type SeasonProgression struct {
+	Season Season       `json:"season"`
+	Games  []RankedGame `json:"games"`
+}
+
+// ParseRankImage works out the tier and division from an ALS rank image url such as
+// https://api.mozambiquehe.re/assets/ranks/platinum2.png
+func ParseRankImage(rankImg string) (Rank, bool) {
+	name := strings.ToLower(strings.TrimSuffix(path.Base(rankImg), path.Ext(rankImg)))
+	tier := strings.TrimRight(name, "0123456789")
+	if rankTierIndex(tier) < 0 {
+		return Rank{}, false
+	}
+	division, err := strconv.Atoi(name[len(tier):])
+	if err != nil {
+		division = 0
+	}
+	return Rank{Tier: tier, Division: division}, true
+}
+
+func rankTierIndex(tier string) int {
+	for i, t := range rankTiers {
+		if t == tier {
+			return i
+		}
+	}
+	return -1
+}
+
+// value orders ranks so that a higher tier, or a lower division number within a tier, compares greater
+func (r Rank) value() int {
+	return rankTierIndex(r.Tier)*10 + (10 - r.Division)
+}
+
+func GetAllSeasons() ([]Season, error) {
+	logger.Debug("Fetching all seasons")
+	var seasons []Season
+
+	rows, err := db.Query("SELECT * FROM seasons ORDER BY seasons.started_at")
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching all seasons: %s", err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var season Season
+		if err = rows.Scan(&season.Id, &season.Name, &season.StartedAt, &season.EndedAt); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching all seasons: %s", err.Error()))
+			return nil, err
+		}
+		seasons = append(seasons, season)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching all seasons: %s", err.Error()))
+		return nil, err
+	}
+	return seasons, nil
+}
+
+// CreateSeason adds a season, ending any open season that started before it so games are only ever in one season
+func CreateSeason(season Season) (Season, error) {
+	logger.Debug(fmt.Sprintf("Creating season %s", season.Name))
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating season %s: %s", season.Name, err.Error()))
+		return season, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE seasons SET seasons.ended_at = ? WHERE seasons.ended_at IS NULL AND seasons.started_at < ?", season.StartedAt, season.StartedAt)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating season %s: %s", season.Name, err.Error()))
+		return season, err
+	}
+	result, err := tx.Exec("INSERT INTO seasons (name, started_at, ended_at) VALUES (?, ?, ?)", season.Name, season.StartedAt, season.EndedAt)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating season %s: %s", season.Name, err.Error()))
+		return season, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating season %s: %s", season.Name, err.Error()))
+		return season, err
+	}
+	if err = tx.Commit(); err != nil {
+		logger.Error(fmt.Sprintf("Error creating season %s: %s", season.Name, err.Error()))
+		return season, err
+	}
+	season.Id = int(id)
+	return season, nil
+}
+
+func GetRankedMatchHistoriesForUser(userId int) ([]MatchHistory, error) {
+	logger.Debug(fmt.Sprintf("Fetching ranked match histories for user id: %d", userId))
+	var matchHistories []MatchHistory
+
+	rows, err := db.Query("SELECT * FROM match_history WHERE match_history.user_id = ? AND match_history.game_mode = 'Ranked' ORDER BY match_history.game_start", userId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching ranked match histories for user id: %d. %s", userId, err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var matchHistory MatchHistory
+		if err = rows.Scan(&matchHistory.Id, &matchHistory.UserId, &matchHistory.GameStart, &matchHistory.GameEnd, &matchHistory.Map, &matchHistory.Legend, &matchHistory.GameMode, &matchHistory.BrRankImg, &matchHistory.BrScoreChange, &matchHistory.MatchHash); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching ranked match histories for user id: %d. %s", userId, err.Error()))
+			return nil, err
+		}
+		matchHistories = append(matchHistories, matchHistory)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching ranked match histories for user id: %d. %s", userId, err.Error()))
+		return nil, err
+	}
+	return matchHistories, nil
+}
+
+// getRankedClipsForUser maps match history ids to the clips recorded during that game. A clip is recorded during a
+// game when the span it covers, the duration leading up to created_at, overlaps the game at all.
+func getRankedClipsForUser(viewer Viewer, userId int) (map[int][]Clip, error) {
+	clips := make(map[int][]Clip)
+	rows, err := db.Query("SELECT "+clipColumns+", match_history.id FROM match_history INNER JOIN clips ON clips.owner_id = match_history.user_id AND clips.created_at >= match_history.game_start AND DATE_SUB(clips.created_at, INTERVAL COALESCE(clips.duration, 0) SECOND) <= match_history.game_end WHERE match_history.user_id = ? AND match_history.game_mode = 'Ranked' AND clips.is_processed = 1 AND "+viewer.clipCondition()+" ORDER BY clips.created_at", userId)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var clip Clip
+		var matchHistoryId int
+		if err = scanClip(rows, &clip, &matchHistoryId); err != nil {
+			return nil, err
+		}
+		populateClip(&clip, viewer)
+		clips[matchHistoryId] = append(clips[matchHistoryId], clip)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return clips, nil
+}
+
+func GetRankedProgressionForUser(viewer Viewer, userId int) ([]SeasonProgression, error) {
+	logger.Debug(fmt.Sprintf("Building ranked progression for user id: %d", userId))
+	progression := []SeasonProgression{}
+
+	seasons, err := GetAllSeasons()
+	if err != nil {
+		return nil, err
+	}
+
+	matchHistories, err := GetRankedMatchHistoriesForUser(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	clips, err := getRankedClipsForUser(viewer, userId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching ranked clips for user id: %d. %s", userId, err.Error()))
+		return nil, err
+	}
+
+	var current *SeasonProgression
+	var previousRank Rank
+	hasPreviousRank := false
+	for _, matchHistory := range matchHistories {
+		season := seasonForTime(seasons, matchHistory.GameStart.Time)
+		if current == nil || current.Season.Id != season.Id {
+			progression = append(progression, SeasonProgression{Season: season, Games: []RankedGame{}})
+			current = &progression[len(progression)-1]
+			hasPreviousRank = false
+		}
+
+		game := RankedGame{
+			MatchHistoryId: matchHistory.Id,
+			GameStart:      matchHistory.GameStart,
+			GameEnd:        matchHistory.GameEnd,
+			Map:            matchHistory.Map,
+			Legend:         matchHistory.Legend,
+			ScoreChange:    int(matchHistory.BrScoreChange.Int32),
+			Event:          RankEventNone,
+			Clips:          []Clip{},
+		}
+		if len(current.Games) > 0 {
+			game.TotalChange = current.Games[len(current.Games)-1].TotalChange
+		}
+		game.TotalChange += game.ScoreChange
+
+		if rank, ok := ParseRankImage(matchHistory.BrRankImg.String); ok {
+			game.Rank = rank
+			if hasPreviousRank && rank.value() > previousRank.value() {
+				game.Event = RankEventPromotion
+			} else if hasPreviousRank && rank.value() < previousRank.value() {
+				game.Event = RankEventDemotion
+			}
+			previousRank = rank
+			hasPreviousRank = true
+		}
+
+		if gameClips, ok := clips[matchHistory.Id]; ok {
+			game.Clips = gameClips
+		}
+
+		current.Games = append(current.Games, game)
+	}
+
+	return progression, nil
+}
+
+// seasonForTime returns the season containing t, or a placeholder season with id 0 for games outside every known season
+func seasonForTime(seasons []Season, t time.Time) Season {
+	for _, season := range seasons {
+		if t.Before(season.StartedAt) {
+			continue
+		}
+		if season.EndedAt.Valid && !t.Before(season.EndedAt.Time) {
+			continue
+		}
+		return season
+	}
+	return Season{Id: 0, Name: "Unknown"}
+}
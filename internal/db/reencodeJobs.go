@@ -0,0 +1,156 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Re-encodes that finish are swapped in, unless the result wasn't smaller, in which case the original is kept
+const (
+	ReencodeStatusEncoding = "encoding"
+	ReencodeStatusFinished = "finished"
+	ReencodeStatusKept     = "kept"
+	ReencodeStatusError    = "error"
+)
+
+// maxReencodeAttempts is how often a clip that fails to re-encode is tried again
+const maxReencodeAttempts = 3
+
+// ReencodeJob records re-encoding one clip to save space. BytesSaved is 0 unless the re-encode was swapped in.
+type ReencodeJob struct {
+	Id                int             `json:"id"`
+	ClipId            int             `json:"clipId"`
+	Codec             string          `json:"codec"`
+	Status            string          `json:"status"`
+	OriginalSize      int64           `json:"originalSize"`
+	ReencodedSize     sql.NullInt64   `json:"reencodedSize"`
+	BytesSaved        int64           `json:"bytesSaved"`
+	OriginalDuration  sql.NullFloat64 `json:"originalDuration"`
+	ReencodedDuration sql.NullFloat64 `json:"reencodedDuration"`
+	ErrorMessage      sql.NullString  `json:"errorMessage"`
+	StartedAt         time.Time       `json:"startedAt"`
+	FinishedAt        sql.NullTime    `json:"finishedAt"`
+}
+
+const reencodeJobColumns = "reencode_jobs.id, reencode_jobs.clip_id, reencode_jobs.codec, reencode_jobs.status, reencode_jobs.original_size, reencode_jobs.reencoded_size, reencode_jobs.original_duration, reencode_jobs.reencoded_duration, reencode_jobs.error_message, reencode_jobs.started_at, reencode_jobs.finished_at"
+
+func scanReencodeJob(row rowScanner, job *ReencodeJob) error {
+	err := row.Scan(&job.Id, &job.ClipId, &job.Codec, &job.Status, &job.OriginalSize, &job.ReencodedSize, &job.OriginalDuration, &job.ReencodedDuration, &job.ErrorMessage, &job.StartedAt, &job.FinishedAt)
+	if err == nil && job.Status == ReencodeStatusFinished && job.ReencodedSize.Valid {
+		job.BytesSaved = job.OriginalSize - job.ReencodedSize.Int64
+	}
+	return err
+}
+
+// GetClipsToReencode returns up to limit processed clips recorded before recordedBefore that haven't been
+// re-encoded yet, oldest first. Clips are only ever re-encoded once, and given up on after a few failures.
+func GetClipsToReencode(recordedBefore time.Time, limit int) ([]Clip, error) {
+	logger.Debug(fmt.Sprintf("Fetching clips recorded before %s to re-encode", recordedBefore.String()))
+	var clips []Clip
+
+	rows, err := db.Query("SELECT "+clipColumns+" FROM clips WHERE clips.is_processed = 1 AND clips.created_at < ? "+
+		"AND NOT EXISTS (SELECT 1 FROM reencode_jobs WHERE reencode_jobs.clip_id = clips.id AND reencode_jobs.status IN (?, ?)) "+
+		"AND (SELECT COUNT(*) FROM reencode_jobs WHERE reencode_jobs.clip_id = clips.id AND reencode_jobs.status = ?) < ? "+
+		"ORDER BY clips.created_at LIMIT ?", recordedBefore, ReencodeStatusFinished, ReencodeStatusKept, ReencodeStatusError, maxReencodeAttempts, limit)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching clips to re-encode: %s", err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var clip Clip
+		if err := scanClip(rows, &clip); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching clips to re-encode: %s", err.Error()))
+			return nil, err
+		}
+		clips = append(clips, clip)
+	}
+	if err := rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching clips to re-encode: %s", err.Error()))
+		return nil, err
+	}
+	return clips, nil
+}
+
+func CreateReencodeJob(clipId int, codec string, originalSize int64) (int, error) {
+	logger.Debug(fmt.Sprintf("Starting re-encode of clip %d to %s", clipId, codec))
+	result, err := db.Exec("INSERT INTO reencode_jobs (clip_id, codec, status, original_size) VALUES (?, ?, ?, ?)", clipId, codec, ReencodeStatusEncoding, originalSize)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating re-encode job for clip %d: %s", clipId, err.Error()))
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating re-encode job for clip %d: %s", clipId, err.Error()))
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// FinishReencodeJob records the outcome of a re-encode, finished when it was swapped in or kept when the original
+// was kept
+func FinishReencodeJob(jobId int, status string, reencodedSize int64, originalDuration float64, reencodedDuration float64) error {
+	_, err := db.Exec("UPDATE reencode_jobs SET reencode_jobs.status = ?, reencode_jobs.reencoded_size = ?, reencode_jobs.original_duration = ?, reencode_jobs.reencoded_duration = ?, reencode_jobs.finished_at = ? WHERE reencode_jobs.id = ?", status, reencodedSize, originalDuration, reencodedDuration, time.Now(), jobId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error finishing re-encode job %d: %s", jobId, err.Error()))
+	}
+	return err
+}
+
+func FailReencodeJob(jobId int, message string) error {
+	_, err := db.Exec("UPDATE reencode_jobs SET reencode_jobs.status = ?, reencode_jobs.error_message = ?, reencode_jobs.finished_at = ? WHERE reencode_jobs.id = ?", ReencodeStatusError, message, time.Now(), jobId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error failing re-encode job %d: %s", jobId, err.Error()))
+	}
+	return err
+}
+
+// FailInterruptedReencodeJobs marks jobs that were still encoding when the transcoder stopped as failed, so the
+// clips are tried again
+func FailInterruptedReencodeJobs() error {
+	_, err := db.Exec("UPDATE reencode_jobs SET reencode_jobs.status = ?, reencode_jobs.error_message = ?, reencode_jobs.finished_at = ? WHERE reencode_jobs.status = ?", ReencodeStatusError, "interrupted", time.Now(), ReencodeStatusEncoding)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error failing interrupted re-encode jobs: %s", err.Error()))
+	}
+	return err
+}
+
+func GetReencodeJobsForClip(clipId int) ([]ReencodeJob, error) {
+	logger.Debug(fmt.Sprintf("Fetching re-encode jobs for clip %d", clipId))
+	var jobs []ReencodeJob
+
+	rows, err := db.Query("SELECT "+reencodeJobColumns+" FROM reencode_jobs WHERE reencode_jobs.clip_id = ? ORDER BY reencode_jobs.id", clipId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching re-encode jobs for clip %d: %s", clipId, err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var job ReencodeJob
+		if err := scanReencodeJob(rows, &job); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching re-encode jobs for clip %d: %s", clipId, err.Error()))
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching re-encode jobs for clip %d: %s", clipId, err.Error()))
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// GetSpaceSavedByReencoding totals the bytes saved by every re-encode that was swapped in
+func GetSpaceSavedByReencoding() (int64, error) {
+	var saved int64
+	err := db.QueryRow("SELECT COALESCE(SUM(reencode_jobs.original_size - reencode_jobs.reencoded_size), 0) FROM reencode_jobs WHERE reencode_jobs.status = ?", ReencodeStatusFinished).Scan(&saved)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error totalling space saved by re-encoding: %s", err.Error()))
+	}
+	return saved, err
+}
@@ -0,0 +1,242 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Session is a stretch of continuous play by one user, bounded by gaps between their clips and games
+type Session struct {
+	Id        int       `json:"id"`
+	OwnerId   int       `json:"ownerId"`
+	StartedAt time.Time `json:"startedAt"`
+	EndedAt   time.Time `json:"endedAt"`
+	ClipCount int       `json:"clipCount"`
+}
+
+type SessionFilter struct {
+	OwnerId sql.NullInt32
+	From    sql.NullTime
+	To      sql.NullTime
+}
+
+// Activity is a time range in which a user was known to be playing, taken from a clip or a match history row
+type Activity struct {
+	Start time.Time
+	End   time.Time
+}
+
+// ActivityMark is the newest clip and match history row at some point, so later activity can be told apart
+type ActivityMark struct {
+	ClipId         int
+	MatchHistoryId int
+}
+
+func GetActivityMark() (ActivityMark, error) {
+	var mark ActivityMark
+	err := db.QueryRow("SELECT (SELECT COALESCE(MAX(clips.id), 0) FROM clips), (SELECT COALESCE(MAX(match_history.id), 0) FROM match_history)").Scan(&mark.ClipId, &mark.MatchHistoryId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching activity mark: %s", err.Error()))
+	}
+	return mark, err
+}
+
+// GetUserIdsWithActivityAfter returns the users who have clips or match history newer than mark
+func GetUserIdsWithActivityAfter(mark ActivityMark) ([]int, error) {
+	var userIds []int
+
+	rows, err := db.Query("SELECT clips.owner_id FROM clips WHERE clips.id > ? UNION SELECT match_history.user_id FROM match_history WHERE match_history.id > ?", mark.ClipId, mark.MatchHistoryId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching users with new activity: %s", err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var userId int
+		if err = rows.Scan(&userId); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching users with new activity: %s", err.Error()))
+			return nil, err
+		}
+		userIds = append(userIds, userId)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching users with new activity: %s", err.Error()))
+		return nil, err
+	}
+	return userIds, nil
+}
+
+func GetActivityForUserSince(userId int, since time.Time) ([]Activity, error) {
+	var activities []Activity
+
+	rows, err := db.Query("SELECT DATE_SUB(clips.created_at, INTERVAL COALESCE(clips.duration, 0) SECOND) AS activity_start, clips.created_at AS activity_end FROM clips WHERE clips.owner_id = ? AND clips.created_at >= ? UNION ALL SELECT match_history.game_start, match_history.game_end FROM match_history WHERE match_history.user_id = ? AND match_history.game_start >= ? AND match_history.game_end IS NOT NULL ORDER BY activity_start", userId, since, userId, since)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching activity for user id: %d. %s", userId, err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var activity Activity
+		if err = rows.Scan(&activity.Start, &activity.End); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching activity for user id: %d. %s", userId, err.Error()))
+			return nil, err
+		}
+		activities = append(activities, activity)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching activity for user id: %d. %s", userId, err.Error()))
+		return nil, err
+	}
+	return activities, nil
+}
+
+// MergeSession records that userId played from start to end. Any existing sessions within gap of that range
+// are widened to cover it and merged into one, otherwise a new session is created.
+func MergeSession(userId int, start time.Time, end time.Time, gap time.Duration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error merging session for user id: %d. %s", userId, err.Error()))
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, started_at, ended_at FROM sessions WHERE owner_id = ? AND started_at <= ? AND ended_at >= ? ORDER BY started_at FOR UPDATE", userId, end.Add(gap), start.Add(-gap))
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error merging session for user id: %d. %s", userId, err.Error()))
+		return err
+	}
+
+	var sessionIds []int
+	for rows.Next() {
+		var id int
+		var startedAt, endedAt time.Time
+		if err = rows.Scan(&id, &startedAt, &endedAt); err != nil {
+			rows.Close()
+			logger.Error(fmt.Sprintf("Error merging session for user id: %d. %s", userId, err.Error()))
+			return err
+		}
+		if startedAt.Before(start) {
+			start = startedAt
+		}
+		if endedAt.After(end) {
+			end = endedAt
+		}
+		sessionIds = append(sessionIds, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error merging session for user id: %d. %s", userId, err.Error()))
+		return err
+	}
+
+	if len(sessionIds) == 0 {
+		_, err = tx.Exec("INSERT INTO sessions (owner_id, started_at, ended_at) VALUES (?, ?, ?)", userId, start, end)
+	} else {
+		_, err = tx.Exec("UPDATE sessions SET started_at = ?, ended_at = ? WHERE id = ?", start, end, sessionIds[0])
+		for _, id := range sessionIds[1:] {
+			if err != nil {
+				break
+			}
+			_, err = tx.Exec("DELETE FROM sessions WHERE id = ?", id)
+		}
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error merging session for user id: %d. %s", userId, err.Error()))
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetSessions lists sessions, counting only the clips the viewer can see
+func GetSessions(viewer Viewer, filter SessionFilter) ([]Session, error) {
+	logger.Debug("Fetching sessions")
+	sessions := []Session{}
+
+	conditions := []string{"1 = 1"}
+	var args []any
+	if filter.OwnerId.Valid {
+		conditions = append(conditions, "sessions.owner_id = ?")
+		args = append(args, filter.OwnerId.Int32)
+	}
+	if filter.From.Valid {
+		conditions = append(conditions, "sessions.ended_at >= ?")
+		args = append(args, filter.From.Time)
+	}
+	if filter.To.Valid {
+		conditions = append(conditions, "sessions.started_at < ?")
+		args = append(args, filter.To.Time)
+	}
+
+	rows, err := db.Query("SELECT sessions.id, sessions.owner_id, sessions.started_at, sessions.ended_at, (SELECT COUNT(*) FROM clips WHERE clips.owner_id = sessions.owner_id AND clips.is_processed = 1 AND clips.created_at BETWEEN sessions.started_at AND sessions.ended_at AND "+viewer.clipCondition()+") FROM sessions WHERE "+strings.Join(conditions, " AND ")+" ORDER BY sessions.started_at DESC", args...)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching sessions: %s", err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var session Session
+		if err = rows.Scan(&session.Id, &session.OwnerId, &session.StartedAt, &session.EndedAt, &session.ClipCount); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching sessions: %s", err.Error()))
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching sessions: %s", err.Error()))
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func GetSessionById(viewer Viewer, sessionId int) (Session, error) {
+	logger.Debug(fmt.Sprintf("Getting session with id %d", sessionId))
+	var session Session
+	row := db.QueryRow("SELECT sessions.id, sessions.owner_id, sessions.started_at, sessions.ended_at, (SELECT COUNT(*) FROM clips WHERE clips.owner_id = sessions.owner_id AND clips.is_processed = 1 AND clips.created_at BETWEEN sessions.started_at AND sessions.ended_at AND "+viewer.clipCondition()+") FROM sessions WHERE sessions.id = ?", sessionId)
+	err := row.Scan(&session.Id, &session.OwnerId, &session.StartedAt, &session.EndedAt, &session.ClipCount)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to get session with id %d: %s", sessionId, err.Error()))
+	}
+	return session, err
+}
+
+func GetClipsForSession(viewer Viewer, session Session) ([]Clip, error) {
+	logger.Debug(fmt.Sprintf("Fetching clips for session %d", session.Id))
+	clips := []Clip{}
+
+	rows, err := db.Query("SELECT "+clipColumns+" FROM clips WHERE clips.owner_id = ? AND clips.is_processed = 1 AND clips.created_at BETWEEN ? AND ? AND "+viewer.clipCondition()+" ORDER BY clips.created_at", session.OwnerId, session.StartedAt, session.EndedAt)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching clips for session %d: %s", session.Id, err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var clip Clip
+		if err = scanClip(rows, &clip); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching clips for session %d: %s", session.Id, err.Error()))
+			return nil, err
+		}
+
+		populateClip(&clip, viewer)
+		clips = append(clips, clip)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching clips for session %d: %s", session.Id, err.Error()))
+		return nil, err
+	}
+	return clips, nil
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Share is a link that lets anyone watch one clip without an account, until it expires or runs out of views
+type Share struct {
+	Id        int           `json:"id"`
+	ClipId    int           `json:"clipId"`
+	CreatedBy int           `json:"createdBy"`
+	Token     string        `json:"token"`
+	CreatedAt time.Time     `json:"createdAt"`
+	ExpiresAt sql.NullTime  `json:"expiresAt"`
+	MaxViews  sql.NullInt32 `json:"maxViews"`
+	ViewCount int           `json:"viewCount"`
+	Uri       string        `json:"uri"`
+}
+
+const shareColumns = "clip_shares.id, clip_shares.clip_id, clip_shares.created_by, clip_shares.token, clip_shares.created_at, clip_shares.expires_at, clip_shares.max_views, clip_shares.view_count"
+
+// SharePath is where share pages are served, followed by the token
+const SharePath = "/s/"
+
+func scanShare(row rowScanner, share *Share) error {
+	err := row.Scan(&share.Id, &share.ClipId, &share.CreatedBy, &share.Token, &share.CreatedAt, &share.ExpiresAt, &share.MaxViews, &share.ViewCount)
+	share.Uri = publicBaseUri + SharePath + share.Token
+	return err
+}
+
+// IsExpired reports whether the share's expiry has passed
+func (s Share) IsExpired() bool {
+	return s.ExpiresAt.Valid && !time.Now().Before(s.ExpiresAt.Time)
+}
+
+// IsUsable reports whether the share hasn't expired or run out of views
+func (s Share) IsUsable() bool {
+	if s.IsExpired() {
+		return false
+	}
+	return !s.MaxViews.Valid || s.ViewCount < int(s.MaxViews.Int32)
+}
+
+func CreateShare(share Share) (Share, error) {
+	logger.Debug(fmt.Sprintf("Creating share for clip %d", share.ClipId))
+	result, err := db.Exec("INSERT INTO clip_shares (clip_id, created_by, token, expires_at, max_views) VALUES (?, ?, ?, ?, ?)", share.ClipId, share.CreatedBy, share.Token, share.ExpiresAt, share.MaxViews)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating share for clip %d: %s", share.ClipId, err.Error()))
+		return share, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating share for clip %d: %s", share.ClipId, err.Error()))
+		return share, err
+	}
+	return GetShareById(int(id))
+}
+
+func GetShareById(shareId int) (Share, error) {
+	logger.Debug(fmt.Sprintf("Getting share with id %d", shareId))
+	var share Share
+	row := db.QueryRow("SELECT "+shareColumns+" FROM clip_shares WHERE clip_shares.id = ?", shareId)
+	err := scanShare(row, &share)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to get share with id %d: %s", shareId, err.Error()))
+	}
+	return share, err
+}
+
+func GetShareByToken(token string) (Share, error) {
+	var share Share
+	row := db.QueryRow("SELECT "+shareColumns+" FROM clip_shares WHERE clip_shares.token = ?", token)
+	err := scanShare(row, &share)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logger.Error(fmt.Sprintf("Failed to get share by token: %s", err.Error()))
+	}
+	return share, err
+}
+
+func GetSharesForClip(clipId int) ([]Share, error) {
+	logger.Debug(fmt.Sprintf("Fetching shares for clip %d", clipId))
+	shares := []Share{}
+
+	rows, err := db.Query("SELECT "+shareColumns+" FROM clip_shares WHERE clip_shares.clip_id = ? ORDER BY clip_shares.created_at DESC", clipId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching shares for clip %d: %s", clipId, err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var share Share
+		if err = scanShare(rows, &share); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching shares for clip %d: %s", clipId, err.Error()))
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching shares for clip %d: %s", clipId, err.Error()))
+		return nil, err
+	}
+	return shares, nil
+}
+
+// UseShare counts a view of the share, returning false without counting if it has expired or run out of views.
+// The check and the count happen in one statement so concurrent views can't go over the limit.
+func UseShare(shareId int) (bool, error) {
+	result, err := db.Exec("UPDATE clip_shares SET clip_shares.view_count = clip_shares.view_count + 1 WHERE clip_shares.id = ? AND (clip_shares.expires_at IS NULL OR clip_shares.expires_at > CURRENT_TIMESTAMP) AND (clip_shares.max_views IS NULL OR clip_shares.view_count < clip_shares.max_views)", shareId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error using share %d: %s", shareId, err.Error()))
+		return false, err
+	}
+	used, err := result.RowsAffected()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error using share %d: %s", shareId, err.Error()))
+		return false, err
+	}
+	return used == 1, nil
+}
+
+func DeleteShareById(shareId int) error {
+	logger.Debug(fmt.Sprintf("Deleting share with id: %d", shareId))
+	_, err := db.Exec("DELETE FROM clip_shares WHERE id = ?", shareId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to delete share %d: %s", shareId, err.Error()))
+	}
+	return err
+}
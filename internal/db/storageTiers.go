@@ -0,0 +1,65 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// Hot clips are kept on the fast local disk, cold clips on the bulk store
+const StorageTierHot = "hot"
+const StorageTierCold = "cold"
+
+// clipLastActive is when a clip was last viewed, or recorded if it never has been
+const clipLastActive = "COALESCE((SELECT MAX(clip_views.viewed_at) FROM clip_views WHERE clip_views.clip_id = clips.id), clips.created_at)"
+
+const clipIsFavorite = "EXISTS (SELECT 1 FROM favorites WHERE favorites.clip_id = clips.id)"
+
+// GetClipsToMoveToTier returns up to limit processed clips the tiering policy wants on tier that are on the other
+// one. Clips belong on the hot tier when they've been active since activeSince, or are favorites when
+// keepFavoritesHot is set.
+func GetClipsToMoveToTier(tier string, activeSince time.Time, keepFavoritesHot bool, limit int) ([]Clip, error) {
+	logger.Debug(fmt.Sprintf("Fetching clips to move to the %s tier", tier))
+	var clips []Clip
+
+	belongsHot := clipLastActive + " >= ?"
+	if keepFavoritesHot {
+		belongsHot += " OR " + clipIsFavorite
+	}
+	condition := "clips.storage_tier = ? AND (" + belongsHot + ")"
+	otherTier := StorageTierCold
+	if tier == StorageTierCold {
+		condition = "clips.storage_tier = ? AND NOT (" + belongsHot + ")"
+		otherTier = StorageTierHot
+	}
+
+	rows, err := db.Query("SELECT "+clipColumns+" FROM clips WHERE clips.is_processed = 1 AND "+condition+" ORDER BY clips.id LIMIT ?", otherTier, activeSince, limit)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching clips to move to the %s tier: %s", tier, err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var clip Clip
+		if err := scanClip(rows, &clip); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching clips to move to the %s tier: %s", tier, err.Error()))
+			return nil, err
+		}
+		clips = append(clips, clip)
+	}
+	if err := rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching clips to move to the %s tier: %s", tier, err.Error()))
+		return nil, err
+	}
+	return clips, nil
+}
+
+func SetClipStorageTier(clipId int, tier string) error {
+	logger.Debug(fmt.Sprintf("Setting storage tier of clip %d to %s", clipId, tier))
+	_, err := db.Exec("UPDATE clips SET clips.storage_tier = ? WHERE clips.id = ?", tier, clipId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error setting storage tier of clip %d: %s", clipId, err.Error()))
+	}
+	return err
+}
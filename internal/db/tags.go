@@ -0,0 +1,110 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrTagExists is returned when a tag name is already taken. Tag names are unique ignoring case.
+var ErrTagExists = errors.New("tag already exists")
+
+const mysqlErrDuplicateEntry = 1062
+
+func isDuplicateEntry(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry
+}
+
+func GetTagById(tagId int) (Tag, error) {
+	logger.Debug(fmt.Sprintf("Getting tag with id %d", tagId))
+	var tag Tag
+	row := db.QueryRow("SELECT tags.id, tags.name, tags.color, tags.category, COUNT(clips_tags.clip_id) FROM tags LEFT JOIN clips_tags ON clips_tags.tag_id = tags.id WHERE tags.id = ? GROUP BY tags.id", tagId)
+	err := row.Scan(&tag.Id, &tag.Name, &tag.Color, &tag.Category, &tag.UsageCount)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to get tag with id %d: %s", tagId, err.Error()))
+	}
+	return tag, err
+}
+
+func CreateTag(tag Tag) (Tag, error) {
+	logger.Debug(fmt.Sprintf("Creating tag %s", tag.Name))
+	result, err := db.Exec("INSERT INTO tags (name, color, category) VALUES (?, ?, ?)", tag.Name, tag.Color, tag.Category)
+	if isDuplicateEntry(err) {
+		return tag, ErrTagExists
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating tag %s: %s", tag.Name, err.Error()))
+		return tag, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating tag %s: %s", tag.Name, err.Error()))
+		return tag, err
+	}
+	return GetTagById(int(id))
+}
+
+func UpdateTag(tag Tag) error {
+	logger.Debug(fmt.Sprintf("Updating tag %d", tag.Id))
+	_, err := db.Exec("UPDATE tags SET tags.name = ?, tags.color = ?, tags.category = ? WHERE tags.id = ?", tag.Name, tag.Color, tag.Category, tag.Id)
+	if isDuplicateEntry(err) {
+		return ErrTagExists
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error updating tag %d: %s", tag.Id, err.Error()))
+	}
+	return err
+}
+
+func DeleteTagById(tagId int) error {
+	logger.Debug(fmt.Sprintf("Deleting tag with id: %d", tagId))
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to delete tag %d: %s", tagId, err.Error()))
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM clips_tags WHERE tag_id = ?", tagId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to delete tag %d: %s", tagId, err.Error()))
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM tags WHERE id = ?", tagId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to delete tag %d: %s", tagId, err.Error()))
+		return err
+	}
+	return tx.Commit()
+}
+
+// MergeTags moves every clip tagged with sourceId over to targetId and deletes the source tag, in one transaction.
+// Clips that already have both tags keep a single link to the target.
+func MergeTags(sourceId int, targetId int) error {
+	logger.Debug(fmt.Sprintf("Merging tag %d into tag %d", sourceId, targetId))
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to merge tag %d into tag %d: %s", sourceId, targetId, err.Error()))
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT IGNORE INTO clips_tags (clip_id, tag_id) SELECT clip_id, ? FROM clips_tags WHERE tag_id = ?", targetId, sourceId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to merge tag %d into tag %d: %s", sourceId, targetId, err.Error()))
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM clips_tags WHERE tag_id = ?", sourceId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to merge tag %d into tag %d: %s", sourceId, targetId, err.Error()))
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM tags WHERE id = ?", sourceId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to merge tag %d into tag %d: %s", sourceId, targetId, err.Error()))
+		return err
+	}
+	return tx.Commit()
+}
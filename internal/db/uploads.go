@@ -0,0 +1,107 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Upload is a resumable upload in progress. The clip is only created once all Length bytes have arrived.
+type Upload struct {
+	Id       string `json:"id"`
+	OwnerId  int    `json:"ownerId"`
+	GameId   int    `json:"gameId"`
+	Filename string `json:"filename"`
+	// ClipCreatedAt is the creation time the client sent, only used when the file doesn't say when it was recorded
+	ClipCreatedAt sql.NullTime `json:"clipCreatedAt"`
+	// Timezone is the IANA timezone the uploader recorded in, for timestamps in the filename
+	Timezone string `json:"timezone"`
+	Length   int64  `json:"length"`
+	Offset   int64  `json:"offset"`
+	// HashState is the marshalled sha256 state of the bytes received so far, so the content hash can be
+	// computed as chunks arrive
+	HashState []byte    `json:"-"`
+	StartedAt time.Time `json:"startedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+const uploadColumns = "uploads.id, uploads.owner_id, uploads.game, uploads.filename, uploads.clip_created_at, uploads.timezone, uploads.length, uploads.upload_offset, uploads.hash_state, uploads.started_at, uploads.expires_at"
+
+func scanUpload(row rowScanner, upload *Upload) error {
+	return row.Scan(&upload.Id, &upload.OwnerId, &upload.GameId, &upload.Filename, &upload.ClipCreatedAt, &upload.Timezone, &upload.Length, &upload.Offset, &upload.HashState, &upload.StartedAt, &upload.ExpiresAt)
+}
+
+func CreateUpload(upload Upload) (Upload, error) {
+	logger.Debug(fmt.Sprintf("Creating upload %s of %d bytes for user %d", upload.Id, upload.Length, upload.OwnerId))
+	_, err := db.Exec("INSERT INTO uploads (id, owner_id, game, filename, clip_created_at, timezone, length, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", upload.Id, upload.OwnerId, upload.GameId, upload.Filename, upload.ClipCreatedAt, upload.Timezone, upload.Length, upload.ExpiresAt)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating upload %s: %s", upload.Id, err.Error()))
+		return upload, err
+	}
+	return GetUploadById(upload.Id)
+}
+
+func GetUploadById(uploadId string) (Upload, error) {
+	logger.Debug(fmt.Sprintf("Getting upload with id %s", uploadId))
+	var upload Upload
+	row := db.QueryRow("SELECT "+uploadColumns+" FROM uploads WHERE uploads.id = ?", uploadId)
+	err := scanUpload(row, &upload)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to get upload with id %s: %s", uploadId, err.Error()))
+	}
+	return upload, err
+}
+
+// SetUploadOffset moves the upload from one offset to the next, storing the hash state at the new offset and
+// pushing back its expiry. It reports false when the upload isn't at the expected offset anymore.
+func SetUploadOffset(uploadId string, from int64, to int64, hashState []byte, expiresAt time.Time) (bool, error) {
+	logger.Debug(fmt.Sprintf("Moving upload %s from offset %d to %d", uploadId, from, to))
+	result, err := db.Exec("UPDATE uploads SET uploads.upload_offset = ?, uploads.hash_state = ?, uploads.expires_at = ? WHERE uploads.id = ? AND uploads.upload_offset = ?", to, hashState, expiresAt, uploadId, from)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error updating offset of upload %s: %s", uploadId, err.Error()))
+		return false, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error updating offset of upload %s: %s", uploadId, err.Error()))
+		return false, err
+	}
+	return updated > 0, nil
+}
+
+func GetExpiredUploads() ([]Upload, error) {
+	logger.Debug("Fetching expired uploads")
+	uploads := []Upload{}
+
+	rows, err := db.Query("SELECT " + uploadColumns + " FROM uploads WHERE uploads.expires_at <= NOW()")
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error fetching expired uploads: %s", err.Error()))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var upload Upload
+		if err = scanUpload(rows, &upload); err != nil {
+			logger.Error(fmt.Sprintf("Error fetching expired uploads: %s", err.Error()))
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+
+	if err = rows.Err(); err != nil {
+		logger.Error(fmt.Sprintf("Error fetching expired uploads: %s", err.Error()))
+		return nil, err
+	}
+	return uploads, nil
+}
+
+func DeleteUploadById(uploadId string) error {
+	logger.Debug(fmt.Sprintf("Deleting upload with id %s", uploadId))
+	_, err := db.Exec("DELETE FROM uploads WHERE uploads.id = ?", uploadId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error deleting upload %s: %s", uploadId, err.Error()))
+	}
+	return err
+}
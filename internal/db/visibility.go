@@ -0,0 +1,143 @@
+package db
+
+import (
+	"ClipsArchiver/internal/signing"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Private clips can only be seen by their owner, group clips by everyone with an account and public clips by
+// anyone, including people who aren't logged in
+const VisibilityPrivate = "private"
+const VisibilityGroup = "group"
+const VisibilityPublic = "public"
+
+// shareGrantPrefix marks signed urls handed out through a share link, followed by the share's token
+const shareGrantPrefix = "share:"
+
+// shareUrlLifetime is how long file urls handed out through a share link stay valid. They're kept short since
+// anyone with the link can pass them on, and the share is checked again whenever one is used.
+const shareUrlLifetime = 30 * time.Minute
+
+var ErrInvalidGrant = errors.New("invalid grant")
+
+// Viewer is who a clip lookup or listing is for, clips they aren't allowed to see are left out
+type Viewer struct {
+	// UserId is 0 for anonymous viewers
+	UserId  int
+	IsAdmin bool
+	// ShareToken and ShareClipId are set for viewers who reached a clip through a share link, who see that clip
+	// whatever its visibility and nothing else
+	ShareToken  string
+	ShareClipId int
+}
+
+// SystemViewer sees every clip, for the background services
+var SystemViewer = Viewer{IsAdmin: true}
+
+// ShareViewer sees the one clip the share link is for
+func ShareViewer(share Share) Viewer {
+	return Viewer{ShareToken: share.Token, ShareClipId: share.ClipId}
+}
+
+func IsValidVisibility(visibility string) bool {
+	return slices.Contains([]string{VisibilityPrivate, VisibilityGroup, VisibilityPublic}, visibility)
+}
+
+// CanSee reports whether the viewer is allowed to see the clip
+func (v Viewer) CanSee(clip Clip) bool {
+	switch {
+	case v.IsAdmin:
+		return true
+	case v.ShareToken != "":
+		return clip.Id == v.ShareClipId
+	case clip.Visibility == VisibilityPublic:
+		return true
+	case v.UserId == 0:
+		return false
+	case clip.Visibility == VisibilityGroup:
+		return true
+	default:
+		return clip.OwnerId == v.UserId
+	}
+}
+
+// clipCondition is an sql condition on the clips table that matches the clips the viewer can see
+func (v Viewer) clipCondition() string {
+	switch {
+	case v.IsAdmin:
+		return "TRUE"
+	case v.ShareToken != "":
+		return fmt.Sprintf("clips.id = %d", v.ShareClipId)
+	case v.UserId == 0:
+		return fmt.Sprintf("clips.visibility = '%s'", VisibilityPublic)
+	default:
+		return fmt.Sprintf("(clips.visibility <> '%s' OR clips.owner_id = %d)", VisibilityPrivate, v.UserId)
+	}
+}
+
+// Grant identifies the viewer in signed urls, so the static file routes can check visibility again when the
+// file is requested
+func (v Viewer) Grant() string {
+	if v.ShareToken != "" {
+		return shareGrantPrefix + v.ShareToken
+	}
+	if v.UserId != 0 {
+		return strconv.Itoa(v.UserId)
+	}
+	return ""
+}
+
+// signPath signs the path of a file for the viewer. Urls for share viewers are short-lived.
+func (v Viewer) signPath(path string) string {
+	if v.ShareToken != "" {
+		return signing.SignPathUntil(path, v.Grant(), time.Now().Add(shareUrlLifetime))
+	}
+	return signing.SignPath(path, v.Grant())
+}
+
+// baseUri is the address the viewer reaches the server on, share viewers are usually outside the network
+func (v Viewer) baseUri() string {
+	if v.ShareToken != "" {
+		return publicBaseUri
+	}
+	return baseUri
+}
+
+// GetViewerForGrant returns the viewer a signed url was handed out to
+func GetViewerForGrant(grant string) (Viewer, error) {
+	if grant == "" {
+		return Viewer{}, nil
+	}
+	if token, ok := strings.CutPrefix(grant, shareGrantPrefix); ok {
+		// revoked and expired shares take their urls with them. The view limit isn't checked, the view that
+		// used it up still has to be able to play the clip.
+		share, err := GetShareByToken(token)
+		if err != nil || share.IsExpired() {
+			return Viewer{}, ErrInvalidGrant
+		}
+		return ShareViewer(share), nil
+	}
+	userId, err := strconv.Atoi(grant)
+	if err != nil || userId <= 0 {
+		return Viewer{}, ErrInvalidGrant
+	}
+	role, err := GetUserRole(userId)
+	if err != nil {
+		return Viewer{}, err
+	}
+	return Viewer{UserId: userId, IsAdmin: role == RoleAdmin}, nil
+}
+
+func SetDefaultVisibility(userId int, visibility string) error {
+	logger.Debug(fmt.Sprintf("Setting default visibility of user %d to %s", userId, visibility))
+	_, err := db.Exec("UPDATE users SET users.default_visibility = ? WHERE users.id = ?", visibility, userId)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error setting default visibility for user %d: %s", userId, err.Error()))
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+package ingest
+
+import (
+	"ClipsArchiver/internal/config"
+	"ClipsArchiver/internal/db"
+	"ClipsArchiver/internal/media"
+	"ClipsArchiver/internal/rabbitmq"
+	"ClipsArchiver/internal/storage"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// NewClip is a received file waiting to become a clip, and what the uploader told us about it
+type NewClip struct {
+	OwnerId          int
+	GameId           int
+	ReceivedPath     string
+	OriginalFilename string
+	ContentHash      string
+	// ClientCreatedAt is only used when neither the file nor its name say when it was recorded
+	ClientCreatedAt sql.NullTime
+	// Location is the uploader's timezone, for timestamps in the filename
+	Location *time.Location
+}
+
+// SaveWithHash writes src to a new temporary file in the scratch folder, returning its path and sha256. It stays
+// there until CreateClip has validated it.
+func SaveWithHash(src io.Reader) (string, string, error) {
+	if err := os.MkdirAll(config.GetScratchPath(), 0755); err != nil {
+		return "", "", err
+	}
+	file, err := os.CreateTemp(config.GetScratchPath(), ".upload-*")
+	if err != nil {
+		return "", "", err
+	}
+	contentHash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, contentHash), src)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", "", err
+	}
+	return file.Name(), hex.EncodeToString(contentHash.Sum(nil)), nil
+}
+
+// CreateClip stores a received file under a name derived from its content, adds its clip and queues it for
+// transcoding. A file that's already a clip is thrown away, and the existing clip is returned with ErrDuplicateClip.
+// Files that aren't videos within the upload limits are thrown away with a media.ValidationError.
+func CreateClip(received NewClip) (db.Clip, error) {
+	if existing, err := db.GetClipByContentHash(db.SystemViewer, received.ContentHash); err == nil {
+		_ = os.Remove(received.ReceivedPath)
+		return existing, db.ErrDuplicateClip
+	}
+	probeData, err := media.ValidateVideo(received.ReceivedPath, config.GetUploadLimits())
+	if err != nil {
+		_ = os.Remove(received.ReceivedPath)
+		return db.Clip{}, err
+	}
+	createdAt, createdAtSource := media.ClipCreationTime(probeData, received.OriginalFilename, received.ClientCreatedAt, received.Location)
+
+	filename := storageFilename(received.ContentHash, received.OriginalFilename)
+	if err = storage.MoveIn(storage.Uploads(), filename, received.ReceivedPath); err != nil {
+		return db.Clip{}, err
+	}
+	clip, err := db.AddClip(received.OwnerId, received.GameId, filename, received.OriginalFilename, received.ContentHash, createdAt, createdAtSource)
+	if errors.Is(err, db.ErrDuplicateClip) {
+		// an identical file was uploaded at the same time, and is stored under the same name
+		existing, _ := db.GetClipByContentHash(db.SystemViewer, received.ContentHash)
+		return existing, err
+	}
+	if err != nil {
+		// the stored file is only thrown away when no clip was added for it, an identical upload may have been
+		// stored under the same name in the meantime
+		if _, lookupErr := db.GetClipByContentHash(db.SystemViewer, received.ContentHash); errors.Is(lookupErr, sql.ErrNoRows) {
+			_ = storage.Uploads().Delete(filename)
+		}
+		return clip, err
+	}
+
+	var requestEntry rabbitmq.RequestEntry
+	requestEntry.Id, err = db.CreateTranscodeRequest(clip.Id)
+	requestEntry.RequestType = 0
+	if err != nil {
+		return clip, err
+	}
+
+	return clip, rabbitmq.PublishToTranscodeQueue(requestEntry)
+}
+
+// storageFilename names stored clips after their content hash, so what the client called the file never matters
+func storageFilename(contentHash string, originalFilename string) string {
+	return contentHash + strings.ToLower(filepath.Ext(originalFilename))
+}
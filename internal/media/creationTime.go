@@ -0,0 +1,93 @@
+package media
+
+import (
+	"database/sql"
+	"github.com/vansante/go-ffprobe"
+	"regexp"
+	"time"
+)
+
+// Where a clip's creation time came from, from most to least trusted
+const (
+	CreatedAtSourceContainer = "container"
+	CreatedAtSourceFilename  = "filename"
+	CreatedAtSourceClient    = "client"
+	CreatedAtSourceUpload    = "upload"
+)
+
+type filenamePattern struct {
+	pattern *regexp.Regexp
+	layout  string
+}
+
+// filenamePatterns match the timestamps recording tools put in filenames. The first group is the timestamp,
+// in the local time of the machine that recorded it.
+var filenamePatterns = []filenamePattern{
+	// ShadowPlay: Apex Legends 2024.01.02 - 21.30.45.03.DVR.mp4
+	{regexp.MustCompile(`(\d{4}\.\d{2}\.\d{2} - \d{2}\.\d{2}\.\d{2})`), "2006.01.02 - 15.04.05"},
+	// OBS recordings and replay buffer: 2024-01-02 21-30-45.mkv, Replay 2024-01-02 21-30-45.mkv
+	{regexp.MustCompile(`(\d{4}-\d{2}-\d{2} \d{2}-\d{2}-\d{2})`), "2006-01-02 15-04-05"},
+	// Medal: MedalTVApexLegends20240102213045.mp4
+	{regexp.MustCompile(`MedalTV[A-Za-z]*(\d{14})`), "20060102150405"},
+}
+
+// earliestCreationTime rules out the zero dates some encoders write instead of leaving the tag out
+var earliestCreationTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ClipCreationTime works out when a clip was recorded from the container's creation_time tag, then a timestamp
+// in the filename, then the time the client sent, and finally the time it was uploaded. Filename timestamps are
+// read in location. It returns the time in UTC along with which of those it came from.
+func ClipCreationTime(probeData *ffprobe.ProbeData, filename string, clientValue sql.NullTime, location *time.Location) (time.Time, string) {
+	if createdAt, ok := containerCreationTime(probeData); ok {
+		return createdAt.UTC(), CreatedAtSourceContainer
+	}
+	if createdAt, ok := FilenameCreationTime(filename, location); ok {
+		return createdAt.UTC(), CreatedAtSourceFilename
+	}
+	if clientValue.Valid && isPlausibleCreationTime(clientValue.Time) {
+		return clientValue.Time.UTC(), CreatedAtSourceClient
+	}
+	return time.Now().UTC(), CreatedAtSourceUpload
+}
+
+// containerCreationTime reads the creation_time tag of the container, or of the video stream when the container
+// doesn't have one. The tag is always UTC.
+func containerCreationTime(probeData *ffprobe.ProbeData) (time.Time, bool) {
+	if probeData == nil {
+		return time.Time{}, false
+	}
+	var values []string
+	if probeData.Format != nil && probeData.Format.Tags != nil {
+		values = append(values, probeData.Format.Tags.CreationTime)
+	}
+	if video := firstMovingVideoStream(probeData); video != nil {
+		values = append(values, video.Tags.CreationTime)
+	}
+	for _, value := range values {
+		createdAt, err := time.Parse(time.RFC3339Nano, value)
+		if err == nil && isPlausibleCreationTime(createdAt) {
+			return createdAt, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// FilenameCreationTime reads the timestamp ShadowPlay, OBS or Medal put in the filename, in location
+func FilenameCreationTime(filename string, location *time.Location) (time.Time, bool) {
+	for _, filenamePattern := range filenamePatterns {
+		match := filenamePattern.pattern.FindStringSubmatch(filename)
+		if match == nil {
+			continue
+		}
+		createdAt, err := time.ParseInLocation(filenamePattern.layout, match[1], location)
+		if err == nil && isPlausibleCreationTime(createdAt) {
+			return createdAt, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// isPlausibleCreationTime rules out times that can't be when a clip was recorded, allowing a day of clock skew
+func isPlausibleCreationTime(createdAt time.Time) bool {
+	return createdAt.After(earliestCreationTime) && createdAt.Before(time.Now().Add(24*time.Hour))
+}
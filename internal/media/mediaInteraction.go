@@ -1,6 +1,7 @@
 package media
 
 import (
+	"fmt"
 	"github.com/u2takey/ffmpeg-go"
 	"github.com/vansante/go-ffprobe"
 	"math"
@@ -10,11 +11,59 @@ import (
 
 const encoder = "h264_videotoolbox"
 
+// Codecs old clips can be re-encoded to
+const (
+	ArchiveCodecHevc = "hevc"
+	ArchiveCodecAv1  = "av1"
+)
+
+type archiveEncoder struct {
+	name           string
+	defaultQuality int
+	options        ffmpeg_go.KwArgs
+}
+
+var archiveEncoders = map[string]archiveEncoder{
+	// hvc1 lets Apple players recognise HEVC in mp4
+	ArchiveCodecHevc: {name: "libx265", defaultQuality: 28, options: ffmpeg_go.KwArgs{"preset": "medium", "tag:v": "hvc1"}},
+	ArchiveCodecAv1:  {name: "libsvtav1", defaultQuality: 35, options: ffmpeg_go.KwArgs{"preset": 8}},
+}
+
 func TranscodeVideoFile(input string, output string) error {
 	err := ffmpeg_go.Input(input).Output(output, ffmpeg_go.KwArgs{"c:v": encoder, "q:v": 65, "vf": "scale=1920:1080"}).OverWriteOutput().ErrorToStdOut().Run()
 	return err
 }
 
+func IsSupportedArchiveCodec(codec string) bool {
+	_, ok := archiveEncoders[codec]
+	return ok
+}
+
+// VideoCodec returns the codec of the file's video the way ffprobe names it, which is how archive codecs are named
+func VideoCodec(probeData *ffprobe.ProbeData) string {
+	if video := firstMovingVideoStream(probeData); video != nil {
+		return video.CodecName
+	}
+	return ""
+}
+
+// ReencodeVideoFile re-encodes the video of input to codec at quality, the encoder's crf, copying everything else
+// as is. A quality of 0 uses the encoder's default.
+func ReencodeVideoFile(input string, output string, codec string, quality int) error {
+	archiveEncoder, ok := archiveEncoders[codec]
+	if !ok {
+		return fmt.Errorf("unsupported archive codec %s", codec)
+	}
+	if quality <= 0 {
+		quality = archiveEncoder.defaultQuality
+	}
+	args := ffmpeg_go.KwArgs{"map": 0, "c": "copy", "c:v": archiveEncoder.name, "crf": quality, "movflags": "+faststart"}
+	for key, value := range archiveEncoder.options {
+		args[key] = value
+	}
+	return ffmpeg_go.Input(input).Output(output, args).OverWriteOutput().ErrorToStdOut().Run()
+}
+
 func GenerateThumbnailFromVideo(input string, output string) error {
 	err := ffmpeg_go.Input(input).Output(output, ffmpeg_go.KwArgs{"ss": "00:00:01.000", "frames:v": 1}).OverWriteOutput().ErrorToStdOut().Run()
 	return err
@@ -0,0 +1,85 @@
+package media
+
+import (
+	"ClipsArchiver/internal/config"
+	"errors"
+	"fmt"
+	"github.com/vansante/go-ffprobe"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+const (
+	ProblemUnreadable           = "unreadable"
+	ProblemNoVideoStream        = "noVideoStream"
+	ProblemUnsupportedContainer = "unsupportedContainer"
+	ProblemTooShort             = "tooShort"
+	ProblemTooLong              = "tooLong"
+	ProblemResolutionTooLow     = "resolutionTooLow"
+	ProblemResolutionTooHigh    = "resolutionTooHigh"
+)
+
+// ValidationError explains why a file can't be a clip, in a form clients can show the user or act on
+type ValidationError struct {
+	Problem string `json:"problem"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func invalid(problem string, format string, args ...any) *ValidationError {
+	return &ValidationError{Problem: problem, Message: fmt.Sprintf(format, args...)}
+}
+
+// ValidateVideo probes the file and checks it's a video within the limits, returning a ValidationError if it isn't.
+// Other errors mean ffprobe couldn't be run or didn't finish in time, which says nothing about the file.
+func ValidateVideo(path string, limits config.UploadLimits) (*ffprobe.ProbeData, error) {
+	probeData, err := GetVideoProbeData(path)
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, err
+	}
+	if err != nil || probeData == nil || probeData.Format == nil {
+		return nil, invalid(ProblemUnreadable, "the file couldn't be read as a video")
+	}
+
+	// ffprobe reports every name a demuxer goes by, like mov,mp4,m4a,3gp,3g2,mj2
+	formatNames := strings.Split(probeData.Format.FormatName, ",")
+	if !slices.ContainsFunc(formatNames, func(name string) bool { return slices.Contains(limits.Containers, name) }) {
+		return probeData, invalid(ProblemUnsupportedContainer, "%s files aren't supported, should be one of %s", probeData.Format.FormatName, strings.Join(limits.Containers, ", "))
+	}
+
+	video := firstMovingVideoStream(probeData)
+	if video == nil {
+		return probeData, invalid(ProblemNoVideoStream, "the file has no video stream")
+	}
+
+	duration := probeData.Format.DurationSeconds
+	if limits.MinDurationSeconds > 0 && duration < limits.MinDurationSeconds {
+		return probeData, invalid(ProblemTooShort, "the video is %.1f seconds long, should be at least %.0f", duration, limits.MinDurationSeconds)
+	}
+	if limits.MaxDurationSeconds > 0 && duration > limits.MaxDurationSeconds {
+		return probeData, invalid(ProblemTooLong, "the video is %.0f seconds long, should be at most %.0f", duration, limits.MaxDurationSeconds)
+	}
+
+	if (limits.MinWidth > 0 && video.Width < limits.MinWidth) || (limits.MinHeight > 0 && video.Height < limits.MinHeight) {
+		return probeData, invalid(ProblemResolutionTooLow, "the video is %dx%d, should be at least %dx%d", video.Width, video.Height, limits.MinWidth, limits.MinHeight)
+	}
+	if (limits.MaxWidth > 0 && video.Width > limits.MaxWidth) || (limits.MaxHeight > 0 && video.Height > limits.MaxHeight) {
+		return probeData, invalid(ProblemResolutionTooHigh, "the video is %dx%d, should be at most %dx%d", video.Width, video.Height, limits.MaxWidth, limits.MaxHeight)
+	}
+	return probeData, nil
+}
+
+// firstMovingVideoStream skips cover art, which ffprobe lists as a video stream too
+func firstMovingVideoStream(probeData *ffprobe.ProbeData) *ffprobe.Stream {
+	for _, stream := range probeData.GetStreams(ffprobe.StreamVideo) {
+		if stream.Disposition.AttachedPic == 0 {
+			return &stream
+		}
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package accounts
+
+import (
+	"ClipsArchiver/internal/auth"
+	"ClipsArchiver/internal/db"
+	"ClipsArchiver/internal/rest"
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxUsernameLength = 32
+const maxTokenNameLength = 64
+
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type sessionResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type createUserRequest struct {
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+type createTokenRequest struct {
+	Name          string `json:"name"`
+	ExpiresInDays int    `json:"expiresInDays"`
+}
+
+type createTokenResponse struct {
+	Token    string      `json:"token"`
+	ApiToken db.ApiToken `json:"apiToken"`
+}
+
+func Login(c *gin.Context) {
+	var request loginRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "invalid body for login")
+		return
+	}
+	userId, ok := auth.CheckCredentials(request.Username, request.Password)
+	if !ok {
+		c.String(http.StatusUnauthorized, "invalid username or password")
+		return
+	}
+
+	token, expiresAt, err := auth.IssueSession(userId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, sessionResponse{Token: token, ExpiresAt: expiresAt})
+}
+
+func GetMe(c *gin.Context) {
+	userId, _ := auth.UserId(c)
+	user, err := db.GetUserById(userId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, user)
+}
+
+// CreateUser adds a user who can log in straight away. Only admins can create users.
+func CreateUser(c *gin.Context) {
+	if !auth.IsAdmin(c) {
+		c.String(http.StatusForbidden, "only admins can create users")
+		return
+	}
+	var request createUserRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "invalid body for User")
+		return
+	}
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || len(request.Name) > maxUsernameLength {
+		c.String(http.StatusBadRequest, "invalid name: should be between 1 and %d characters", maxUsernameLength)
+		return
+	}
+	if request.Role == "" {
+		request.Role = db.RoleMember
+	}
+	if !db.IsValidRole(request.Role) {
+		c.String(http.StatusBadRequest, rest.ErrorRoleFormat)
+		return
+	}
+	credentials, ok := buildCredentials(c, request.Username, request.Password)
+	if !ok {
+		return
+	}
+
+	user, err := db.CreateUser(request.Name, request.Role, credentials)
+	if errors.Is(err, db.ErrUsernameTaken) {
+		c.String(http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, user)
+}
+
+// SetCredentials sets the username and password of an existing user. Callers can change their own credentials
+// and admins can change anyone's. The user's other tokens and sessions are revoked, callers using a JWT session
+// have to log in again.
+func SetCredentials(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid user id provided: %s", c.Param("id"))
+		return
+	}
+	if !auth.IsUser(c, userId) && !auth.IsAdmin(c) {
+		c.String(http.StatusForbidden, "only the user or an admin can change their credentials")
+		return
+	}
+	if _, err = db.GetUserById(userId); err != nil {
+		c.String(http.StatusNotFound, "no user found with id: %d", userId)
+		return
+	}
+
+	var request loginRequest
+	if err = c.BindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "invalid body for credentials")
+		return
+	}
+	credentials, ok := buildCredentials(c, request.Username, request.Password)
+	if !ok {
+		return
+	}
+	credentials.UserId = userId
+
+	err = db.SetCredentials(credentials, auth.ApiTokenHash(c))
+	if errors.Is(err, db.ErrUsernameTaken) {
+		c.String(http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.String(http.StatusNoContent, "Updated credentials")
+}
+
+func GetTokens(c *gin.Context) {
+	userId, _ := auth.UserId(c)
+	apiTokens, err := db.GetApiTokensForUser(userId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, apiTokens)
+}
+
+// CreateToken issues a new API token for the caller. The token is only returned here, it can't be retrieved later.
+func CreateToken(c *gin.Context) {
+	userId, _ := auth.UserId(c)
+	var request createTokenRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "invalid body for token")
+		return
+	}
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || len(request.Name) > maxTokenNameLength {
+		c.String(http.StatusBadRequest, "invalid token name: should be between 1 and %d characters", maxTokenNameLength)
+		return
+	}
+	if request.ExpiresInDays < 0 {
+		c.String(http.StatusBadRequest, "invalid expiry: should be a positive number of days, or 0 for no expiry")
+		return
+	}
+	var expiresAt sql.NullTime
+	if request.ExpiresInDays > 0 {
+		expiresAt = sql.NullTime{Time: time.Now().AddDate(0, 0, request.ExpiresInDays), Valid: true}
+	}
+
+	token, tokenHash, err := auth.NewApiToken()
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	apiToken, err := db.CreateApiToken(userId, request.Name, tokenHash, expiresAt)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, createTokenResponse{Token: token, ApiToken: apiToken})
+}
+
+func DeleteToken(c *gin.Context) {
+	userId, _ := auth.UserId(c)
+	tokenId, err := strconv.Atoi(c.Param("tokenId"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid token id provided: %s", c.Param("tokenId"))
+		return
+	}
+
+	err = db.DeleteApiToken(userId, tokenId)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.String(http.StatusNotFound, "no token found with id: %d", tokenId)
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.String(http.StatusNoContent, "Deleted token")
+}
+
+func buildCredentials(c *gin.Context, username string, password string) (db.Credentials, bool) {
+	username = strings.TrimSpace(username)
+	if username == "" || len(username) > maxUsernameLength {
+		c.String(http.StatusBadRequest, "invalid username: should be between 1 and %d characters", maxUsernameLength)
+		return db.Credentials{}, false
+	}
+	passwordHash, err := auth.HashPassword(password)
+	if errors.Is(err, auth.ErrPasswordLength) {
+		c.String(http.StatusBadRequest, err.Error())
+		return db.Credentials{}, false
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return db.Credentials{}, false
+	}
+	return db.Credentials{Username: username, PasswordHash: passwordHash}, true
+}
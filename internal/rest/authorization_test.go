@@ -0,0 +1,203 @@
+package rest_test
+
+import (
+	"ClipsArchiver/internal/auth"
+	"ClipsArchiver/internal/db"
+	"ClipsArchiver/internal/rest/accounts"
+	"ClipsArchiver/internal/rest/clips"
+	"ClipsArchiver/internal/rest/files"
+	"ClipsArchiver/internal/rest/trimRequests"
+	"ClipsArchiver/internal/rest/users"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const ownerId = 1
+const otherId = 2
+const clipId = 10
+
+var configFiles = []string{"config.json", "apiConfig.json", "dbConfig.json", "authConfig.json", "uploadConfig.json", "ingestConfig.json"}
+
+// TestMain runs the tests in a folder with empty config files, so loading the config doesn't create them and exit
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "clipsarchiver-rest")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, name := range configFiles {
+		if err = os.WriteFile(dir+"/"+name, []byte("{}"), 0666); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err = os.Chdir(dir); err != nil {
+		log.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// containsMatcher matches queries that contain the expected sql, so expectations don't have to be regular expressions
+var containsMatcher = sqlmock.QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+	if !strings.Contains(actualSQL, expectedSQL) {
+		return fmt.Errorf("%q doesn't contain %q", actualSQL, expectedSQL)
+	}
+	return nil
+})
+
+// mockDb points the db package at a mock. Queries without an expectation fail, which the handlers either
+// ignore or answer with an error the tests don't expect.
+func mockDb(t *testing.T) sqlmock.Sqlmock {
+	conn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(containsMatcher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.MatchExpectationsInOrder(false)
+	db.UseConnection(slog.New(slog.NewTextHandler(io.Discard, nil)), conn)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return mock
+}
+
+// existingClip is the group clip of ownerId that expectClip returns
+var existingClip = db.Clip{Id: clipId, OwnerId: ownerId, Game: 1, Title: "Clutch", Visibility: db.VisibilityGroup}
+
+func expectClip(mock sqlmock.Sqlmock) {
+	rows := sqlmock.NewRows([]string{"id", "owner_id", "filename", "is_processed", "created_at", "duration", "map", "game_mode", "legend", "match_history_found", "ranked_image", "ranked_point_gain", "game", "title", "description", "visibility", "original_filename", "content_hash", "created_at_source", "storage_tier", "views", "favorites"}).
+		AddRow(existingClip.Id, existingClip.OwnerId, "clip.mp4", true, time.Now(), 30, nil, nil, nil, false, nil, nil, existingClip.Game, existingClip.Title, existingClip.Description, existingClip.Visibility, "clip.mp4", nil, "upload", "hot", 0, 0)
+	mock.ExpectQuery("FROM clips WHERE clips.id = ?").WillReturnRows(rows)
+}
+
+// serve sends the request to the handler on route as the caller with userId and role
+func serve(handler gin.HandlerFunc, method string, route string, path string, body any, userId int, role string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Handle(method, route, func(c *gin.Context) {
+		auth.SetCaller(c, userId, role)
+	}, handler)
+
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		jsonBytes, _ := json.Marshal(body)
+		reader = bytes.NewReader(jsonBytes)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(method, path, reader))
+	return recorder
+}
+
+func clipPath() string {
+	return fmt.Sprintf("/clips/%d", clipId)
+}
+
+func TestClipUpdate(t *testing.T) {
+	retitled := existingClip
+	retitled.Title = "Not a clutch"
+	tagged := existingClip
+	tagged.Tags = []string{"clutch"}
+
+	tests := []struct {
+		name   string
+		userId int
+		role   string
+		clip   db.Clip
+		want   int
+	}{
+		{"member changing someone else's title", otherId, db.RoleMember, retitled, http.StatusForbidden},
+		{"viewer changing tags", otherId, db.RoleViewer, tagged, http.StatusForbidden},
+		{"member changing someone else's tags", otherId, db.RoleMember, tagged, http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mock := mockDb(t)
+			expectClip(mock)
+			if test.want == http.StatusOK {
+				mock.ExpectQuery("SELECT id, name FROM tags WHERE tags.name = ?").WithArgs("clutch").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "clutch"))
+				mock.ExpectQuery("SELECT * FROM clips_tags WHERE clip_id = ? AND tag_id = ?").
+					WillReturnRows(sqlmock.NewRows([]string{"clip_id", "tag_id"}))
+				mock.ExpectExec("INSERT INTO clips_tags").WithArgs(clipId, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			recorder := serve(clips.Update, http.MethodPut, "/clips/:clipId", clipPath(), test.clip, test.userId, test.role)
+			if recorder.Code != test.want {
+				t.Errorf("status = %d, want %d: %s", recorder.Code, test.want, recorder.Body.String())
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestClipDelete(t *testing.T) {
+	mock := mockDb(t)
+	expectClip(mock)
+
+	recorder := serve(clips.Delete, http.MethodDelete, "/clips/:clipId", clipPath(), nil, otherId, db.RoleMember)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d for a member deleting someone else's clip", recorder.Code, http.StatusForbidden)
+	}
+}
+
+func TestViewersCantContribute(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		method  string
+		route   string
+		path    string
+	}{
+		{"upload", files.UploadClip, http.MethodPost, "/clips/upload", "/clips/upload"},
+		{"trim", trimRequests.Create, http.MethodPost, "/clips/trim/:clipId", fmt.Sprintf("/clips/trim/%d", clipId)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockDb(t)
+			recorder := serve(test.handler, test.method, test.route, test.path, nil, otherId, db.RoleViewer)
+			if recorder.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d for a viewer", recorder.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func TestAdminOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		method  string
+		route   string
+		path    string
+		body    any
+		userId  int
+		role    string
+	}{
+		{"member changing a role", users.SetRole, http.MethodPut, "/users/:id/role", fmt.Sprintf("/users/%d/role", otherId), gin.H{"role": db.RoleAdmin}, ownerId, db.RoleMember},
+		{"admin demoting themselves", users.SetRole, http.MethodPut, "/users/:id/role", fmt.Sprintf("/users/%d/role", ownerId), gin.H{"role": db.RoleMember}, ownerId, db.RoleAdmin},
+		{"member creating a user", accounts.CreateUser, http.MethodPost, "/users", "/users", gin.H{"name": "new", "username": "new", "password": "password123"}, ownerId, db.RoleMember},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockDb(t)
+			recorder := serve(test.handler, test.method, test.route, test.path, test.body, test.userId, test.role)
+			if recorder.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d: %s", recorder.Code, http.StatusForbidden, recorder.Body.String())
+			}
+		})
+	}
+}
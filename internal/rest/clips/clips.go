@@ -1,8 +1,11 @@
 package clips
 
 import (
+	"ClipsArchiver/internal/auth"
 	"ClipsArchiver/internal/db"
 	"ClipsArchiver/internal/rest"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"strconv"
@@ -11,23 +14,51 @@ import (
 )
 
 func Update(c *gin.Context) {
-	var clip db.Clip
-	if err := c.BindJSON(&clip); err != nil {
-		c.String(http.StatusBadRequest, "Invalid body for Clip")
-	}
 	clipId, conversionErr := strconv.Atoi(c.Param("clipId"))
 	if conversionErr != nil {
 		c.String(http.StatusBadRequest, "invalid clip id provided: %s", c.Param("clipId"))
 		return
 	}
-	existingClip, err := db.GetClipById(clipId)
+	existingClip, err := db.GetClipById(auth.Viewer(c), clipId)
 	if err != nil {
-		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		c.String(http.StatusNotFound, "no clip found with id: %d", clipId)
+		return
+	}
+	if !auth.CanContribute(c) {
+		c.String(http.StatusForbidden, "viewers can't change clips")
+		return
+	}
+	var clip db.Clip
+	if err = c.BindJSON(&clip); err != nil {
+		c.String(http.StatusBadRequest, "Invalid body for Clip")
+		return
+	}
+	// the path decides which clip is updated, and ownership can't be changed
+	clip.Id = existingClip.Id
+	clip.OwnerId = existingClip.OwnerId
+	if clip.Game == 0 {
+		clip.Game = existingClip.Game
+	}
+	if clip.Visibility == "" {
+		clip.Visibility = existingClip.Visibility
+	}
+	if !db.IsValidVisibility(clip.Visibility) {
+		c.String(http.StatusBadRequest, rest.ErrorVisibilityFormat)
+		return
+	}
+	// any member can tag the clips they can see, everything else is up to the owner
+	tagsOnly := changesOnlyTags(existingClip, clip)
+	if !tagsOnly && !auth.CanModify(c, existingClip.OwnerId) {
+		c.String(http.StatusForbidden, "only the owner of a clip or an admin can change anything but its tags")
 		return
 	}
 	err = db.UpdateClipTags(existingClip, clip)
 	if err != nil {
 		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	if tagsOnly {
+		return
 	}
 	err = db.UpdateClip(clip)
 	if err != nil {
@@ -35,12 +66,29 @@ func Update(c *gin.Context) {
 	}
 }
 
+// changesOnlyTags reports whether the update leaves every field UpdateClip writes as it is
+func changesOnlyTags(existing db.Clip, clip db.Clip) bool {
+	return clip.Game == existing.Game && clip.Title == existing.Title && clip.Description == existing.Description &&
+		clip.Map == existing.Map && clip.GameMode == existing.GameMode && clip.Legend == existing.Legend &&
+		clip.MatchHistoryFound == existing.MatchHistoryFound && clip.BrRankImg == existing.BrRankImg &&
+		clip.BrScoreChange == existing.BrScoreChange && clip.Visibility == existing.Visibility
+}
+
 func Delete(c *gin.Context) {
 	clipId, err := strconv.Atoi(c.Param("clipId"))
 	if err != nil {
 		c.String(http.StatusBadRequest, "invalid clip id provided: %s", c.Param("clipId"))
 		return
 	}
+	clip, err := db.GetClipById(auth.Viewer(c), clipId)
+	if err != nil {
+		c.String(http.StatusNotFound, "no clip found with id: %d", clipId)
+		return
+	}
+	if !auth.CanModify(c, clip.OwnerId) {
+		c.String(http.StatusForbidden, "only the owner of a clip or an admin can delete it")
+		return
+	}
 	err = db.DeleteClipById(clipId)
 	if err != nil {
 		c.String(http.StatusBadRequest, "invalid clip id provided: %s", c.Param("clipId"))
@@ -55,7 +103,7 @@ func Get(c *gin.Context) {
 		c.String(http.StatusBadRequest, "invalid clip id provided: %s", c.Param("clipId"))
 		return
 	}
-	clip, err := db.GetClipById(clipId)
+	clip, err := db.GetClipById(auth.Viewer(c), clipId)
 	if err != nil {
 		c.String(http.StatusBadRequest, "invalid clip id provided: %s", c.Param("clipId"))
 		return
@@ -63,7 +111,14 @@ func Get(c *gin.Context) {
 	c.IndentedJSON(http.StatusOK, clip)
 }
 
+// GetForDate lists the clips from midnight to midnight on the date in the timezone query value, or the server's
+// timezone when there isn't one
 func GetForDate(c *gin.Context) {
+	location, err := rest.ParseTimezone(c.Query("timezone"))
+	if err != nil {
+		c.String(http.StatusBadRequest, rest.ErrorTimezoneFormat)
+		return
+	}
 	date := c.Param("date")
 	values := strings.Split(date, "-")
 	if len(values) != 3 {
@@ -88,7 +143,7 @@ func GetForDate(c *gin.Context) {
 		return
 	}
 
-	clips, err := db.GetClipsForDate(time.Date(year, time.Month(month), day, 4, 0, 0, 0, time.UTC))
+	clips, err := db.GetClipsForDate(auth.Viewer(c), time.Date(year, time.Month(month), day, 0, 0, 0, 0, location))
 
 	if err != nil {
 		println(err)
@@ -99,7 +154,7 @@ func GetForDate(c *gin.Context) {
 }
 
 func GetByFilename(c *gin.Context) {
-	clip, err := db.GetClipByFilename(c.Param("filename"))
+	clip, err := db.GetClipByFilename(auth.Viewer(c), c.Param("filename"))
 	if err != nil {
 		println(err.Error())
 		c.String(http.StatusNotFound, "No clip found for filename")
@@ -107,3 +162,237 @@ func GetByFilename(c *gin.Context) {
 	}
 	c.IndentedJSON(http.StatusOK, clip)
 }
+
+func GetMatch(c *gin.Context) {
+	clipId, err := strconv.Atoi(c.Param("clipId"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid clip id provided: %s", c.Param("clipId"))
+		return
+	}
+	clip, err := db.GetClipById(auth.Viewer(c), clipId)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid clip id provided: %s", c.Param("clipId"))
+		return
+	}
+	matchGroupId, err := db.GetMatchGroupIdForClip(clip)
+	if err != nil {
+		c.String(http.StatusNotFound, "no match found for clip: %d", clipId)
+		return
+	}
+	matchGroup, err := db.GetMatchGroupById(matchGroupId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, matchGroup)
+}
+
+// GetReencodes lists the archive re-encodes of a clip, with the space each one saved
+func GetReencodes(c *gin.Context) {
+	clipId, err := strconv.Atoi(c.Param("clipId"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid clip id provided: %s", c.Param("clipId"))
+		return
+	}
+	if _, err = db.GetClipById(auth.Viewer(c), clipId); err != nil {
+		c.String(http.StatusBadRequest, "invalid clip id provided: %s", c.Param("clipId"))
+		return
+	}
+	jobs, err := db.GetReencodeJobsForClip(clipId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, jobs)
+}
+
+const defaultPageSize = 50
+const maxPageSize = 200
+
+func List(c *gin.Context) {
+	filter, err := parseClipFilter(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := db.SearchClips(filter)
+	if errors.Is(err, db.ErrInvalidCursor) {
+		c.String(http.StatusBadRequest, "invalid cursor provided")
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, page)
+}
+
+func parseClipFilter(c *gin.Context) (db.ClipFilter, error) {
+	filter := db.ClipFilter{Viewer: auth.Viewer(c)}
+	var err error
+
+	if filter.OwnerIds, err = rest.ParseIntList(c.Query("ownerId")); err != nil {
+		return filter, fmt.Errorf("invalid owner id provided: %s", c.Query("ownerId"))
+	}
+	if filter.GameId, err = rest.ParseOptionalInt(c.Query("game")); err != nil {
+		return filter, fmt.Errorf("invalid game id provided: %s", c.Query("game"))
+	}
+	if filter.From, err = rest.ParseOptionalDate(c.Query("from")); err != nil {
+		return filter, errors.New(rest.ErrorDateFormat)
+	}
+	if filter.To, err = rest.ParseOptionalDate(c.Query("to")); err != nil {
+		return filter, errors.New(rest.ErrorDateFormat)
+	}
+	if filter.To.Valid {
+		filter.To.Time = filter.To.Time.AddDate(0, 0, 1)
+	}
+	if filter.MapIds, err = rest.ParseIntList(c.Query("map")); err != nil {
+		return filter, fmt.Errorf("invalid map id provided: %s", c.Query("map"))
+	}
+	if filter.LegendIds, err = rest.ParseIntList(c.Query("legend")); err != nil {
+		return filter, fmt.Errorf("invalid legend id provided: %s", c.Query("legend"))
+	}
+	if filter.GameMode, err = rest.ParseOptionalGameMode(c.Query("mode")); err != nil {
+		return filter, errors.New(rest.ErrorGameModeFormat)
+	}
+
+	filter.Tags = rest.ParseStringList(c.Query("tags"))
+	filter.TagMatch = c.DefaultQuery("tagMatch", db.TagMatchAny)
+	if filter.TagMatch != db.TagMatchAny && filter.TagMatch != db.TagMatchAll {
+		return filter, fmt.Errorf("invalid tag match provided: %s. Should be any or all", filter.TagMatch)
+	}
+
+	if filter.MinDuration, err = rest.ParseOptionalInt(c.Query("minDuration")); err != nil {
+		return filter, fmt.Errorf("invalid minimum duration provided: %s", c.Query("minDuration"))
+	}
+	if filter.MaxDuration, err = rest.ParseOptionalInt(c.Query("maxDuration")); err != nil {
+		return filter, fmt.Errorf("invalid maximum duration provided: %s", c.Query("maxDuration"))
+	}
+	if filter.MinScoreChange, err = rest.ParseOptionalInt(c.Query("minScoreChange")); err != nil {
+		return filter, fmt.Errorf("invalid minimum score change provided: %s", c.Query("minScoreChange"))
+	}
+	if filter.MaxScoreChange, err = rest.ParseOptionalInt(c.Query("maxScoreChange")); err != nil {
+		return filter, fmt.Errorf("invalid maximum score change provided: %s", c.Query("maxScoreChange"))
+	}
+	if filter.IsProcessed, err = rest.ParseOptionalBool(c.Query("processed")); err != nil {
+		return filter, fmt.Errorf("invalid processed value provided: %s", c.Query("processed"))
+	}
+
+	filter.Sort = c.DefaultQuery("sort", db.ClipSortCreatedAt)
+	if !db.IsValidClipSort(filter.Sort) {
+		return filter, fmt.Errorf("invalid sort provided: %s", filter.Sort)
+	}
+	switch c.DefaultQuery("order", "desc") {
+	case "asc":
+		filter.Descending = false
+	case "desc":
+		filter.Descending = true
+	default:
+		return filter, fmt.Errorf("invalid order provided: %s. Should be asc or desc", c.Query("order"))
+	}
+
+	filter.Cursor = c.Query("cursor")
+	filter.Limit = defaultPageSize
+	if c.Query("limit") != "" {
+		filter.Limit, err = strconv.Atoi(c.Query("limit"))
+		if err != nil || filter.Limit < 1 || filter.Limit > maxPageSize {
+			return filter, fmt.Errorf("invalid limit provided: %s. Should be between 1 and %d", c.Query("limit"), maxPageSize)
+		}
+	}
+	return filter, nil
+}
+
+func Search(c *gin.Context) {
+	text := c.Query("q")
+	if text == "" {
+		c.String(http.StatusBadRequest, "no search query provided")
+		return
+	}
+
+	limit := defaultPageSize
+	if c.Query("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(c.Query("limit"))
+		if err != nil || limit < 1 || limit > maxPageSize {
+			c.String(http.StatusBadRequest, "invalid limit provided: %s. Should be between 1 and %d", c.Query("limit"), maxPageSize)
+			return
+		}
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.String(http.StatusBadRequest, "invalid offset provided: %s", c.Query("offset"))
+		return
+	}
+
+	results, err := db.FullTextSearchClips(auth.Viewer(c), text, limit, offset)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, results)
+}
+
+const maxBulkClips = 500
+const maxTagLength = 32
+
+func Bulk(c *gin.Context) {
+	var operation db.BulkClipOperation
+	if err := c.BindJSON(&operation); err != nil {
+		c.String(http.StatusBadRequest, "invalid body for bulk operation")
+		return
+	}
+	if len(operation.ClipIds) == 0 || len(operation.ClipIds) > maxBulkClips {
+		c.String(http.StatusBadRequest, "invalid clip ids: should contain between 1 and %d ids", maxBulkClips)
+		return
+	}
+
+	switch operation.Operation {
+	case db.BulkAddTags, db.BulkRemoveTags:
+		if len(operation.Tags) == 0 {
+			c.String(http.StatusBadRequest, "no tags provided for %s", operation.Operation)
+			return
+		}
+		for i, tag := range operation.Tags {
+			operation.Tags[i] = strings.TrimSpace(tag)
+			if operation.Tags[i] == "" || len(operation.Tags[i]) > maxTagLength {
+				c.String(http.StatusBadRequest, "invalid tag provided: %s", tag)
+				return
+			}
+		}
+	case db.BulkSetGameMode:
+		if operation.GameMode.Valid {
+			gameMode, err := rest.ParseOptionalGameMode(operation.GameMode.String)
+			if err != nil {
+				c.String(http.StatusBadRequest, rest.ErrorGameModeFormat)
+				return
+			}
+			operation.GameMode = gameMode
+		}
+	case db.BulkSetLegend, db.BulkSetMap, db.BulkDelete, db.BulkRequeueTranscode:
+	default:
+		c.String(http.StatusBadRequest, "invalid operation provided: %s", operation.Operation)
+		return
+	}
+
+	if !auth.IsAdmin(c) {
+		owners, err := db.GetClipOwners(operation.ClipIds)
+		if err != nil {
+			c.String(http.StatusInternalServerError, rest.ErrorDefault)
+			return
+		}
+		for _, ownerId := range owners {
+			if !auth.CanModify(c, ownerId) {
+				c.String(http.StatusForbidden, "bulk operations can only include your own clips")
+				return
+			}
+		}
+	}
+
+	results, err := db.RunBulkClipOperation(operation)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, results)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, results)
+}
@@ -0,0 +1,110 @@
+package clips
+
+import (
+	"ClipsArchiver/internal/auth"
+	"ClipsArchiver/internal/db"
+	"ClipsArchiver/internal/rest"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+const maxEmojiLength = 16
+
+// AddFavorite and the other favorite and reaction handlers act for the caller
+func AddFavorite(c *gin.Context) {
+	userId, clipId, ok := getCallerAndClipFromPath(c)
+	if !ok {
+		return
+	}
+
+	err := db.AddFavorite(userId, clipId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.String(http.StatusNoContent, "Added favorite")
+}
+
+func RemoveFavorite(c *gin.Context) {
+	userId, clipId, ok := getCallerAndClipFromPath(c)
+	if !ok {
+		return
+	}
+
+	err := db.RemoveFavorite(userId, clipId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.String(http.StatusNoContent, "Removed favorite")
+}
+
+func AddReaction(c *gin.Context) {
+	userId, clipId, ok := getCallerAndClipFromPath(c)
+	if !ok {
+		return
+	}
+	emoji := c.Param("emoji")
+	if !isValidEmoji(emoji) {
+		c.String(http.StatusBadRequest, "invalid reaction provided: %s", emoji)
+		return
+	}
+
+	err := db.AddReaction(userId, clipId, emoji)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.String(http.StatusNoContent, "Added reaction")
+}
+
+func RemoveReaction(c *gin.Context) {
+	userId, clipId, ok := getCallerAndClipFromPath(c)
+	if !ok {
+		return
+	}
+
+	err := db.RemoveReaction(userId, clipId, c.Param("emoji"))
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.String(http.StatusNoContent, "Removed reaction")
+}
+
+// getCallerAndClipFromPath returns the caller, whose favorites and reactions are changed, and the clip named by the
+// :clipId path parameter, writing an error response if there isn't one
+func getCallerAndClipFromPath(c *gin.Context) (int, int, bool) {
+	userId, ok := auth.UserId(c)
+	if !ok {
+		c.String(http.StatusUnauthorized, "authentication required")
+		return 0, 0, false
+	}
+	clipId, err := strconv.Atoi(c.Param("clipId"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid clip id provided: %s", c.Param("clipId"))
+		return 0, 0, false
+	}
+	_, err = db.GetClipById(auth.Viewer(c), clipId)
+	if err != nil {
+		c.String(http.StatusNotFound, "no clip found with id: %d", clipId)
+		return 0, 0, false
+	}
+	return userId, clipId, true
+}
+
+// isValidEmoji keeps reactions to emoji by rejecting anything containing letters, digits or whitespace
+func isValidEmoji(emoji string) bool {
+	if emoji == "" || utf8.RuneCountInString(emoji) > maxEmojiLength {
+		return false
+	}
+	for _, r := range emoji {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,265 @@
+package collections
+
+import (
+	"ClipsArchiver/internal/auth"
+	"ClipsArchiver/internal/db"
+	"ClipsArchiver/internal/rest"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const maxTitleLength = 128
+const maxDescriptionLength = 2000
+
+type clipRequest struct {
+	ClipId int `json:"clipId"`
+}
+
+type orderRequest struct {
+	ClipIds []int `json:"clipIds"`
+}
+
+func GetAllPublic(c *gin.Context) {
+	collections, err := db.GetPublicCollections()
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, collections)
+}
+
+func GetForUser(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid user id provided: %s", c.Param("id"))
+		return
+	}
+	collections, err := db.GetCollectionsForUser(userId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	if !auth.IsUser(c, userId) && !auth.IsAdmin(c) {
+		collections = slices.DeleteFunc(collections, func(collection db.Collection) bool { return !collection.IsPublic })
+	}
+	c.IndentedJSON(http.StatusOK, collections)
+}
+
+func Get(c *gin.Context) {
+	collection, ok := getCollectionFromPath(c)
+	if !ok {
+		return
+	}
+	clips, err := db.GetClipsForCollection(auth.Viewer(c), collection.Id)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	collection.Clips = clips
+	c.IndentedJSON(http.StatusOK, collection)
+}
+
+func Create(c *gin.Context) {
+	if !auth.CanContribute(c) {
+		c.String(http.StatusForbidden, "viewers can't create collections")
+		return
+	}
+	var collection db.Collection
+	if err := c.BindJSON(&collection); err != nil {
+		c.String(http.StatusBadRequest, "invalid body for Collection")
+		return
+	}
+	collection.OwnerId, _ = auth.UserId(c)
+	if !validateCollection(c, &collection) {
+		return
+	}
+
+	collection, err := db.CreateCollection(collection)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	if collection.CoverClipId.Valid {
+		_ = db.AddClipToCollection(collection.Id, int(collection.CoverClipId.Int32))
+		collection.ClipCount = 1
+	}
+	c.IndentedJSON(http.StatusCreated, collection)
+}
+
+func Update(c *gin.Context) {
+	existing, ok := getCollectionFromPath(c)
+	if !ok {
+		return
+	}
+	if !auth.CanModify(c, existing.OwnerId) {
+		c.String(http.StatusForbidden, "only the owner of a collection or an admin can change it")
+		return
+	}
+	var collection db.Collection
+	if err := c.BindJSON(&collection); err != nil {
+		c.String(http.StatusBadRequest, "invalid body for Collection")
+		return
+	}
+	collection.Id = existing.Id
+	collection.OwnerId = existing.OwnerId
+	if !validateCollection(c, &collection) {
+		return
+	}
+
+	err := db.UpdateCollection(collection)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	if collection.CoverClipId.Valid {
+		_ = db.AddClipToCollection(collection.Id, int(collection.CoverClipId.Int32))
+	}
+
+	collection, err = db.GetCollectionById(existing.Id)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, collection)
+}
+
+func Delete(c *gin.Context) {
+	collection, ok := getCollectionFromPath(c)
+	if !ok {
+		return
+	}
+	if !auth.CanModify(c, collection.OwnerId) {
+		c.String(http.StatusForbidden, "only the owner of a collection or an admin can change it")
+		return
+	}
+	err := db.DeleteCollectionById(collection.Id)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.String(http.StatusNoContent, "Deleted collection")
+}
+
+func AddClip(c *gin.Context) {
+	collection, ok := getCollectionFromPath(c)
+	if !ok {
+		return
+	}
+	if !auth.CanModify(c, collection.OwnerId) {
+		c.String(http.StatusForbidden, "only the owner of a collection or an admin can change it")
+		return
+	}
+	var request clipRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "invalid body for collection clip")
+		return
+	}
+	if _, err := db.GetClipById(auth.Viewer(c), request.ClipId); err != nil {
+		c.String(http.StatusNotFound, "no clip found with id: %d", request.ClipId)
+		return
+	}
+
+	err := db.AddClipToCollection(collection.Id, request.ClipId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.String(http.StatusCreated, "Added clip")
+}
+
+func RemoveClip(c *gin.Context) {
+	collection, ok := getCollectionFromPath(c)
+	if !ok {
+		return
+	}
+	if !auth.CanModify(c, collection.OwnerId) {
+		c.String(http.StatusForbidden, "only the owner of a collection or an admin can change it")
+		return
+	}
+	clipId, err := strconv.Atoi(c.Param("clipId"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid clip id provided: %s", c.Param("clipId"))
+		return
+	}
+
+	err = db.RemoveClipFromCollection(collection.Id, clipId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.String(http.StatusNoContent, "Removed clip")
+}
+
+// Reorder takes every clip id in the collection in their new order
+func Reorder(c *gin.Context) {
+	collection, ok := getCollectionFromPath(c)
+	if !ok {
+		return
+	}
+	if !auth.CanModify(c, collection.OwnerId) {
+		c.String(http.StatusForbidden, "only the owner of a collection or an admin can change it")
+		return
+	}
+	var request orderRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "invalid body for collection order")
+		return
+	}
+
+	err := db.ReorderCollection(collection.Id, request.ClipIds)
+	if errors.Is(err, db.ErrCollectionOrder) {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+
+	clips, err := db.GetClipsForCollection(auth.Viewer(c), collection.Id)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	collection.Clips = clips
+	c.IndentedJSON(http.StatusOK, collection)
+}
+
+// getCollectionFromPath loads the collection named by the :collectionId path parameter, writing an error response if it can't
+func getCollectionFromPath(c *gin.Context) (db.Collection, bool) {
+	collectionId, err := strconv.Atoi(c.Param("collectionId"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid collection id provided: %s", c.Param("collectionId"))
+		return db.Collection{}, false
+	}
+	collection, err := db.GetCollectionById(collectionId)
+	// private collections are hidden from everyone but their owner and admins
+	if err != nil || (!collection.IsPublic && !auth.IsUser(c, collection.OwnerId) && !auth.IsAdmin(c)) {
+		c.String(http.StatusNotFound, "no collection found with id: %d", collectionId)
+		return db.Collection{}, false
+	}
+	return collection, true
+}
+
+func validateCollection(c *gin.Context, collection *db.Collection) bool {
+	collection.Title = strings.TrimSpace(collection.Title)
+	if collection.Title == "" || len(collection.Title) > maxTitleLength {
+		c.String(http.StatusBadRequest, "invalid collection title: should be between 1 and %d characters", maxTitleLength)
+		return false
+	}
+	if len(collection.Description) > maxDescriptionLength {
+		c.String(http.StatusBadRequest, "invalid collection description: should be at most %d characters", maxDescriptionLength)
+		return false
+	}
+	if collection.CoverClipId.Valid {
+		if _, err := db.GetClipById(auth.Viewer(c), int(collection.CoverClipId.Int32)); err != nil {
+			c.String(http.StatusNotFound, "no clip found with id: %d", collection.CoverClipId.Int32)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,193 @@
+package comments
+
+import (
+	"ClipsArchiver/internal/auth"
+	"ClipsArchiver/internal/db"
+	"ClipsArchiver/internal/rest"
+	"database/sql"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxBodyLength = 2000
+
+type commentRequest struct {
+	ParentId  sql.NullInt32 `json:"parentId"`
+	Timestamp sql.NullInt32 `json:"timestamp"`
+	Body      string        `json:"body"`
+}
+
+func GetForClip(c *gin.Context) {
+	clipId, err := strconv.Atoi(c.Param("clipId"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid clip id provided: %s", c.Param("clipId"))
+		return
+	}
+	comments, err := db.GetCommentsForClip(auth.Viewer(c), clipId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, comments)
+}
+
+func GetForUser(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid user id provided: %s", c.Param("id"))
+		return
+	}
+	comments, err := db.GetCommentsForUser(auth.Viewer(c), userId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, comments)
+}
+
+func Get(c *gin.Context) {
+	comment, ok := getCommentFromPath(c)
+	if !ok {
+		return
+	}
+	c.IndentedJSON(http.StatusOK, comment)
+}
+
+func Create(c *gin.Context) {
+	if !auth.CanContribute(c) {
+		c.String(http.StatusForbidden, "viewers can't comment")
+		return
+	}
+	clipId, err := strconv.Atoi(c.Param("clipId"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid clip id provided: %s", c.Param("clipId"))
+		return
+	}
+	clip, err := db.GetClipById(auth.Viewer(c), clipId)
+	if err != nil {
+		c.String(http.StatusNotFound, "no clip found with id: %d", clipId)
+		return
+	}
+	var request commentRequest
+	if err = c.BindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "invalid body for Comment")
+		return
+	}
+	if request.ParentId.Valid {
+		parent, err := db.GetCommentById(auth.Viewer(c), int(request.ParentId.Int32))
+		if err != nil || parent.ClipId != clip.Id {
+			c.String(http.StatusBadRequest, "no comment found with id %d on this clip", request.ParentId.Int32)
+			return
+		}
+		if parent.IsDeleted {
+			c.String(http.StatusBadRequest, "can't reply to deleted comment %d", parent.Id)
+			return
+		}
+	}
+
+	authorId, _ := auth.UserId(c)
+	comment := db.Comment{ClipId: clip.Id, AuthorId: authorId, ParentId: request.ParentId, Timestamp: request.Timestamp, Body: request.Body}
+	if !validateComment(c, &comment, clip) {
+		return
+	}
+
+	comment, err = db.CreateComment(comment)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, comment)
+}
+
+// Update edits the body and timestamp of a comment. Only the author can edit their comments.
+func Update(c *gin.Context) {
+	comment, ok := getCommentFromPath(c)
+	if !ok {
+		return
+	}
+	var request commentRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "invalid body for Comment")
+		return
+	}
+	if !auth.IsUser(c, comment.AuthorId) || !auth.CanContribute(c) {
+		c.String(http.StatusForbidden, "only the author can edit a comment")
+		return
+	}
+	if comment.IsDeleted {
+		c.String(http.StatusBadRequest, "comment %d has been deleted", comment.Id)
+		return
+	}
+	clip, err := db.GetClipById(auth.Viewer(c), comment.ClipId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+
+	comment.Body = request.Body
+	comment.Timestamp = request.Timestamp
+	if !validateComment(c, &comment, clip) {
+		return
+	}
+
+	err = db.UpdateComment(comment)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	comment, err = db.GetCommentById(auth.Viewer(c), comment.Id)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, comment)
+}
+
+// Delete removes a comment. Authors can delete their own comments and admins can delete any.
+func Delete(c *gin.Context) {
+	comment, ok := getCommentFromPath(c)
+	if !ok {
+		return
+	}
+	if !auth.CanModify(c, comment.AuthorId) {
+		c.String(http.StatusForbidden, "only the author or an admin can delete a comment")
+		return
+	}
+
+	err := db.DeleteCommentById(comment.Id)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.String(http.StatusNoContent, "Deleted comment")
+}
+
+// getCommentFromPath loads the comment named by the :commentId path parameter, writing an error response if it can't
+func getCommentFromPath(c *gin.Context) (db.Comment, bool) {
+	commentId, err := strconv.Atoi(c.Param("commentId"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid comment id provided: %s", c.Param("commentId"))
+		return db.Comment{}, false
+	}
+	comment, err := db.GetCommentById(auth.Viewer(c), commentId)
+	if err != nil {
+		c.String(http.StatusNotFound, "no comment found with id: %d", commentId)
+		return db.Comment{}, false
+	}
+	return comment, true
+}
+
+func validateComment(c *gin.Context, comment *db.Comment, clip db.Clip) bool {
+	comment.Body = strings.TrimSpace(comment.Body)
+	if comment.Body == "" || len(comment.Body) > maxBodyLength {
+		c.String(http.StatusBadRequest, "invalid comment body: should be between 1 and %d characters", maxBodyLength)
+		return false
+	}
+	if comment.Timestamp.Valid && (comment.Timestamp.Int32 < 0 || (clip.Duration > 0 && int(comment.Timestamp.Int32) > clip.Duration)) {
+		c.String(http.StatusBadRequest, "invalid comment timestamp: should be between 0 and %d seconds", clip.Duration)
+		return false
+	}
+	return true
+}
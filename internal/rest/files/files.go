@@ -1,124 +1,343 @@
 package files
 
 import (
-	"ClipsArchiver/internal/config"
+	"ClipsArchiver/internal/auth"
 	"ClipsArchiver/internal/db"
-	"ClipsArchiver/internal/rabbitmq"
+	"ClipsArchiver/internal/ingest"
+	"ClipsArchiver/internal/media"
+	"ClipsArchiver/internal/rest"
+	"ClipsArchiver/internal/signing"
+	"ClipsArchiver/internal/storage"
+	"database/sql"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"io"
+	"mime"
 	"net/http"
+	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// maxFormValueSize limits the form values sent along with an upload, the file itself isn't limited
+const maxFormValueSize = 1024
+
+var errInvalidCreationDate = errors.New("invalid creation date provided")
+var errMissingFile = errors.New("no file provided")
+
+// viewWindow is how long repeat requests from the same viewer count as a single view
+const viewWindow = 30 * time.Minute
+
+// presignLifetime is how long the links files are redirected to work for. Players are sent through the signed
+// urls again for every range they request, so these don't have to last long.
+const presignLifetime = time.Hour
+
+type receivedUpload struct {
+	path        string
+	filename    string
+	contentHash string
+	values      map[string]string
+}
+
 type TrimRequest struct {
 	StartTime int `json:"startTime"`
 	EndTime   int `json:"endTime"`
 }
 
 func UploadClip(c *gin.Context) {
-	ownerId, conversionErr := strconv.Atoi(c.Param("ownerId"))
-	if conversionErr != nil {
-		println(conversionErr.Error())
-		c.String(http.StatusBadRequest, "invalid owner id provided: %s", c.Param("ownerId"))
+	if !auth.CanContribute(c) {
+		c.String(http.StatusForbidden, "viewers can't upload clips")
 		return
 	}
-	// Single file
-	form, err := c.MultipartForm()
-	files := form.File["file"]
-	file := files[0]
+	// the uploader is always the caller
+	ownerId, _ := auth.UserId(c)
+	received, err := receiveMultipartUpload(c)
 	if err != nil {
 		println(err.Error())
 		c.String(http.StatusBadRequest, "get form err: %s", err.Error())
 		return
 	}
+	// only left behind if the clip isn't created
+	defer os.Remove(received.path)
 
-	clip, err := db.GetClipByFilename(file.Filename)
-
-	if err == nil {
-		println("clip exists")
-		c.String(http.StatusBadRequest, "file already uploaded as clip with id: %d", clip.Id)
+	gameSlug := db.GameSlugApexLegends
+	if received.values["game"] != "" {
+		gameSlug = received.values["game"]
+	}
+	game, err := db.GetGameBySlug(gameSlug)
+	if err != nil {
+		c.String(http.StatusBadRequest, "unknown game: %s", gameSlug)
 		return
 	}
 
-	filename := config.GetInputPath() + filepath.Base(file.Filename)
-	if err := c.SaveUploadedFile(file, filename); err != nil {
+	location, err := rest.ParseTimezone(received.values["timezone"])
+	if err != nil {
+		c.String(http.StatusBadRequest, rest.ErrorTimezoneFormat)
+		return
+	}
+	clientCreatedAt, err := parseCreationDateTime(received.values["creationDateTime"], location)
+	if err != nil {
 		println(err.Error())
-		c.String(http.StatusBadRequest, "upload file err: %s", err.Error())
+		c.String(http.StatusBadRequest, "invalid creation date provided")
+		return
+	}
+	clip, err := ingest.CreateClip(ingest.NewClip{
+		OwnerId:          ownerId,
+		GameId:           game.Id,
+		ReceivedPath:     received.path,
+		OriginalFilename: received.filename,
+		ContentHash:      received.contentHash,
+		ClientCreatedAt:  clientCreatedAt,
+		Location:         location,
+	})
+	if errors.Is(err, db.ErrDuplicateClip) {
+		respondDuplicate(c, clip)
 		return
 	}
+	var validationErr *media.ValidationError
+	if errors.As(err, &validationErr) {
+		c.IndentedJSON(http.StatusUnprocessableEntity, validationErr)
+		return
+	}
+	if err != nil {
+		println(err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, clip)
+}
+
+// receiveMultipartUpload streams the file part of a multipart upload straight into the input folder, hashing it
+// on the way, and collects the other form values whichever order they come in
+func receiveMultipartUpload(c *gin.Context) (receivedUpload, error) {
+	received := receivedUpload{values: make(map[string]string)}
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return received, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err == nil && part.FormName() == "file" && received.path == "" {
+			received.filename = filepath.Base(part.FileName())
+			received.path, received.contentHash, err = ingest.SaveWithHash(part)
+			if err != nil {
+				return received, err
+			}
+			continue
+		}
+		var value []byte
+		if err == nil {
+			value, err = io.ReadAll(io.LimitReader(part, maxFormValueSize))
+		}
+		if err != nil {
+			if received.path != "" {
+				_ = os.Remove(received.path)
+			}
+			return received, err
+		}
+		received.values[part.FormName()] = string(value)
+	}
+	if received.path == "" {
+		return received, errMissingFile
+	}
+	return received, nil
+}
+
+// parseCreationDateTime reads the creation time clients can send along with uploads, either RFC 3339 or the
+// YYYY-MM-DD-hh-mm-ss of older clients, which is read in location. Older clients don't send a timezone, so that's
+// UTC for them, the way these were always read. It's optional, so an empty value gives an invalid NullTime.
+func parseCreationDateTime(creationDateTime string, location *time.Location) (sql.NullTime, error) {
+	if creationDateTime == "" {
+		return sql.NullTime{}, nil
+	}
+	if dateTime, err := time.Parse(time.RFC3339, creationDateTime); err == nil {
+		return sql.NullTime{Time: dateTime, Valid: true}, nil
+	}
 
-	creationDateTime := form.Value["creationDateTime"][0]
 	dateTimeParts := strings.Split(creationDateTime, "-")
 	var dateTimePartsAsIntegers [6]int
 
 	if len(dateTimeParts) != 6 {
-		c.String(http.StatusBadRequest, "invalid creation date provided")
-		return
+		return sql.NullTime{}, errInvalidCreationDate
 	}
 
 	for i := 0; i < 6; i++ {
 		number, err := strconv.Atoi(dateTimeParts[i])
 		if err != nil {
-			println(err.Error())
-			c.String(http.StatusBadRequest, "invalid creation date provided")
-			return
+			return sql.NullTime{}, err
 		}
 		dateTimePartsAsIntegers[i] = number
 	}
-	dateTime := time.Date(dateTimePartsAsIntegers[0], time.Month(dateTimePartsAsIntegers[1]), dateTimePartsAsIntegers[2], dateTimePartsAsIntegers[3], dateTimePartsAsIntegers[4], dateTimePartsAsIntegers[5], 0, time.UTC)
-	clip, err = db.AddClip(ownerId, file.Filename, dateTime)
+	dateTime := time.Date(dateTimePartsAsIntegers[0], time.Month(dateTimePartsAsIntegers[1]), dateTimePartsAsIntegers[2], dateTimePartsAsIntegers[3], dateTimePartsAsIntegers[4], dateTimePartsAsIntegers[5], 0, location)
+	return sql.NullTime{Time: dateTime, Valid: true}, nil
+}
+
+// respondDuplicate answers an upload of a file that's already a clip with that clip, if the uploader can see it
+func respondDuplicate(c *gin.Context, existing db.Clip) {
+	if clip, err := db.GetClipById(auth.Viewer(c), existing.Id); err == nil {
+		c.IndentedJSON(http.StatusConflict, clip)
+		return
+	}
+	c.String(http.StatusConflict, "this clip has already been uploaded")
+}
+
+func DownloadClipById(c *gin.Context) {
+	clipId, conversionErr := strconv.Atoi(c.Param("clipId"))
+	if conversionErr != nil {
+		c.String(http.StatusBadRequest, "invalid clip id provided: %s", c.Param("clipId"))
+		return
+	}
+	clip, err := db.GetClipById(auth.Viewer(c), clipId)
+
 	if err != nil {
-		println(err.Error())
-		c.String(http.StatusInternalServerError, err.Error())
+		c.String(http.StatusBadRequest, "no clip found with id: %d", clipId)
 		return
 	}
 
-	var requestEntry rabbitmq.RequestEntry
-	requestEntry.Id, err = db.CreateTranscodeRequest(clip.Id)
-	requestEntry.RequestType = 0
+	serveClip(c, clip, clip.Filename)
+	recordView(c, clip.Id)
+}
+
+// RequireSignature only lets requests for the static file routes through when they carry a valid, unexpired
+// signature for the exact file, so neither other files nor directory listings can be reached with a shared link.
+// The clip's visibility is checked again for whoever the link was handed out to, so links stop working once
+// a clip is made private, or once the share link they came from is revoked or expires.
+func RequireSignature(c *gin.Context) {
+	grant := c.Query(signing.GrantParam)
+	if !signing.VerifyPath(c.Request.URL.Path, c.Query(signing.ExpiresParam), grant, c.Query(signing.SignatureParam)) {
+		c.String(http.StatusForbidden, "invalid or expired link")
+		c.Abort()
+		return
+	}
+	viewer, err := db.GetViewerForGrant(grant)
 	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+		c.String(http.StatusForbidden, "invalid or expired link")
+		c.Abort()
+		return
+	}
+	// thumbnails are named after their clip with .png appended
+	filename := strings.TrimSuffix(path.Base(c.Param("filepath")), ".png")
+	if _, err = db.GetClipByFilename(viewer, filename); err != nil {
+		c.String(http.StatusNotFound, "file not found")
+		c.Abort()
 		return
 	}
+	c.Next()
+}
 
-	err = rabbitmq.PublishToTranscodeQueue(requestEntry)
+// RecordArchiveView counts a view when the archive route serves a clip, or sends the player on to the store
+func RecordArchiveView(c *gin.Context) {
+	c.Next()
+
+	status := c.Writer.Status()
+	if c.Request.Method == http.MethodHead || (status != http.StatusOK && status != http.StatusPartialContent && status != http.StatusTemporaryRedirect) {
+		return
+	}
+	// RequireSignature already checked the clip is visible to whoever the link was handed out to
+	clip, err := db.GetClipByFilename(db.SystemViewer, path.Base(c.Param("filepath")))
 	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+		return
 	}
+	recordView(c, clip.Id)
+}
 
-	c.IndentedJSON(http.StatusCreated, clip)
+// recordView counts a view for the caller, falling back to the client address for anonymous viewers
+func recordView(c *gin.Context, clipId int) {
+	var viewerId sql.NullInt32
+	if userId, ok := auth.UserId(c); ok {
+		viewerId = sql.NullInt32{Int32: int32(userId), Valid: true}
+	}
+	_ = db.RecordClipView(clipId, viewerId, c.ClientIP(), viewWindow)
 }
 
-func DownloadClipById(c *gin.Context) {
+func DownloadClipThumbnailById(c *gin.Context) {
 	clipId, conversionErr := strconv.Atoi(c.Param("clipId"))
 	if conversionErr != nil {
 		c.String(http.StatusBadRequest, "invalid clip id provided: %s", c.Param("clipId"))
 		return
 	}
-	clip, err := db.GetClipById(clipId)
+	clip, err := db.GetClipById(auth.Viewer(c), clipId)
 
 	if err != nil {
 		c.String(http.StatusBadRequest, "no clip found with id: %d", clipId)
 		return
 	}
 
-	c.FileAttachment(config.GetOutputPath()+clip.Filename, clip.Filename)
+	serveStored(c, storage.Thumbnails(), clip.Filename+".png", clip.Filename+".png")
 }
 
-func DownloadClipThumbnailById(c *gin.Context) {
-	clipId, conversionErr := strconv.Atoi(c.Param("clipId"))
-	if conversionErr != nil {
-		c.String(http.StatusBadRequest, "invalid clip id provided: %s", c.Param("clipId"))
+// ServeArchivedClip serves the clip a signed videoUri points to
+func ServeArchivedClip(c *gin.Context) {
+	// RequireSignature already checked the clip is visible to whoever the link was handed out to
+	clip, err := db.GetClipByFilename(db.SystemViewer, path.Base(c.Param("filepath")))
+	if err != nil {
+		c.String(http.StatusNotFound, "file not found")
 		return
 	}
-	clip, err := db.GetClipById(clipId)
+	serveClip(c, clip, "")
+}
+
+// ServeThumbnail serves the thumbnail a signed thumbnailUri points to
+func ServeThumbnail(c *gin.Context) {
+	serveStored(c, storage.Thumbnails(), path.Base(c.Param("filepath")), "")
+}
 
+// serveClip sends a clip from whichever tier holds it, looking on the tier it's recorded on first, since the
+// tiering mover may be partway through moving it
+func serveClip(c *gin.Context, clip db.Clip, downloadName string) {
+	tiers := []storage.Storage{storage.Clips(), storage.HotClips()}
+	if clip.StorageTier == db.StorageTierHot {
+		tiers = []storage.Storage{storage.HotClips(), storage.Clips()}
+	}
+	store, err := storage.Locate(clip.Filename, tiers...)
 	if err != nil {
-		c.String(http.StatusBadRequest, "no clip found with id: %d", clipId)
+		c.String(http.StatusNotFound, "file not found")
 		return
 	}
+	serveStored(c, store, clip.Filename, downloadName)
+}
 
-	c.FileAttachment(config.GetThumbnailsPath()+clip.Filename+".png", clip.Filename+".png")
+// serveStored sends a stored file, as an attachment named downloadName unless that's empty. Stores that can hand
+// out links to their files are redirected to, so large clips don't have to pass through the server.
+func serveStored(c *gin.Context, store storage.Storage, key string, downloadName string) {
+	if uri, err := store.Presign(key, presignLifetime, downloadName); err == nil {
+		c.Redirect(http.StatusTemporaryRedirect, uri)
+		return
+	}
+	if local, ok := store.(storage.LocalStorage); ok {
+		if downloadName != "" {
+			c.FileAttachment(local.LocalPath(key), downloadName)
+		} else {
+			c.File(local.LocalPath(key))
+		}
+		return
+	}
+
+	info, err := store.Stat(key)
+	if err != nil {
+		c.String(http.StatusNotFound, "file not found")
+		return
+	}
+	reader, err := store.Get(key)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	defer reader.Close()
+	if downloadName != "" {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", downloadName))
+	}
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, key, info.ModTime, seeker)
+		return
+	}
+	c.DataFromReader(http.StatusOK, info.Size, mime.TypeByExtension(path.Ext(key)), reader, nil)
 }
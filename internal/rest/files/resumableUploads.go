@@ -0,0 +1,458 @@
+package files
+
+import (
+	"ClipsArchiver/internal/auth"
+	"ClipsArchiver/internal/config"
+	"ClipsArchiver/internal/db"
+	"ClipsArchiver/internal/ingest"
+	"ClipsArchiver/internal/media"
+	"ClipsArchiver/internal/rest"
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resumable uploads follow the tus 1.0 protocol (https://tus.io/protocols/resumable-upload) with the creation,
+// expiration, checksum and termination extensions. Clients create an upload, then send the file in as many
+// PATCH requests as they need, asking for the offset with HEAD after a dropped connection. Once every byte has
+// arrived the clip is created and transcoded the same way as with UploadClip.
+
+const tusVersion = "1.0.0"
+const tusExtensions = "creation,expiration,checksum,termination"
+const tusChecksumAlgorithms = "md5,sha1,sha256"
+const tusContentType = "application/offset+octet-stream"
+
+// statusChecksumMismatch is the status tus uses for a chunk that doesn't match its Upload-Checksum
+const statusChecksumMismatch = 460
+
+const maxUploadSize = 16 << 30
+
+// uploadLifetime is how long an upload is kept after the last chunk arrived before it counts as abandoned
+const uploadLifetime = 24 * time.Hour
+
+const UploadsPath = "/clips/uploads/"
+
+// uploadLocks stops two requests from writing to the same upload at once, and uploads from being thrown away while
+// a chunk is written
+var uploadLocks sync.Map
+
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// RequireTusResumable rejects requests made with a tus version other than 1.0.0 and marks every response with it
+func RequireTusResumable(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.String(http.StatusPreconditionFailed, "unsupported tus version, should be %s", tusVersion)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// GetUploadOptions tells tus clients what the server supports
+func GetUploadOptions(c *gin.Context) {
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(maxUploadSize, 10))
+	c.Header("Tus-Checksum-Algorithm", tusChecksumAlgorithms)
+	c.Status(http.StatusNoContent)
+}
+
+// CreateUpload starts a resumable upload. The filename, game, timezone and creationDateTime are sent as Upload-Metadata
+// and mean the same as the form values of UploadClip.
+func CreateUpload(c *gin.Context) {
+	if !auth.CanContribute(c) {
+		c.String(http.StatusForbidden, "viewers can't upload clips")
+		return
+	}
+	ownerId, _ := auth.UserId(c)
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		c.String(http.StatusBadRequest, "invalid Upload-Length provided: %s", c.GetHeader("Upload-Length"))
+		return
+	}
+	if length > maxUploadSize {
+		c.String(http.StatusRequestEntityTooLarge, "uploads can be at most %d bytes", maxUploadSize)
+		return
+	}
+	metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid Upload-Metadata provided")
+		return
+	}
+
+	filename := filepath.Base(metadata["filename"])
+	if filename == "" || filename == "." || filename == string(filepath.Separator) {
+		c.String(http.StatusBadRequest, "filename is missing from Upload-Metadata")
+		return
+	}
+	gameSlug := db.GameSlugApexLegends
+	if metadata["game"] != "" {
+		gameSlug = metadata["game"]
+	}
+	game, err := db.GetGameBySlug(gameSlug)
+	if err != nil {
+		c.String(http.StatusBadRequest, "unknown game: %s", gameSlug)
+		return
+	}
+	location, err := rest.ParseTimezone(metadata["timezone"])
+	if err != nil {
+		c.String(http.StatusBadRequest, rest.ErrorTimezoneFormat)
+		return
+	}
+	clipCreatedAt, err := parseCreationDateTime(metadata["creationDateTime"], location)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid creation date provided")
+		return
+	}
+
+	uploadId, err := newUploadId()
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	if err = os.MkdirAll(config.GetPartialUploadsPath(), 0755); err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	file, err := os.Create(partialUploadPath(uploadId))
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	_ = file.Close()
+
+	upload, err := db.CreateUpload(db.Upload{
+		Id:            uploadId,
+		OwnerId:       ownerId,
+		GameId:        game.Id,
+		Filename:      filename,
+		ClipCreatedAt: clipCreatedAt,
+		Timezone:      metadata["timezone"],
+		Length:        length,
+		ExpiresAt:     time.Now().Add(uploadLifetime),
+	})
+	if err != nil {
+		_ = os.Remove(partialUploadPath(uploadId))
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+
+	c.Header("Location", config.GetBaseUri()+UploadsPath+upload.Id)
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+// GetUploadOffset tells a client how much of the upload has arrived, so it knows where to resume
+func GetUploadOffset(c *gin.Context) {
+	upload, ok := getUploadFromPath(c)
+	if !ok {
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusOK)
+}
+
+// PatchUpload appends a chunk at the offset the client says it's at. When the chunk carries an Upload-Checksum
+// it's only kept if it matches, otherwise whatever arrived before the connection dropped is kept. The clip is
+// created once the last byte arrives, and its id is returned in the Clip-Id header. When the file turns out to be
+// a clip already, or isn't a video within the upload limits, the response is the same as UploadClip's.
+func PatchUpload(c *gin.Context) {
+	if c.ContentType() != tusContentType {
+		c.String(http.StatusUnsupportedMediaType, "chunks should be sent as %s", tusContentType)
+		return
+	}
+	upload, ok := getUploadFromPath(c)
+	if !ok {
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid Upload-Offset provided: %s", c.GetHeader("Upload-Offset"))
+		return
+	}
+	var checksum hash.Hash
+	var expectedChecksum []byte
+	if header := c.GetHeader("Upload-Checksum"); header != "" {
+		algorithm, value, _ := strings.Cut(header, " ")
+		newHash, ok := checksumAlgorithms[algorithm]
+		if !ok {
+			c.String(http.StatusBadRequest, "unsupported checksum algorithm, should be one of %s", tusChecksumAlgorithms)
+			return
+		}
+		expectedChecksum, err = base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			c.String(http.StatusBadRequest, "invalid Upload-Checksum provided")
+			return
+		}
+		checksum = newHash()
+	}
+
+	unlock, ok := tryLockUpload(upload.Id)
+	if !ok {
+		c.String(http.StatusLocked, "another chunk of this upload is still being received")
+		return
+	}
+	defer unlock()
+
+	// another chunk may have finished between loading the upload and taking the lock
+	upload, err = db.GetUploadById(upload.Id)
+	if err != nil {
+		c.String(http.StatusNotFound, "no upload found with id: %s", c.Param("uploadId"))
+		return
+	}
+	if offset != upload.Offset {
+		c.String(http.StatusConflict, "upload is at offset %d", upload.Offset)
+		return
+	}
+
+	file, err := os.OpenFile(partialUploadPath(upload.Id), os.O_WRONLY, 0)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	defer file.Close()
+	if _, err = file.Seek(upload.Offset, io.SeekStart); err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+
+	contentHash, err := restoreContentHash(upload.HashState)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	writer := io.MultiWriter(file, contentHash)
+	if checksum != nil {
+		writer = io.MultiWriter(file, contentHash, checksum)
+	}
+	written, copyErr := io.Copy(writer, io.LimitReader(c.Request.Body, upload.Length-upload.Offset))
+
+	if checksum != nil && (copyErr != nil || !bytes.Equal(checksum.Sum(nil), expectedChecksum)) {
+		// a chunk with a checksum is all or nothing
+		_ = file.Truncate(upload.Offset)
+		if copyErr != nil {
+			c.String(http.StatusBadRequest, "chunk was cut off: %s", copyErr.Error())
+			return
+		}
+		c.String(statusChecksumMismatch, "chunk doesn't match its checksum")
+		return
+	}
+
+	newOffset := upload.Offset + written
+	expiresAt := time.Now().Add(uploadLifetime)
+	hashState, err := contentHash.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		_ = file.Truncate(upload.Offset)
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	moved, err := db.SetUploadOffset(upload.Id, upload.Offset, newOffset, hashState, expiresAt)
+	if err != nil || !moved {
+		_ = file.Truncate(upload.Offset)
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	upload.Offset = newOffset
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Expires", expiresAt.UTC().Format(http.TimeFormat))
+	if copyErr != nil {
+		c.String(http.StatusBadRequest, "chunk was cut off: %s", copyErr.Error())
+		return
+	}
+
+	if upload.Offset == upload.Length {
+		_ = file.Close()
+		clip, err := finishUpload(upload, hex.EncodeToString(contentHash.Sum(nil)))
+		if errors.Is(err, db.ErrDuplicateClip) {
+			respondDuplicate(c, clip)
+			return
+		}
+		var validationErr *media.ValidationError
+		if errors.As(err, &validationErr) {
+			c.IndentedJSON(http.StatusUnprocessableEntity, validationErr)
+			return
+		}
+		if err != nil {
+			c.String(http.StatusInternalServerError, rest.ErrorDefault)
+			return
+		}
+		c.Header("Clip-Id", strconv.Itoa(clip.Id))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DeleteUpload abandons an upload and throws away what has arrived so far
+func DeleteUpload(c *gin.Context) {
+	upload, ok := getUploadFromPath(c)
+	if !ok {
+		return
+	}
+	unlock, ok := tryLockUpload(upload.Id)
+	if !ok {
+		c.String(http.StatusLocked, "a chunk of this upload is still being received")
+		return
+	}
+	defer unlock()
+	if err := removeUpload(upload); err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RemoveExpiredUploads throws away uploads that haven't received a chunk within their lifetime
+func RemoveExpiredUploads() {
+	uploads, err := db.GetExpiredUploads()
+	if err != nil {
+		return
+	}
+	for _, upload := range uploads {
+		removeExpiredUpload(upload.Id)
+	}
+}
+
+// removeExpiredUpload throws the upload away unless a chunk is being received, in which case the next run
+// looks at it again. Expiry is checked again under the lock, since a chunk may have just moved it.
+func removeExpiredUpload(uploadId string) {
+	unlock, ok := tryLockUpload(uploadId)
+	if !ok {
+		return
+	}
+	defer unlock()
+	upload, err := db.GetUploadById(uploadId)
+	if err != nil || upload.ExpiresAt.After(time.Now()) {
+		return
+	}
+	_ = removeUpload(upload)
+}
+
+// finishUpload creates the clip for a complete upload. The upload is only thrown away once that's settled, when
+// it failed for another reason and the file is still there the client can try again with an empty chunk.
+func finishUpload(upload db.Upload, contentHash string) (db.Clip, error) {
+	// the timezone was checked when the upload was created
+	location, err := rest.ParseTimezone(upload.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	clip, err := ingest.CreateClip(ingest.NewClip{
+		OwnerId:          upload.OwnerId,
+		GameId:           upload.GameId,
+		ReceivedPath:     partialUploadPath(upload.Id),
+		OriginalFilename: upload.Filename,
+		ContentHash:      contentHash,
+		ClientCreatedAt:  upload.ClipCreatedAt,
+		Location:         location,
+	})
+	var validationErr *media.ValidationError
+	if err != nil && !errors.Is(err, db.ErrDuplicateClip) && !errors.As(err, &validationErr) {
+		if _, statErr := os.Stat(partialUploadPath(upload.Id)); statErr == nil {
+			return clip, err
+		}
+	}
+	_ = db.DeleteUploadById(upload.Id)
+	uploadLocks.Delete(upload.Id)
+	return clip, err
+}
+
+// restoreContentHash picks the sha256 of an upload back up where the last chunk left it
+func restoreContentHash(hashState []byte) (hash.Hash, error) {
+	contentHash := sha256.New()
+	if len(hashState) == 0 {
+		return contentHash, nil
+	}
+	return contentHash, contentHash.(encoding.BinaryUnmarshaler).UnmarshalBinary(hashState)
+}
+
+// tryLockUpload takes the upload's lock unless another request holds it, returning the function that releases it
+func tryLockUpload(uploadId string) (func(), bool) {
+	lock, _ := uploadLocks.LoadOrStore(uploadId, &sync.Mutex{})
+	mutex := lock.(*sync.Mutex)
+	if !mutex.TryLock() {
+		return nil, false
+	}
+	return mutex.Unlock, true
+}
+
+// removeUpload throws away the upload and what has arrived of it, the caller holds its lock. The lock is only
+// forgotten once the row is gone, so requests that come in meanwhile find the upload busy rather than half removed.
+func removeUpload(upload db.Upload) error {
+	err := os.Remove(partialUploadPath(upload.Id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = db.DeleteUploadById(upload.Id)
+	if err == nil {
+		uploadLocks.Delete(upload.Id)
+	}
+	return err
+}
+
+// getUploadFromPath loads the caller's upload named by the :uploadId path parameter, writing an error response
+// if it can't. Other users' uploads are reported as not found.
+func getUploadFromPath(c *gin.Context) (db.Upload, bool) {
+	upload, err := db.GetUploadById(c.Param("uploadId"))
+	if err != nil || !auth.IsUser(c, upload.OwnerId) {
+		c.String(http.StatusNotFound, "no upload found with id: %s", c.Param("uploadId"))
+		return db.Upload{}, false
+	}
+	if !upload.ExpiresAt.After(time.Now()) {
+		c.String(http.StatusGone, "upload %s has expired", upload.Id)
+		return db.Upload{}, false
+	}
+	return upload, true
+}
+
+// parseUploadMetadata decodes the comma separated "key base64value" pairs of an Upload-Metadata header
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata value for %s: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+func partialUploadPath(uploadId string) string {
+	return config.GetPartialUploadsPath() + uploadId
+}
+
+func newUploadId() (string, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(idBytes), nil
+}
@@ -0,0 +1,17 @@
+package games
+
+import (
+	"ClipsArchiver/internal/db"
+	"ClipsArchiver/internal/rest"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func GetAll(c *gin.Context) {
+	games, err := db.GetAllGames()
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, games)
+}
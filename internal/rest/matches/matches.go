@@ -0,0 +1,38 @@
+package matches
+
+import (
+	"ClipsArchiver/internal/auth"
+	"ClipsArchiver/internal/db"
+	"ClipsArchiver/internal/rest"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+func Get(c *gin.Context) {
+	matchGroupId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid match id provided: %s", c.Param("id"))
+		return
+	}
+	matchGroup, err := db.GetMatchGroupById(matchGroupId)
+	if err != nil {
+		c.String(http.StatusNotFound, "no match found with id: %d", matchGroupId)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, matchGroup)
+}
+
+func GetClips(c *gin.Context) {
+	matchGroupId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid match id provided: %s", c.Param("id"))
+		return
+	}
+	clips, err := db.GetClipsForMatchGroup(auth.Viewer(c), matchGroupId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, clips)
+}
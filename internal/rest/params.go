@@ -0,0 +1,100 @@
+package rest
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// ParseDate parses a YYYY-MM-DD path or query value as a UTC date.
+func ParseDate(value string) (time.Time, error) {
+	return time.ParseInLocation(dateLayout, value, time.UTC)
+}
+
+// ParseOptionalDate parses a YYYY-MM-DD query value, returning an invalid NullTime when the value is empty.
+func ParseOptionalDate(value string) (sql.NullTime, error) {
+	if value == "" {
+		return sql.NullTime{}, nil
+	}
+	date, err := ParseDate(value)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	return sql.NullTime{Time: date, Valid: true}, nil
+}
+
+// ParseOptionalGameMode normalises a pubs/ranked query value to the casing used in match_history.
+func ParseOptionalGameMode(value string) (sql.NullString, error) {
+	switch strings.ToLower(value) {
+	case "":
+		return sql.NullString{}, nil
+	case "pubs":
+		return sql.NullString{String: "Pubs", Valid: true}, nil
+	case "ranked":
+		return sql.NullString{String: "Ranked", Valid: true}, nil
+	}
+	return sql.NullString{}, errors.New("invalid game mode")
+}
+
+// ParseIntList parses a comma separated list of ids such as 1,4,7. An empty value gives an empty list.
+func ParseIntList(value string) ([]int, error) {
+	var values []int
+	if value == "" {
+		return values, nil
+	}
+	for _, part := range strings.Split(value, ",") {
+		number, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, number)
+	}
+	return values, nil
+}
+
+// ParseStringList splits a comma separated query value, dropping empty entries
+func ParseStringList(value string) []string {
+	var values []string
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
+
+func ParseOptionalInt(value string) (sql.NullInt32, error) {
+	if value == "" {
+		return sql.NullInt32{}, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return sql.NullInt32{}, err
+	}
+	return sql.NullInt32{Int32: int32(number), Valid: true}, nil
+}
+
+func ParseOptionalBool(value string) (sql.NullBool, error) {
+	if value == "" {
+		return sql.NullBool{}, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return sql.NullBool{}, err
+	}
+	return sql.NullBool{Bool: b, Valid: true}, nil
+}
+
+// ParseTimezone loads an IANA timezone such as Europe/London, falling back to UTC when the value is empty, so
+// times don't depend on where the server runs.
+func ParseTimezone(value string) (*time.Location, error) {
+	if value == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(value)
+}
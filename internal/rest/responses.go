@@ -2,3 +2,7 @@ package rest
 
 const ErrorDefault = "Something went wrong :("
 const ErrorDateFormat = "Invalid date format: Should be YYYY-MM-DD."
+const ErrorGameModeFormat = "Invalid game mode: Should be pubs or ranked."
+const ErrorRoleFormat = "Invalid role: Should be admin, member or viewer."
+const ErrorVisibilityFormat = "Invalid visibility: Should be private, group or public."
+const ErrorTimezoneFormat = "Invalid timezone: Should be an IANA name such as Europe/London."
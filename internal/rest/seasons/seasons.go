@@ -0,0 +1,65 @@
+package seasons
+
+import (
+	"ClipsArchiver/internal/auth"
+	"ClipsArchiver/internal/db"
+	"ClipsArchiver/internal/rest"
+	"database/sql"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const maxNameLength = 32
+
+type seasonRequest struct {
+	Name      string     `json:"name"`
+	StartedAt time.Time  `json:"startedAt"`
+	EndedAt   *time.Time `json:"endedAt"`
+}
+
+func GetAll(c *gin.Context) {
+	seasons, err := db.GetAllSeasons()
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, seasons)
+}
+
+// Create adds a new season, usually when one starts. The season before it is ended when the new one starts.
+func Create(c *gin.Context) {
+	if !auth.IsAdmin(c) {
+		c.String(http.StatusForbidden, "only admins can add seasons")
+		return
+	}
+	var request seasonRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "invalid body for Season")
+		return
+	}
+	season := db.Season{Name: strings.TrimSpace(request.Name), StartedAt: request.StartedAt}
+	if season.Name == "" || len(season.Name) > maxNameLength {
+		c.String(http.StatusBadRequest, "invalid season name: should be between 1 and %d characters", maxNameLength)
+		return
+	}
+	if season.StartedAt.IsZero() {
+		c.String(http.StatusBadRequest, "invalid season: startedAt is required")
+		return
+	}
+	if request.EndedAt != nil {
+		if !request.EndedAt.After(request.StartedAt) {
+			c.String(http.StatusBadRequest, "invalid season: endedAt should be after startedAt")
+			return
+		}
+		season.EndedAt = sql.NullTime{Time: *request.EndedAt, Valid: true}
+	}
+
+	season, err := db.CreateSeason(season)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, season)
+}
@@ -0,0 +1,78 @@
+package sessions
+
+import (
+	"ClipsArchiver/internal/auth"
+	"ClipsArchiver/internal/db"
+	"ClipsArchiver/internal/rest"
+	"database/sql"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+func GetAll(c *gin.Context) {
+	var filter db.SessionFilter
+	if c.Query("userId") != "" {
+		userId, err := strconv.Atoi(c.Query("userId"))
+		if err != nil {
+			c.String(http.StatusBadRequest, "invalid user id provided: %s", c.Query("userId"))
+			return
+		}
+		filter.OwnerId = sql.NullInt32{Int32: int32(userId), Valid: true}
+	}
+
+	var err error
+	filter.From, err = rest.ParseOptionalDate(c.Query("from"))
+	if err != nil {
+		c.String(http.StatusBadRequest, rest.ErrorDateFormat)
+		return
+	}
+	filter.To, err = rest.ParseOptionalDate(c.Query("to"))
+	if err != nil {
+		c.String(http.StatusBadRequest, rest.ErrorDateFormat)
+		return
+	}
+	if filter.To.Valid {
+		filter.To.Time = filter.To.Time.AddDate(0, 0, 1)
+	}
+
+	sessions, err := db.GetSessions(auth.Viewer(c), filter)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, sessions)
+}
+
+func Get(c *gin.Context) {
+	sessionId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid session id provided: %s", c.Param("id"))
+		return
+	}
+	session, err := db.GetSessionById(auth.Viewer(c), sessionId)
+	if err != nil {
+		c.String(http.StatusNotFound, "no session found with id: %d", sessionId)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, session)
+}
+
+func GetClips(c *gin.Context) {
+	sessionId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid session id provided: %s", c.Param("id"))
+		return
+	}
+	session, err := db.GetSessionById(auth.Viewer(c), sessionId)
+	if err != nil {
+		c.String(http.StatusNotFound, "no session found with id: %d", sessionId)
+		return
+	}
+	clips, err := db.GetClipsForSession(auth.Viewer(c), session)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, clips)
+}
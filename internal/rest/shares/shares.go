@@ -0,0 +1,340 @@
+package shares
+
+import (
+	"ClipsArchiver/internal/auth"
+	"ClipsArchiver/internal/config"
+	"ClipsArchiver/internal/db"
+	"ClipsArchiver/internal/rest"
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"html"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const providerName = "Clips Archiver"
+const playerWidth = 1280
+const playerHeight = 720
+
+// unfurlBots fetch share pages to build link previews. Their visits don't count as views, so they only get the
+// page's metadata and never a url for the video itself.
+var unfurlBots = []string{"Discordbot", "Twitterbot", "facebookexternalhit", "Slackbot", "TelegramBot", "WhatsApp", "LinkedInBot", "Embedly"}
+
+type shareRequest struct {
+	ExpiresInHours int `json:"expiresInHours"`
+	MaxViews       int `json:"maxViews"`
+}
+
+type pageData struct {
+	Title        string
+	Description  string
+	PageUri      string
+	EmbedUri     string
+	OEmbedUri    string
+	VideoUri     string
+	ThumbnailUri string
+	Duration     int
+	Width        int
+	Height       int
+	IsEmbed      bool
+}
+
+type oEmbedResponse struct {
+	Version         string `json:"version"`
+	Type            string `json:"type"`
+	ProviderName    string `json:"provider_name"`
+	ProviderUrl     string `json:"provider_url"`
+	Title           string `json:"title"`
+	ThumbnailUrl    string `json:"thumbnail_url"`
+	ThumbnailWidth  int    `json:"thumbnail_width"`
+	ThumbnailHeight int    `json:"thumbnail_height"`
+	Width           int    `json:"width"`
+	Height          int    `json:"height"`
+	Html            string `json:"html"`
+}
+
+var pageTemplate = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<meta property="og:site_name" content="Clips Archiver">
+<meta property="og:type" content="video.other">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Description}}">
+<meta property="og:url" content="{{.PageUri}}">
+<meta property="og:image" content="{{.ThumbnailUri}}">
+{{if .VideoUri}}<meta property="og:video" content="{{.VideoUri}}">
+<meta property="og:video:type" content="video/mp4">
+<meta property="og:video:width" content="{{.Width}}">
+<meta property="og:video:height" content="{{.Height}}">
+{{end}}<meta property="video:duration" content="{{.Duration}}">
+<meta name="twitter:card" content="player">
+<meta name="twitter:title" content="{{.Title}}">
+<meta name="twitter:description" content="{{.Description}}">
+<meta name="twitter:image" content="{{.ThumbnailUri}}">
+<meta name="twitter:player" content="{{.EmbedUri}}">
+<meta name="twitter:player:width" content="{{.Width}}">
+<meta name="twitter:player:height" content="{{.Height}}">
+{{if .VideoUri}}<meta name="twitter:player:stream" content="{{.VideoUri}}">
+{{end}}<link rel="alternate" type="application/json+oembed" href="{{.OEmbedUri}}" title="{{.Title}}">
+<style>
+html, body { margin: 0; height: 100%; background: #111; color: #eee; font-family: sans-serif; }
+main { max-width: 1280px; margin: 0 auto; padding: {{if .IsEmbed}}0{{else}}24px{{end}}; }
+video { width: 100%; max-height: {{if .IsEmbed}}100vh{{else}}80vh{{end}}; background: #000; }
+h1 { font-size: 1.4em; margin: 16px 0 8px; }
+p { color: #aaa; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<main>
+{{if .VideoUri}}<video src="{{.VideoUri}}" poster="{{.ThumbnailUri}}" controls playsinline preload="metadata"></video>
+{{end}}{{if not .IsEmbed}}<h1>{{.Title}}</h1>
+<p>{{.Description}}</p>{{end}}
+</main>
+</body>
+</html>
+`))
+
+func GetForClip(c *gin.Context) {
+	clip, ok := getModifiableClip(c)
+	if !ok {
+		return
+	}
+	shares, err := db.GetSharesForClip(clip.Id)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, shares)
+}
+
+// Create makes a share link for the clip. Expiry and view limit are optional, 0 means no limit.
+func Create(c *gin.Context) {
+	clip, ok := getModifiableClip(c)
+	if !ok {
+		return
+	}
+	var request shareRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "invalid body for share")
+		return
+	}
+	if request.ExpiresInHours < 0 || request.MaxViews < 0 {
+		c.String(http.StatusBadRequest, "invalid share limits: expiry and view limit should be positive, or 0 for no limit")
+		return
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	share := db.Share{ClipId: clip.Id, Token: token}
+	share.CreatedBy, _ = auth.UserId(c)
+	if request.ExpiresInHours > 0 {
+		share.ExpiresAt = sql.NullTime{Time: time.Now().Add(time.Duration(request.ExpiresInHours) * time.Hour), Valid: true}
+	}
+	if request.MaxViews > 0 {
+		share.MaxViews = sql.NullInt32{Int32: int32(request.MaxViews), Valid: true}
+	}
+
+	share, err = db.CreateShare(share)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, share)
+}
+
+// Delete revokes a share link. The person who shared it, the clip's owner and admins can revoke it.
+func Delete(c *gin.Context) {
+	shareId, err := strconv.Atoi(c.Param("shareId"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid share id provided: %s", c.Param("shareId"))
+		return
+	}
+	share, err := db.GetShareById(shareId)
+	if err != nil {
+		c.String(http.StatusNotFound, "no share found with id: %d", shareId)
+		return
+	}
+	clip, err := db.GetClipById(db.SystemViewer, share.ClipId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	if !auth.IsUser(c, share.CreatedBy) && !auth.CanModify(c, clip.OwnerId) {
+		c.String(http.StatusForbidden, "only the person who shared a clip, its owner or an admin can revoke the link")
+		return
+	}
+
+	err = db.DeleteShareById(share.Id)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.String(http.StatusNoContent, "Deleted share")
+}
+
+// Page serves the player page for a share link, counting a view. ?embed=1 leaves out everything but the player.
+func Page(c *gin.Context) {
+	share, err := db.GetShareByToken(c.Param("token"))
+	if err != nil {
+		c.String(http.StatusNotFound, "this link doesn't exist")
+		return
+	}
+	isBot := isUnfurlBot(c.Request.UserAgent())
+	if isBot {
+		if !share.IsUsable() {
+			c.String(http.StatusGone, "this link has expired")
+			return
+		}
+	} else {
+		used, err := db.UseShare(share.Id)
+		if err != nil {
+			c.String(http.StatusInternalServerError, rest.ErrorDefault)
+			return
+		}
+		if !used {
+			c.String(http.StatusGone, "this link has expired")
+			return
+		}
+	}
+
+	// share links show the clip whatever its visibility
+	clip, err := db.GetClipById(db.ShareViewer(share), share.ClipId)
+	if err != nil {
+		c.String(http.StatusNotFound, "this link doesn't exist")
+		return
+	}
+	if isBot {
+		clip.VideoUri = ""
+	}
+
+	data := pageData{
+		Title:        clipTitle(clip),
+		Description:  clip.Description,
+		PageUri:      share.Uri,
+		EmbedUri:     share.Uri + "?embed=1",
+		OEmbedUri:    config.GetPublicBaseUri() + "/oembed?format=json&url=" + url.QueryEscape(share.Uri),
+		VideoUri:     clip.VideoUri,
+		ThumbnailUri: clip.ThumbnailUri,
+		Duration:     clip.Duration,
+		Width:        playerWidth,
+		Height:       playerHeight,
+		IsEmbed:      c.Query("embed") == "1",
+	}
+	var page bytes.Buffer
+	if err = pageTemplate.Execute(&page, data); err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+}
+
+// OEmbed describes a share link for sites that embed through oEmbed. Only json is supported.
+func OEmbed(c *gin.Context) {
+	if format := c.Query("format"); format != "" && format != "json" {
+		c.String(http.StatusNotImplemented, "only json is supported")
+		return
+	}
+	shareUri, err := url.Parse(c.Query("url"))
+	if err != nil || !strings.HasPrefix(shareUri.Path, db.SharePath) {
+		c.String(http.StatusNotFound, "not a share link: %s", c.Query("url"))
+		return
+	}
+	share, err := db.GetShareByToken(strings.TrimPrefix(shareUri.Path, db.SharePath))
+	if err != nil || !share.IsUsable() {
+		c.String(http.StatusNotFound, "not a share link: %s", c.Query("url"))
+		return
+	}
+	clip, err := db.GetClipById(db.ShareViewer(share), share.ClipId)
+	if err != nil {
+		c.String(http.StatusNotFound, "not a share link: %s", c.Query("url"))
+		return
+	}
+
+	width, height := fitPlayer(c.Query("maxwidth"), c.Query("maxheight"))
+	iframe := fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" frameborder="0" allowfullscreen></iframe>`, html.EscapeString(share.Uri+"?embed=1"), width, height)
+	c.IndentedJSON(http.StatusOK, oEmbedResponse{
+		Version:         "1.0",
+		Type:            "video",
+		ProviderName:    providerName,
+		ProviderUrl:     config.GetPublicBaseUri(),
+		Title:           clipTitle(clip),
+		ThumbnailUrl:    clip.ThumbnailUri,
+		ThumbnailWidth:  playerWidth,
+		ThumbnailHeight: playerHeight,
+		Width:           width,
+		Height:          height,
+		Html:            iframe,
+	})
+}
+
+// fitPlayer scales the default player size down to fit the consumer's limits, keeping the aspect ratio
+func fitPlayer(maxWidth string, maxHeight string) (int, int) {
+	width, height := playerWidth, playerHeight
+	if limit, err := strconv.Atoi(maxWidth); err == nil && limit > 0 && limit < width {
+		width, height = limit, limit*playerHeight/playerWidth
+	}
+	if limit, err := strconv.Atoi(maxHeight); err == nil && limit > 0 && limit < height {
+		width, height = limit*playerWidth/playerHeight, limit
+	}
+	return width, height
+}
+
+func clipTitle(clip db.Clip) string {
+	if clip.Title != "" {
+		return clip.Title
+	}
+	if clip.OriginalFilename != "" {
+		return clip.OriginalFilename
+	}
+	return clip.Filename
+}
+
+func isUnfurlBot(userAgent string) bool {
+	for _, bot := range unfurlBots {
+		if strings.Contains(userAgent, bot) {
+			return true
+		}
+	}
+	return false
+}
+
+func newShareToken() (string, error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(tokenBytes), nil
+}
+
+// getModifiableClip loads the clip named by the :clipId path parameter if the caller can manage its shares
+func getModifiableClip(c *gin.Context) (db.Clip, bool) {
+	clipId, err := strconv.Atoi(c.Param("clipId"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid clip id provided: %s", c.Param("clipId"))
+		return db.Clip{}, false
+	}
+	clip, err := db.GetClipById(auth.Viewer(c), clipId)
+	if err != nil {
+		c.String(http.StatusNotFound, "no clip found with id: %d", clipId)
+		return db.Clip{}, false
+	}
+	if !auth.CanModify(c, clip.OwnerId) {
+		c.String(http.StatusForbidden, "only the owner of a clip or an admin can share it")
+		return db.Clip{}, false
+	}
+	return clip, true
+}
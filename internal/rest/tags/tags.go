@@ -1,12 +1,26 @@
 package tags
 
 import (
+	"ClipsArchiver/internal/auth"
 	"ClipsArchiver/internal/db"
 	"ClipsArchiver/internal/rest"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
+const maxNameLength = 32
+const maxCategoryLength = 32
+
+var colorPattern = regexp.MustCompile("^#[0-9a-fA-F]{6}$")
+
+type mergeRequest struct {
+	TargetId int `json:"targetId"`
+}
+
 func GetAll(c *gin.Context) {
 	tags, err := db.GetAllTags()
 	if err != nil {
@@ -15,3 +29,169 @@ func GetAll(c *gin.Context) {
 	}
 	c.IndentedJSON(http.StatusOK, tags)
 }
+
+func Get(c *gin.Context) {
+	tagId, err := strconv.Atoi(c.Param("tagId"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid tag id provided: %s", c.Param("tagId"))
+		return
+	}
+	tag, err := db.GetTagById(tagId)
+	if err != nil {
+		c.String(http.StatusNotFound, "no tag found with id: %d", tagId)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, tag)
+}
+
+func Create(c *gin.Context) {
+	if !auth.CanContribute(c) {
+		c.String(http.StatusForbidden, "viewers can't change tags")
+		return
+	}
+	var tag db.Tag
+	if err := c.BindJSON(&tag); err != nil {
+		c.String(http.StatusBadRequest, "invalid body for Tag")
+		return
+	}
+	if !validateTag(c, &tag) {
+		return
+	}
+
+	tag, err := db.CreateTag(tag)
+	if errors.Is(err, db.ErrTagExists) {
+		c.String(http.StatusConflict, "a tag named %s already exists", tag.Name)
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, tag)
+}
+
+func Update(c *gin.Context) {
+	if !auth.CanContribute(c) {
+		c.String(http.StatusForbidden, "viewers can't change tags")
+		return
+	}
+	tagId, err := strconv.Atoi(c.Param("tagId"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid tag id provided: %s", c.Param("tagId"))
+		return
+	}
+	var tag db.Tag
+	if err = c.BindJSON(&tag); err != nil {
+		c.String(http.StatusBadRequest, "invalid body for Tag")
+		return
+	}
+	tag.Id = tagId
+	if !validateTag(c, &tag) {
+		return
+	}
+
+	if _, err = db.GetTagById(tagId); err != nil {
+		c.String(http.StatusNotFound, "no tag found with id: %d", tagId)
+		return
+	}
+	err = db.UpdateTag(tag)
+	if errors.Is(err, db.ErrTagExists) {
+		c.String(http.StatusConflict, "a tag named %s already exists, merge into it instead", tag.Name)
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+
+	tag, err = db.GetTagById(tagId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, tag)
+}
+
+func Delete(c *gin.Context) {
+	if !auth.CanContribute(c) {
+		c.String(http.StatusForbidden, "viewers can't change tags")
+		return
+	}
+	tagId, err := strconv.Atoi(c.Param("tagId"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid tag id provided: %s", c.Param("tagId"))
+		return
+	}
+	if _, err = db.GetTagById(tagId); err != nil {
+		c.String(http.StatusNotFound, "no tag found with id: %d", tagId)
+		return
+	}
+	err = db.DeleteTagById(tagId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.String(http.StatusNoContent, "Deleted tag")
+}
+
+// Merge moves every clip from the tag in the path onto the target tag in the body and deletes the path tag
+func Merge(c *gin.Context) {
+	if !auth.CanContribute(c) {
+		c.String(http.StatusForbidden, "viewers can't change tags")
+		return
+	}
+	sourceId, err := strconv.Atoi(c.Param("tagId"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid tag id provided: %s", c.Param("tagId"))
+		return
+	}
+	var request mergeRequest
+	if err = c.BindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "invalid body for merge request")
+		return
+	}
+	if request.TargetId == sourceId {
+		c.String(http.StatusBadRequest, "can't merge a tag into itself")
+		return
+	}
+	if _, err = db.GetTagById(sourceId); err != nil {
+		c.String(http.StatusNotFound, "no tag found with id: %d", sourceId)
+		return
+	}
+	if _, err = db.GetTagById(request.TargetId); err != nil {
+		c.String(http.StatusNotFound, "no tag found with id: %d", request.TargetId)
+		return
+	}
+
+	err = db.MergeTags(sourceId, request.TargetId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+
+	target, err := db.GetTagById(request.TargetId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, target)
+}
+
+// validateTag tidies up the tag's fields and writes a 400 response if any of them are unusable
+func validateTag(c *gin.Context, tag *db.Tag) bool {
+	tag.Name = strings.TrimSpace(tag.Name)
+	if tag.Name == "" || len(tag.Name) > maxNameLength {
+		c.String(http.StatusBadRequest, "invalid tag name: should be between 1 and %d characters", maxNameLength)
+		return false
+	}
+	if tag.Color.Valid && !colorPattern.MatchString(tag.Color.String) {
+		c.String(http.StatusBadRequest, "invalid tag color: should be formatted as #RRGGBB")
+		return false
+	}
+	tag.Category.String = strings.TrimSpace(tag.Category.String)
+	if tag.Category.Valid && len(tag.Category.String) > maxCategoryLength {
+		c.String(http.StatusBadRequest, "invalid tag category: should be at most %d characters", maxCategoryLength)
+		return false
+	}
+	return true
+}
@@ -1,6 +1,7 @@
 package transcodeRequests
 
 import (
+	"ClipsArchiver/internal/auth"
 	"ClipsArchiver/internal/db"
 	"ClipsArchiver/internal/rest"
 	"github.com/gin-gonic/gin"
@@ -9,7 +10,7 @@ import (
 )
 
 func GetAll(c *gin.Context) {
-	queueEntries, err := db.GetAllTranscodeRequests()
+	queueEntries, err := db.GetAllTranscodeRequests(auth.Viewer(c))
 	if err != nil {
 		c.String(http.StatusInternalServerError, rest.ErrorDefault)
 		return
@@ -25,7 +26,7 @@ func GetById(c *gin.Context) {
 		return
 	}
 
-	queueEntry, err := db.GetTranscodeRequestByClipId(clipId)
+	queueEntry, err := db.GetTranscodeRequestByClipId(auth.Viewer(c), clipId)
 
 	if err != nil {
 		println(err.Error())
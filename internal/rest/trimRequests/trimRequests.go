@@ -1,6 +1,7 @@
 package trimRequests
 
 import (
+	"ClipsArchiver/internal/auth"
 	"ClipsArchiver/internal/db"
 	"ClipsArchiver/internal/rest"
 	"net/http"
@@ -10,7 +11,7 @@ import (
 )
 
 func GetAll(c *gin.Context) {
-	queueEntries, err := db.GetAllTrimRequests()
+	queueEntries, err := db.GetAllTrimRequests(auth.Viewer(c))
 	if err != nil {
 		c.String(http.StatusInternalServerError, rest.ErrorDefault)
 		return
@@ -26,7 +27,7 @@ func GetByClipId(c *gin.Context) {
 		return
 	}
 
-	queueEntry, err := db.GetTrimRequestByClipId(clipId)
+	queueEntry, err := db.GetTrimRequestByClipId(auth.Viewer(c), clipId)
 
 	if err != nil {
 		println(err.Error())
@@ -38,13 +39,33 @@ func GetByClipId(c *gin.Context) {
 }
 
 func Create(c *gin.Context) {
+	if !auth.CanContribute(c) {
+		c.String(http.StatusForbidden, "viewers can't trim clips")
+		return
+	}
+	clipId, err := strconv.Atoi(c.Param("clipId"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid clip id provided: %s", c.Param("clipId"))
+		return
+	}
+	clip, err := db.GetClipById(auth.Viewer(c), clipId)
+	if err != nil {
+		c.String(http.StatusNotFound, "no clip found with id: %d", clipId)
+		return
+	}
+	if !auth.CanModify(c, clip.OwnerId) {
+		c.String(http.StatusForbidden, "only the owner of a clip or an admin can trim it")
+		return
+	}
+
 	var queueEntry db.TrimRequest
-	if err := c.BindJSON(&queueEntry); err != nil {
+	if err = c.BindJSON(&queueEntry); err != nil {
 		c.String(http.StatusBadRequest, "invalid request body")
 		return
 	}
+	queueEntry.ClipId = clip.Id
 
-	err := db.CreateTrimRequest(queueEntry)
+	err = db.CreateTrimRequest(queueEntry)
 	if err != nil {
 		c.String(http.StatusInternalServerError, rest.ErrorDefault)
 		return
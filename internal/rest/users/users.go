@@ -1,12 +1,31 @@
 package users
 
 import (
+	"ClipsArchiver/internal/auth"
 	"ClipsArchiver/internal/db"
 	"ClipsArchiver/internal/rest"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
+const maxAccountFieldLength = 64
+
+type visibilityRequest struct {
+	Visibility string `json:"visibility"`
+}
+
+type gameAccountRequest struct {
+	AccountName string `json:"accountName"`
+	AccountUid  string `json:"accountUid"`
+}
+
+type roleRequest struct {
+	Role string `json:"role"`
+}
+
 func GetAll(c *gin.Context) {
 	users, err := db.GetAllUsers()
 	if err != nil {
@@ -15,3 +34,203 @@ func GetAll(c *gin.Context) {
 	}
 	c.IndentedJSON(http.StatusOK, users)
 }
+
+func GetStats(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid user id provided: %s", c.Param("id"))
+		return
+	}
+
+	var filter db.StatsFilter
+	filter.From, err = rest.ParseOptionalDate(c.Query("from"))
+	if err != nil {
+		c.String(http.StatusBadRequest, rest.ErrorDateFormat)
+		return
+	}
+	filter.To, err = rest.ParseOptionalDate(c.Query("to"))
+	if err != nil {
+		c.String(http.StatusBadRequest, rest.ErrorDateFormat)
+		return
+	}
+	if filter.To.Valid {
+		// to is inclusive for the caller, the query compares against the start of the next day
+		filter.To.Time = filter.To.Time.AddDate(0, 0, 1)
+	}
+	filter.GameMode, err = rest.ParseOptionalGameMode(c.Query("mode"))
+	if err != nil {
+		c.String(http.StatusBadRequest, rest.ErrorGameModeFormat)
+		return
+	}
+
+	stats, err := db.GetUserStats(auth.Viewer(c), userId, filter)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, stats)
+}
+
+func GetRankedProgression(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid user id provided: %s", c.Param("id"))
+		return
+	}
+
+	progression, err := db.GetRankedProgressionForUser(auth.Viewer(c), userId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, progression)
+}
+
+func GetGameAccounts(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid user id provided: %s", c.Param("id"))
+		return
+	}
+
+	gameAccounts, err := db.GetGameAccountsForUser(userId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, gameAccounts)
+}
+
+// SetGameAccount links the user to their account in a game, so their clips can be matched to its match history.
+// Users can set their own, admins anyone's.
+func SetGameAccount(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid user id provided: %s", c.Param("id"))
+		return
+	}
+	if !auth.IsUser(c, userId) && !auth.IsAdmin(c) {
+		c.String(http.StatusForbidden, "only the user or an admin can change their game accounts")
+		return
+	}
+	if _, err = db.GetUserById(userId); err != nil {
+		c.String(http.StatusNotFound, "no user found with id: %d", userId)
+		return
+	}
+	gameId, err := strconv.Atoi(c.Param("gameId"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid game id provided: %s", c.Param("gameId"))
+		return
+	}
+	if _, err = db.GetGameById(gameId); err != nil {
+		c.String(http.StatusNotFound, "no game found with id: %d", gameId)
+		return
+	}
+	var request gameAccountRequest
+	if err = c.BindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "invalid body for game account")
+		return
+	}
+	gameAccount := db.GameAccount{UserId: userId, GameId: gameId, AccountName: strings.TrimSpace(request.AccountName), AccountUid: strings.TrimSpace(request.AccountUid)}
+	if gameAccount.AccountUid == "" || len(gameAccount.AccountUid) > maxAccountFieldLength || len(gameAccount.AccountName) > maxAccountFieldLength {
+		c.String(http.StatusBadRequest, "invalid game account: accountUid is required and both fields should be at most %d characters", maxAccountFieldLength)
+		return
+	}
+
+	err = db.SetGameAccount(gameAccount)
+	if errors.Is(err, db.ErrGameAccountTaken) {
+		c.String(http.StatusConflict, "account %s is already linked to another user", gameAccount.AccountUid)
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, gameAccount)
+}
+
+// SetRole changes what a user is allowed to do. Only admins can change roles, and they can't demote themselves
+// so there's always an admin left.
+func SetRole(c *gin.Context) {
+	if !auth.IsAdmin(c) {
+		c.String(http.StatusForbidden, "only admins can change roles")
+		return
+	}
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid user id provided: %s", c.Param("id"))
+		return
+	}
+	var request roleRequest
+	if err = c.BindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "invalid body for role")
+		return
+	}
+	if !db.IsValidRole(request.Role) {
+		c.String(http.StatusBadRequest, rest.ErrorRoleFormat)
+		return
+	}
+	if auth.IsUser(c, userId) && request.Role != db.RoleAdmin {
+		c.String(http.StatusForbidden, "admins can't remove their own admin role")
+		return
+	}
+	if _, err = db.GetUserById(userId); err != nil {
+		c.String(http.StatusNotFound, "no user found with id: %d", userId)
+		return
+	}
+
+	err = db.SetUserRole(userId, request.Role)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.String(http.StatusNoContent, "Updated role")
+}
+
+// SetDefaultVisibility changes the visibility the user's new clips get. Users can change their own, admins anyone's.
+func SetDefaultVisibility(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid user id provided: %s", c.Param("id"))
+		return
+	}
+	if !auth.IsUser(c, userId) && !auth.IsAdmin(c) {
+		c.String(http.StatusForbidden, "only the user or an admin can change their default visibility")
+		return
+	}
+	if _, err = db.GetUserById(userId); err != nil {
+		c.String(http.StatusNotFound, "no user found with id: %d", userId)
+		return
+	}
+	var request visibilityRequest
+	if err = c.BindJSON(&request); err != nil {
+		c.String(http.StatusBadRequest, "invalid body for visibility")
+		return
+	}
+	if !db.IsValidVisibility(request.Visibility) {
+		c.String(http.StatusBadRequest, rest.ErrorVisibilityFormat)
+		return
+	}
+
+	err = db.SetDefaultVisibility(userId, request.Visibility)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.String(http.StatusNoContent, "Updated default visibility")
+}
+
+func GetFavorites(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid user id provided: %s", c.Param("id"))
+		return
+	}
+
+	clips, err := db.GetFavoriteClipsForUser(auth.Viewer(c), userId)
+	if err != nil {
+		c.String(http.StatusInternalServerError, rest.ErrorDefault)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, clips)
+}
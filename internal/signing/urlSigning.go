@@ -0,0 +1,72 @@
+package signing
+
+import (
+	"ClipsArchiver/internal/config"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const ExpiresParam = "expires"
+const SignatureParam = "signature"
+const GrantParam = "grant"
+
+// urlLifetime is how long a signed url stays valid at least. Expiry is rounded up to the next hour so the same
+// file gets the same url for a while, which keeps client and browser caches useful.
+const urlLifetime = 6 * time.Hour
+
+var secret []byte
+var secretOnce sync.Once
+
+// signingSecret uses the configured secret, or a random one when none is set. Urls signed with a random secret
+// stop working when the server restarts.
+func signingSecret() []byte {
+	secretOnce.Do(func() {
+		if configured := config.GetUrlSigningSecret(); configured != "" {
+			secret = []byte(configured)
+			return
+		}
+		secret = make([]byte, 32)
+		_, _ = rand.Read(secret)
+	})
+	return secret
+}
+
+func signature(path string, expires int64, grant string) string {
+	mac := hmac.New(sha256.New, signingSecret())
+	mac.Write([]byte(path + "\n" + strconv.FormatInt(expires, 10) + "\n" + grant))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignPath returns the path with an expiry, the grant and a signature over all three appended as query parameters.
+// path is the unescaped path, the way the server sees it after decoding the request. grant records who the url
+// was handed out to and is left out when empty.
+func SignPath(path string, grant string) string {
+	return SignPathUntil(path, grant, time.Now().Add(urlLifetime).Truncate(time.Hour).Add(time.Hour))
+}
+
+// SignPathUntil is SignPath for urls that have to stop working at a set time
+func SignPathUntil(path string, grant string, expiresAt time.Time) string {
+	expires := expiresAt.Unix()
+	query := url.Values{}
+	query.Set(ExpiresParam, strconv.FormatInt(expires, 10))
+	if grant != "" {
+		query.Set(GrantParam, grant)
+	}
+	query.Set(SignatureParam, signature(path, expires, grant))
+	return (&url.URL{Path: path}).EscapedPath() + "?" + query.Encode()
+}
+
+// VerifyPath checks that the signature was made for the path, expiry and grant and that the expiry hasn't passed
+func VerifyPath(path string, expires string, grant string, signatureValue string) bool {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() >= expiresAt {
+		return false
+	}
+	return hmac.Equal([]byte(signatureValue), []byte(signature(path, expiresAt, grant)))
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type localStorage struct {
+	root string
+}
+
+// NewLocal keeps files in a folder on the local filesystem
+func NewLocal(root string) LocalStorage {
+	return &localStorage{root: root}
+}
+
+// LocalPath maps a key into the root folder, cleaning it first so keys can't reach outside of it
+func (s *localStorage) LocalPath(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+// Put writes to a temporary file next to the destination first, so a file is never seen half written
+func (s *localStorage) Put(key string, src io.Reader) error {
+	destination := s.LocalPath(key)
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(destination), ".put-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, src)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), destination)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+	}
+	return err
+}
+
+func (s *localStorage) Get(key string) (io.ReadCloser, error) {
+	return os.Open(s.LocalPath(key))
+}
+
+func (s *localStorage) Stat(key string) (ObjectInfo, error) {
+	info, err := os.Stat(s.LocalPath(key))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if info.IsDir() {
+		return ObjectInfo{}, fs.ErrNotExist
+	}
+	return ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *localStorage) Delete(key string) error {
+	return os.Remove(s.LocalPath(key))
+}
+
+// List skips hidden files, which are files still being written
+func (s *localStorage) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(s.root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if filePath == s.root && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() || !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+		relativePath, err := filepath.Rel(s.root, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relativePath)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+func (s *localStorage) Presign(string, time.Duration, string) (string, error) {
+	return "", ErrPresignUnsupported
+}
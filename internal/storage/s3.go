@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"ClipsArchiver/internal/config"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+const defaultS3Region = "us-east-1"
+
+type s3Storage struct {
+	client    *s3.Client
+	uploader  *manager.Uploader
+	presigner *s3.PresignClient
+	bucket    string
+	prefix    string
+}
+
+// NewS3 keeps files in an S3 compatible bucket, under keys starting with prefix
+func NewS3(bucket config.S3Config, prefix string) (Storage, error) {
+	if bucket.Bucket == "" {
+		return nil, errors.New("no s3 bucket configured")
+	}
+	region := defaultS3Region
+	if bucket.Region != "" {
+		region = bucket.Region
+	}
+	options := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(region)}
+	// without keys the usual environment variables, shared config and instance roles are used
+	if bucket.AccessKeyId != "" {
+		options = append(options, awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(bucket.AccessKeyId, bucket.SecretAccessKey, "")))
+	}
+	awsConfig, err := awsconfig.LoadDefaultConfig(context.Background(), options...)
+	if err != nil {
+		return nil, err
+	}
+	client := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		o.UsePathStyle = bucket.UsePathStyle
+		if bucket.Endpoint != "" {
+			o.BaseEndpoint = aws.String(bucket.Endpoint)
+			// not every S3 compatible service understands the checksums AWS asks for by default
+			o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+			o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+		}
+	})
+	return &s3Storage{
+		client:    client,
+		uploader:  manager.NewUploader(client),
+		presigner: s3.NewPresignClient(client),
+		bucket:    bucket.Bucket,
+		prefix:    prefix,
+	}, nil
+}
+
+// Put uploads in parts, so clips of any size can be stored without knowing their size up front
+func (s *s3Storage) Put(key string, src io.Reader) error {
+	_, err := s.uploader.Upload(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+		Body:   src,
+	})
+	return s.wrapError(key, err)
+}
+
+func (s *s3Storage) Get(key string) (io.ReadCloser, error) {
+	output, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	if err != nil {
+		return nil, s.wrapError(key, err)
+	}
+	return output.Body, nil
+}
+
+func (s *s3Storage) Stat(key string) (ObjectInfo, error) {
+	output, err := s.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	if err != nil {
+		return ObjectInfo{}, s.wrapError(key, err)
+	}
+	return ObjectInfo{Key: key, Size: aws.ToInt64(output.ContentLength), ModTime: aws.ToTime(output.LastModified)}, nil
+}
+
+func (s *s3Storage) Delete(key string) error {
+	_, err := s.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	return s.wrapError(key, err)
+}
+
+func (s *s3Storage) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	pages := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix + prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(context.Background())
+		if err != nil {
+			return objects, s.wrapError(prefix, err)
+		}
+		for _, object := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:     strings.TrimPrefix(aws.ToString(object.Key), s.prefix),
+				Size:    aws.ToInt64(object.Size),
+				ModTime: aws.ToTime(object.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+func (s *s3Storage) Presign(key string, expires time.Duration, downloadName string) (string, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	}
+	if downloadName != "" {
+		input.ResponseContentDisposition = aws.String(fmt.Sprintf("attachment; filename=%q", downloadName))
+	}
+	request, err := s.presigner.PresignGetObject(context.Background(), input, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", s.wrapError(key, err)
+	}
+	return request.URL, nil
+}
+
+// wrapError makes missing objects match fs.ErrNotExist, like they do for the local store
+func (s *s3Storage) wrapError(key string, err error) error {
+	if err == nil {
+		return nil
+	}
+	// HeadObject has no body to carry an error code, so it only says NotFound
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NoSuchKey" || apiErr.ErrorCode() == "NotFound") {
+		return fmt.Errorf("%s%s: %w", s.prefix, key, fs.ErrNotExist)
+	}
+	return fmt.Errorf("%s%s: %w", s.prefix, key, err)
+}
@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"ClipsArchiver/internal/config"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrPresignUnsupported is returned by stores that can't hand out links to their files, which have to be served
+// by the server instead
+var ErrPresignUnsupported = errors.New("presigned urls aren't supported by this store")
+
+// Storage keeps files under slash separated keys. Missing files are reported with errors matching fs.ErrNotExist.
+type Storage interface {
+	Put(key string, src io.Reader) error
+	// Get opens a file, which also implements io.Seeker when the store can serve ranges of it
+	Get(key string) (io.ReadCloser, error)
+	Stat(key string) (ObjectInfo, error)
+	Delete(key string) error
+	List(prefix string) ([]ObjectInfo, error)
+	// Presign returns a url anyone can download the file from until it expires, as an attachment named
+	// downloadName unless that's empty
+	Presign(key string, expires time.Duration, downloadName string) (string, error)
+}
+
+// LocalStorage is implemented by stores on the local filesystem, whose files can be used in place
+type LocalStorage interface {
+	Storage
+	LocalPath(key string) string
+}
+
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Names of the stores, which are folders of the local store and key prefixes of a bucket
+const (
+	uploadsName    = "Uploads"
+	clipsName      = "Clips"
+	thumbnailsName = "Thumbnails"
+)
+
+var (
+	storesOnce sync.Once
+	uploads    Storage
+	clips      Storage
+	hotClips   Storage
+	thumbnails Storage
+)
+
+// Uploads holds uploaded files waiting to be transcoded, keyed by clip filename
+func Uploads() Storage {
+	storesOnce.Do(openStores)
+	return uploads
+}
+
+// Clips holds transcoded clips, keyed by clip filename. With tiering enabled this is the cold tier.
+func Clips() Storage {
+	storesOnce.Do(openStores)
+	return clips
+}
+
+// HotClips is the hot tier of transcoded clips, which is always on the local disk
+func HotClips() Storage {
+	storesOnce.Do(openStores)
+	return hotClips
+}
+
+// Thumbnails holds clip thumbnails, keyed by clip filename with .png appended
+func Thumbnails() Storage {
+	storesOnce.Do(openStores)
+	return thumbnails
+}
+
+func openStores() {
+	hotClips = NewLocal(config.GetHotClipsPath())
+	backend, bucket := config.GetStorageBackend()
+	switch backend {
+	case config.StorageBackendLocal:
+		uploads = NewLocal(config.GetInputPath())
+		clips = NewLocal(config.GetOutputPath())
+		thumbnails = NewLocal(config.GetThumbnailsPath())
+	case config.StorageBackendS3:
+		var err error
+		if uploads, err = NewS3(bucket, uploadsName+"/"); err == nil {
+			if clips, err = NewS3(bucket, clipsName+"/"); err == nil {
+				thumbnails, err = NewS3(bucket, thumbnailsName+"/")
+			}
+		}
+		if err != nil {
+			log.Fatalf("Failed to open storage: %s", err.Error())
+		}
+	default:
+		log.Fatalf("Unknown storage backend %s, should be %s or %s", backend, config.StorageBackendLocal, config.StorageBackendS3)
+	}
+}
+
+// Locate returns the first of stores that holds key, for files that can be on more than one tier
+func Locate(key string, stores ...Storage) (Storage, error) {
+	for _, store := range stores {
+		if _, err := store.Stat(key); err == nil {
+			return store, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+}
+
+// Copy stores a copy of key from one store in another, checking the whole file arrived
+func Copy(from Storage, to Storage, key string) error {
+	info, err := from.Stat(key)
+	if err != nil {
+		return err
+	}
+	src, err := from.Get(key)
+	if err != nil {
+		return err
+	}
+	err = to.Put(key, src)
+	_ = src.Close()
+	if err != nil {
+		return err
+	}
+	copied, err := to.Stat(key)
+	if err != nil {
+		return err
+	}
+	if copied.Size != info.Size {
+		return fmt.Errorf("%s: copied %d of %d bytes", key, copied.Size, info.Size)
+	}
+	return nil
+}
+
+// SameLocation reports whether two stores keep their files in the same place, which would make moving a file
+// between them delete it
+func SameLocation(a Storage, b Storage) bool {
+	localA, okA := a.(LocalStorage)
+	localB, okB := b.(LocalStorage)
+	return okA && okB && filepath.Clean(localA.LocalPath("")) == filepath.Clean(localB.LocalPath(""))
+}
+
+// MoveIn stores a local file as key, removing the local file. Local stores just rename it.
+func MoveIn(store Storage, key string, localPath string) error {
+	if local, ok := store.(LocalStorage); ok {
+		destination := local.LocalPath(key)
+		if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(localPath, destination); err == nil {
+			return nil
+		}
+	}
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	err = store.Put(key, file)
+	_ = file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(localPath)
+}
+
+// LocalFile is a file from a store that ffmpeg can work on, either the stored file itself or a copy in the
+// scratch folder
+type LocalFile struct {
+	Path      string
+	store     Storage
+	key       string
+	temporary bool
+}
+
+// Fetch makes key available as a local file. Release it once it's no longer needed.
+func Fetch(store Storage, key string) (*LocalFile, error) {
+	if local, ok := store.(LocalStorage); ok {
+		return &LocalFile{Path: local.LocalPath(key), store: store, key: key}, nil
+	}
+	file, err := scratchFile(key)
+	if err != nil {
+		return nil, err
+	}
+	src, err := store.Get(key)
+	if err == nil {
+		_, err = io.Copy(file, src)
+		_ = src.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return nil, fmt.Errorf("fetching %s: %w", key, err)
+	}
+	return &LocalFile{Path: file.Name(), store: store, key: key, temporary: true}, nil
+}
+
+// Stage returns a local file to write key to, which is stored once it's committed
+func Stage(store Storage, key string) (*LocalFile, error) {
+	if local, ok := store.(LocalStorage); ok {
+		localPath := local.LocalPath(key)
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			return nil, err
+		}
+		return &LocalFile{Path: localPath, store: store, key: key}, nil
+	}
+	file, err := scratchFile(key)
+	if err != nil {
+		return nil, err
+	}
+	_ = file.Close()
+	return &LocalFile{Path: file.Name(), store: store, key: key, temporary: true}, nil
+}
+
+// StageReplacement is Stage for a file that replaces key while key is still being read, so it's always written
+// to the scratch folder first
+func StageReplacement(store Storage, key string) (*LocalFile, error) {
+	file, err := scratchFile(key)
+	if err != nil {
+		return nil, err
+	}
+	_ = file.Close()
+	return &LocalFile{Path: file.Name(), store: store, key: key, temporary: true}, nil
+}
+
+// Commit stores a staged file
+func (f *LocalFile) Commit() error {
+	if !f.temporary {
+		return nil
+	}
+	if err := MoveIn(f.store, f.key, f.Path); err != nil {
+		return err
+	}
+	f.temporary = false
+	return nil
+}
+
+// Release removes the scratch copy of a fetched file, or a staged file that wasn't committed
+func (f *LocalFile) Release() {
+	if f.temporary {
+		_ = os.Remove(f.Path)
+	}
+}
+
+// scratchFile keeps the key's extension, which ffmpeg goes by
+func scratchFile(key string) (*os.File, error) {
+	if err := os.MkdirAll(config.GetScratchPath(), 0755); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(config.GetScratchPath(), "*-"+path.Base(key))
+}
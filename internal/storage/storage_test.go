@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"ClipsArchiver/internal/config"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStore runs the checks every store has to pass, with keys under a prefix no other run uses
+func testStore(t *testing.T, store Storage) {
+	prefix := "test-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "/"
+	key := prefix + "clip.mp4"
+	content := "not really a video"
+
+	if _, err := store.Stat(key); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Stat() of a missing file = %v, want fs.ErrNotExist", err)
+	}
+	if _, err := store.Get(key); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Get() of a missing file = %v, want fs.ErrNotExist", err)
+	}
+
+	if err := store.Put(key, strings.NewReader(content)); err != nil {
+		t.Fatalf("Put() = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Delete(key) })
+
+	file, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	got, err := io.ReadAll(file)
+	_ = file.Close()
+	if err != nil || string(got) != content {
+		t.Errorf("Get() read %q, %v, want %q", got, err, content)
+	}
+
+	info, err := store.Stat(key)
+	if err != nil {
+		t.Fatalf("Stat() = %v", err)
+	}
+	if info.Key != key || info.Size != int64(len(content)) {
+		t.Errorf("Stat() = %+v, want key %s and size %d", info, key, len(content))
+	}
+
+	// putting a file again replaces it
+	if err = store.Put(key, strings.NewReader(content+content)); err != nil {
+		t.Fatalf("Put() over an existing file = %v", err)
+	}
+	if info, err = store.Stat(key); err != nil || info.Size != int64(2*len(content)) {
+		t.Errorf("Stat() after replacing = %+v, %v, want size %d", info, err, 2*len(content))
+	}
+
+	otherKey := prefix + "nested/other.mp4"
+	if err = store.Put(otherKey, strings.NewReader(content)); err != nil {
+		t.Fatalf("Put() = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Delete(otherKey) })
+	objects, err := store.List(prefix)
+	if err != nil {
+		t.Fatalf("List() = %v", err)
+	}
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	slices.Sort(keys)
+	if want := []string{key, otherKey}; !slices.Equal(keys, want) {
+		t.Errorf("List(%s) = %v, want %v", prefix, keys, want)
+	}
+	if objects, err = store.List(prefix + "nested/"); err != nil || len(objects) != 1 {
+		t.Errorf("List(%snested/) = %v, %v, want only %s", prefix, objects, err, otherKey)
+	}
+
+	if err = store.Delete(key); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	if _, err = store.Stat(key); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() after Delete() = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	testStore(t, NewLocal(t.TempDir()))
+}
+
+func TestLocalListOfMissingFolder(t *testing.T) {
+	objects, err := NewLocal(filepath.Join(t.TempDir(), "missing")).List("")
+	if err != nil || len(objects) != 0 {
+		t.Errorf("List() = %v, %v, want nothing", objects, err)
+	}
+}
+
+func TestLocalListSkipsHiddenFiles(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, ".put-123"), []byte("half written"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := NewLocal(root).List("")
+	if err != nil || len(objects) != 0 {
+		t.Errorf("List() = %v, %v, want hidden files left out", objects, err)
+	}
+}
+
+func TestLocalPathStaysInRoot(t *testing.T) {
+	root := t.TempDir()
+	store := NewLocal(root)
+	for _, key := range []string{"../outside.mp4", "/../../outside.mp4", "a/../../outside.mp4"} {
+		if got := store.LocalPath(key); !strings.HasPrefix(got, root+string(filepath.Separator)) {
+			t.Errorf("LocalPath(%s) = %s, want a path under %s", key, got, root)
+		}
+	}
+}
+
+func TestLocalPresign(t *testing.T) {
+	if _, err := NewLocal(t.TempDir()).Presign("clip.mp4", time.Minute, ""); !errors.Is(err, ErrPresignUnsupported) {
+		t.Errorf("Presign() = %v, want ErrPresignUnsupported", err)
+	}
+}
+
+// s3TestBucket reads the bucket to test against from the environment, such as a local MinIO. The s3 tests are
+// skipped when CLIPS_TEST_S3_ENDPOINT isn't set.
+func s3TestBucket(t *testing.T) config.S3Config {
+	endpoint := os.Getenv("CLIPS_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("CLIPS_TEST_S3_ENDPOINT isn't set")
+	}
+	return config.S3Config{
+		Endpoint:        endpoint,
+		Region:          os.Getenv("CLIPS_TEST_S3_REGION"),
+		Bucket:          os.Getenv("CLIPS_TEST_S3_BUCKET"),
+		AccessKeyId:     os.Getenv("CLIPS_TEST_S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("CLIPS_TEST_S3_SECRET_ACCESS_KEY"),
+		UsePathStyle:    true,
+	}
+}
+
+func TestS3(t *testing.T) {
+	store, err := NewS3(s3TestBucket(t), clipsName+"/")
+	if err != nil {
+		t.Fatalf("NewS3() = %v", err)
+	}
+	testStore(t, store)
+}
+
+func TestS3Presign(t *testing.T) {
+	store, err := NewS3(s3TestBucket(t), clipsName+"/")
+	if err != nil {
+		t.Fatalf("NewS3() = %v", err)
+	}
+	key := "test-" + strconv.FormatInt(time.Now().UnixNano(), 36) + ".mp4"
+	content := "not really a video"
+	if err = store.Put(key, strings.NewReader(content)); err != nil {
+		t.Fatalf("Put() = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Delete(key) })
+
+	url, err := store.Presign(key, time.Minute, "my clip.mp4")
+	if err != nil {
+		t.Fatalf("Presign() = %v", err)
+	}
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET presigned url = %v", err)
+	}
+	defer response.Body.Close()
+	got, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK || string(got) != content {
+		t.Errorf("GET presigned url = %d %q, want 200 %q", response.StatusCode, got, content)
+	}
+	if disposition := response.Header.Get("Content-Disposition"); !strings.Contains(disposition, `filename="my clip.mp4"`) {
+		t.Errorf("Content-Disposition = %q, want the download name", disposition)
+	}
+}
+
+func TestS3WithoutBucket(t *testing.T) {
+	if _, err := NewS3(config.S3Config{}, clipsName+"/"); err == nil {
+		t.Error("NewS3() without a bucket = nil, want an error")
+	}
+}